@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/nir/ai.go/internal/alias"
+)
+
+// runAlias manages the query aliases in ~/.ai/aliases.json
+func runAlias(opts *options, args []string) int {
+	usage := func() int {
+		fmt.Fprintln(os.Stderr, "Usage: ai alias list")
+		fmt.Fprintln(os.Stderr, "       ai alias add <name> <query template>")
+		fmt.Fprintln(os.Stderr, "       ai alias remove <name>")
+		return 2
+	}
+	if len(args) == 0 {
+		return usage()
+	}
+
+	aliases, err := alias.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load aliases: %v\n", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "list":
+		if len(aliases) == 0 {
+			fmt.Println("No aliases defined. Add one with: ai alias add <name> <query template>")
+			return 0
+		}
+		names := make([]string, 0, len(aliases))
+		for name := range aliases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%s%s%s\t%s\n", colorSuccess, name, colorReset, aliases[name])
+		}
+		return 0
+
+	case "add":
+		if len(args) < 3 {
+			return usage()
+		}
+		name, queryTemplate := args[1], strings.Join(args[2:], " ")
+		if err := alias.ValidateName(name); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		// Subcommands are dispatched first, so an alias with the same name could never be used
+		if _, ok := subcommands[name]; ok || name == runJSONCommand {
+			fmt.Fprintf(os.Stderr, "%q is a subcommand and can't be used as an alias name\n", name)
+			return 2
+		}
+		if err := alias.ValidateTemplate(queryTemplate); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		aliases[name] = queryTemplate
+
+	case "remove":
+		if len(args) != 2 {
+			return usage()
+		}
+		if _, ok := aliases[args[1]]; !ok {
+			fmt.Fprintf(os.Stderr, "No alias named %q\n", args[1])
+			return 1
+		}
+		delete(aliases, args[1])
+
+	default:
+		return usage()
+	}
+
+	if err := alias.Save(aliases); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save aliases: %v\n", err)
+		return 1
+	}
+	return 0
+}
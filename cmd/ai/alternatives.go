@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/nir/ai.go/internal/clientopts"
+	"github.com/nir/ai.go/internal/command"
+	"github.com/nir/ai.go/internal/session"
+)
+
+// alternative is one of the suggestions requested with --count
+type alternative struct {
+	response string
+	cmd      *command.Command
+}
+
+// fetchAlternatives asks for count suggestions in parallel and returns the distinct commands among them
+// Each request gets a higher temperature than the previous one, see clientopts.WithAlternative, so they don't
+// all return the same command at a low temperature. Responses that fail or can't be parsed are left out,
+// an error is only returned if none succeeded.
+func fetchAlternatives(ctx context.Context, client Client, turns []session.Message, userQuery, currentDir string, files []string, commandHistory string, count int) ([]alternative, error) {
+	responses := make([]string, count)
+	errs := make([]error, count)
+
+	var wg sync.WaitGroup
+	for i := range count {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i], errs[i] = client.GetCommandSuggestion(clientopts.WithAlternative(ctx, i, count), turns, userQuery, currentDir, files, commandHistory)
+		}()
+	}
+	wg.Wait()
+
+	var alternatives []alternative
+	seen := map[string]bool{}
+	var firstErr error
+	for i, response := range responses {
+		if errs[i] != nil {
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+			continue
+		}
+		cmd, err := command.ParseCommandResponse(response)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if seen[cmd.Command] {
+			continue
+		}
+		seen[cmd.Command] = true
+		alternatives = append(alternatives, alternative{response: response, cmd: cmd})
+	}
+
+	if len(alternatives) == 0 {
+		return nil, firstErr
+	}
+	return alternatives, nil
+}
+
+// chooseAlternative lists the alternatives and lets the user pick one, the first one is the default
+// requested is the number of alternatives asked for, the user is told when fewer distinct commands came back.
+func chooseAlternative(alternatives []alternative, requested int) alternative {
+	if len(alternatives) < requested {
+		fmt.Printf("%s💡 Only %d of the %d suggestions were distinct commands, the others were duplicates or failed.%s\n", colorWarning, len(alternatives), requested, colorReset)
+	}
+	if len(alternatives) == 1 {
+		return alternatives[0]
+	}
+
+	fmt.Printf("\n%s💡 %d alternatives:%s\n", colorSuccess, len(alternatives), colorReset)
+	for i, alt := range alternatives {
+		fmt.Printf("%d. %s%s%s\n", i+1, colorCommand, alt.cmd.Command, colorReset)
+		fmt.Printf("   %s (%s)\n", alt.cmd.Reason, getSafetyText(alt.cmd.Safe))
+	}
+
+	for {
+		answer := readLine(fmt.Sprintf("Choose a command (1-%d, Enter for 1): ", len(alternatives)))
+		if answer == "" {
+			return alternatives[0]
+		}
+		if choice, err := strconv.Atoi(answer); err == nil && choice >= 1 && choice <= len(alternatives) {
+			return alternatives[choice-1]
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// consoleFlushInterval is how often buffered command output is written to the console
+const consoleFlushInterval = 50 * time.Millisecond
+
+// consoleBufferBytes is the size of the console buffer, a full buffer is written right away
+const consoleBufferBytes = 64 * 1024
+
+// consoleWriter prints the output of a running command
+// Unless it is unbuffered, output is collected and written every consoleFlushInterval,
+// so commands printing thousands of lines don't cost a write per line
+type consoleWriter struct {
+	out io.Writer
+
+	mutex  sync.Mutex
+	buffer *bufio.Writer // nil when unbuffered
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// newConsoleWriter creates a writer printing to out, Close must be called once the command has finished
+func newConsoleWriter(out io.Writer, unbuffered bool) *consoleWriter {
+	w := &consoleWriter{out: out}
+	if unbuffered {
+		return w
+	}
+
+	w.buffer = bufio.NewWriterSize(out, consoleBufferBytes)
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(consoleFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				w.Flush()
+			}
+		}
+	}()
+	return w
+}
+
+// Print writes text to the console, or to the buffer
+func (w *consoleWriter) Print(text string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.buffer == nil {
+		fmt.Fprint(w.out, text)
+		return
+	}
+	w.buffer.WriteString(text)
+}
+
+// Flush writes the buffered output to the console
+func (w *consoleWriter) Flush() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.buffer != nil {
+		w.buffer.Flush()
+	}
+}
+
+// Close stops the periodic flushing and writes what is left in the buffer
+func (w *consoleWriter) Close() {
+	if w.buffer != nil {
+		close(w.stop)
+		<-w.done
+	}
+	w.Flush()
+}
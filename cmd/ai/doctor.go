@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nir/ai.go/internal/config"
+	"github.com/nir/ai.go/internal/httpheaders"
+)
+
+// doctorPingTimeout bounds the connectivity check
+const doctorPingTimeout = 30 * time.Second
+
+// headerLister is implemented by clients that can add custom headers to their requests
+type headerLister interface {
+	Headers() map[string]string
+}
+
+// doctorReport prints check results and remembers whether any check failed
+type doctorReport struct {
+	failed bool
+}
+
+// pass reports a successful check
+func (r *doctorReport) pass(name, detail string) {
+	fmt.Printf("%s✅ %s:%s %s\n", colorSuccess, name, colorReset, detail)
+}
+
+// warn reports a check that didn't fail but may explain unexpected behavior
+func (r *doctorReport) warn(name, detail string) {
+	fmt.Printf("%s⚠️  %s:%s %s\n", colorWarning, name, colorReset, detail)
+}
+
+// fail reports a failed check
+func (r *doctorReport) fail(name, detail string) {
+	r.failed = true
+	fmt.Printf("%s❌ %s:%s %s\n", colorError, name, colorReset, detail)
+}
+
+// runDoctor checks the configuration and environment and reports what ai would do with them
+func runDoctor(opts *options, args []string) int {
+	report := &doctorReport{}
+
+	// Configuration directory
+	aiDir, err := config.Dir()
+	if err != nil {
+		report.fail("Config directory", err.Error())
+	} else {
+		report.pass("Config directory", aiDir+" is writable")
+
+		// Configuration files
+		for _, file := range configFiles {
+			if _, err := os.Stat(filepath.Join(aiDir, file)); err == nil {
+				report.pass("Config file", file+" found")
+			} else {
+				fmt.Printf("   Config file: %s not found\n", file)
+			}
+		}
+
+		if _, err := config.Load(); err != nil {
+			report.fail("General settings", err.Error())
+		}
+	}
+
+	// Environment variables, values are never printed as they may be secrets
+	for _, name := range []string{"ANTHROPIC_API_KEY", "AWS_PROFILE", "AWS_REGION", "AWS_ACCESS_KEY_ID", "AI_PROVIDER", "AI_CONFIG_DIR", "AI_MODEL_ID", "AI_REGION", "AI_PROFILE", "AI_ENDPOINT", "AI_TEMPERATURE", "AI_MAX_TOKENS", "NO_COLOR"} {
+		if os.Getenv(name) != "" {
+			fmt.Printf("   Environment: %s is set\n", name)
+		} else {
+			fmt.Printf("   Environment: %s is not set\n", name)
+		}
+	}
+
+	// Shell interpreter used to run commands
+	if path, err := exec.LookPath("bash"); err != nil {
+		report.fail("Shell", "bash not found, commands can't be run")
+	} else {
+		report.pass("Shell", path)
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		report.warn("Git", "git not found, --git-context and --git-log have no effect")
+	}
+
+	// Provider selection, using the same logic as a regular run
+	client, reason, err := selectClient(func(err error) {
+		report.warn("Provider fallback", err.Error())
+	})
+	if err != nil {
+		report.fail("Provider", err.Error())
+	} else {
+		report.pass("Provider", fmt.Sprintf("%s, model %s", reason, client.Model()))
+
+		// Custom headers, with the values of secrets masked
+		if h, ok := client.(headerLister); ok {
+			for _, header := range httpheaders.Redacted(h.Headers()) {
+				fmt.Printf("   Custom header: %s\n", header)
+			}
+		}
+
+		// Connectivity to the selected provider
+		ctx, cancel := context.WithTimeout(context.Background(), doctorPingTimeout)
+		start := time.Now()
+		err := client.Ping(ctx)
+		cancel()
+		if err != nil {
+			report.fail("Connectivity", err.Error())
+			if hint := connectivityHint(err); hint != "" {
+				fmt.Printf("   %s\n", hint)
+			}
+		} else {
+			report.pass("Connectivity", fmt.Sprintf("provider responded in %s", time.Since(start).Round(time.Millisecond)))
+		}
+	}
+
+	if report.failed {
+		fmt.Printf("\n%sSome checks failed.%s\n", colorError, colorReset)
+		return 1
+	}
+	fmt.Printf("\n%sAll checks passed.%s\n", colorSuccess, colorReset)
+	return 0
+}
+
+// connectivityHint suggests what to check for a failed connectivity check, or returns "" if the cause isn't recognized
+func connectivityHint(err error) string {
+	message := err.Error()
+	has := func(parts ...string) bool {
+		for _, part := range parts {
+			if strings.Contains(message, part) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case has("status 401", "status 403", "UnrecognizedClientException", "InvalidSignatureException", "ExpiredToken", "AccessDeniedException", "no valid credential"):
+		return "The credentials were rejected or are missing: check the API key (or the environment variable named by api_key_env), or your AWS credentials and profile."
+	case has("status 404", "ResourceNotFoundException", "model identifier is invalid"):
+		return "The model wasn't found: check model_id in the provider config, AI_MODEL_ID, --model and the directory's .ai.json."
+	case has("connection refused"):
+		return "Nothing is listening at the configured address: is the server running, and are base_url or endpoint right?"
+	case has("no such host"):
+		return "The host name couldn't be resolved: check base_url or endpoint and your network."
+	case errors.Is(err, context.DeadlineExceeded) || has("Client.Timeout", "i/o timeout"):
+		return "The provider didn't respond in time: check your network, proxy settings and base_url or endpoint."
+	case has("status 429", "ThrottlingException"):
+		return "The provider is rate limiting requests, the credentials work but try again later."
+	}
+	return ""
+}
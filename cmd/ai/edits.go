@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nir/ai.go/internal/logger"
+	"github.com/nir/ai.go/internal/shell"
+)
+
+// maxEditDiffLines caps the diff shown for each file changed with --confirm-edits
+const maxEditDiffLines = 40
+
+// backupEdits backs up the existing files a command writes to, for --confirm-edits
+// It returns a nil backup when there is nothing to back up, and false if the user cancelled the command
+func backupEdits(cmd, dir string, log *logger.Logger) (*shell.Backup, bool) {
+	targets := shell.EditTargets(cmd, dir)
+	if len(targets) == 0 {
+		log.LogInfo("The command doesn't seem to write to existing files, nothing to back up")
+		return nil, true
+	}
+
+	backup, err := shell.NewBackup(targets)
+	if err != nil {
+		log.LogError(err)
+		fmt.Printf("%s📝 The files the command writes to can't be backed up: %v%s\n", colorWarning, err, colorReset)
+		return nil, confirm("Run it without a backup? (y/n): ")
+	}
+	log.LogInfo(fmt.Sprintf("Backed up %s before running the command", strings.Join(targets, ", ")))
+	fmt.Printf("%s📝 Backed up %d files the command writes to, you can keep or revert its changes to each of them afterwards.%s\n", colorInfo, len(targets), colorReset)
+	return backup, true
+}
+
+// reviewEdits shows the changes a command made to the backed up files and asks whether to keep each of them,
+// restoring the backup of those the user rejects. The backup is removed afterwards.
+// It returns a note telling Claude which changes were reverted, if any.
+func reviewEdits(backup *shell.Backup, log *logger.Logger) string {
+	defer func() {
+		if err := backup.Close(); err != nil {
+			log.LogError(err)
+		}
+	}()
+
+	changed, err := backup.Changed()
+	if err != nil {
+		log.LogError(err)
+		return ""
+	}
+	if len(changed) == 0 {
+		fmt.Println("The command didn't change the backed up files.")
+		return ""
+	}
+
+	var reverted []string
+	for _, path := range changed {
+		fmt.Printf("\n%s📝 The command changed %s:%s\n", colorHighlight, path, colorReset)
+		fmt.Print(backup.Diff(path, maxEditDiffLines))
+		if confirm(fmt.Sprintf("Keep the changes to %s? (y/n): ", path)) {
+			log.LogInfo(fmt.Sprintf("Kept the changes to %s", path))
+			continue
+		}
+
+		if err := backup.Restore(path); err != nil {
+			log.LogError(err)
+			fmt.Printf("%s⚠️ %s couldn't be reverted: %v%s\n", colorWarning, path, err, colorReset)
+			continue
+		}
+		reverted = append(reverted, path)
+		log.LogInfo(fmt.Sprintf("Reverted the changes to %s", path))
+		fmt.Printf("%s↩️  Reverted %s.%s\n", colorSuccess, path, colorReset)
+	}
+
+	if len(reverted) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("\n[The user reverted the command's changes to %s, so they are as they were before it ran]\n", strings.Join(reverted, ", "))
+}
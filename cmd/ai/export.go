@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nir/ai.go/internal/command"
+	"github.com/nir/ai.go/internal/session"
+)
+
+// runExport prints a stored session as a Markdown transcript or as JSON
+func runExport(opts *options, args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	sessionID := flags.String("session-id", opts.sessionID, "ID of the session to export")
+	format := flags.String("format", "md", "Output format: md or json")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: ai export --session-id <id> [--format md|json]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *sessionID == "" {
+		flags.Usage()
+		return 2
+	}
+
+	sess, err := session.Load(*sessionID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load session: %v\n", err)
+		return 1
+	}
+	if len(sess.Messages) == 0 {
+		fmt.Fprintf(os.Stderr, "Session %s not found or empty\n", *sessionID)
+		return 1
+	}
+
+	switch *format {
+	case "json":
+		data, err := json.MarshalIndent(sess, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to marshal session: %v\n", err)
+			return 1
+		}
+		fmt.Println(string(data))
+	case "md", "markdown":
+		fmt.Print(renderMarkdown(sess))
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown format %q, expected md or json\n", *format)
+		return 2
+	}
+	return 0
+}
+
+// renderMarkdown renders a session as a readable Markdown transcript
+func renderMarkdown(sess *session.Session) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# Session %s\n\n", sess.ID)
+	fmt.Fprintf(&b, "Started %s, last updated %s\n", sess.CreatedAt.Format(time.DateTime), sess.UpdatedAt.Format(time.DateTime))
+
+	for _, message := range sess.Messages {
+		switch message.Role {
+		case "assistant":
+			b.WriteString("\n## Claude\n\n")
+			// Suggestions are JSON, show them as fields when they can be parsed
+			cmd, err := command.ParseCommandResponse(message.Content)
+			if err != nil {
+				fmt.Fprintf(&b, "```\n%s\n```\n", strings.TrimSpace(message.Content))
+				continue
+			}
+			if cmd.Clarification != "" && strings.TrimSpace(cmd.Command) == "" {
+				fmt.Fprintf(&b, "%s\n", cmd.Clarification)
+				continue
+			}
+			fmt.Fprintf(&b, "```sh\n%s\n```\n\n", cmd.Command)
+			fmt.Fprintf(&b, "- Reason: %s\n", cmd.Reason)
+			fmt.Fprintf(&b, "- Safe: %t\n", cmd.Safe)
+			fmt.Fprintf(&b, "- Final: %t\n", cmd.IsFinal)
+			fmt.Fprintf(&b, "- Needs output: %t\n", cmd.NeedsOutput)
+			if len(cmd.SideEffects) > 0 {
+				fmt.Fprintf(&b, "- Side effects: %s\n", strings.Join(cmd.SideEffects, "; "))
+			}
+		default:
+			b.WriteString("\n## User\n\n")
+			// Follow-up messages contain command output, which is kept verbatim
+			content := strings.TrimSpace(message.Content)
+			if strings.Contains(content, "\n") {
+				fmt.Fprintf(&b, "```\n%s\n```\n", content)
+			} else {
+				fmt.Fprintf(&b, "%s\n", content)
+			}
+		}
+	}
+
+	// Software recorded with --record-installs, so it can be cleaned up
+	if len(sess.Installs) > 0 {
+		b.WriteString("\n## Installed software\n\n")
+		for _, install := range sess.Installs {
+			packages := strings.Join(install.Packages, " ")
+			if packages == "" {
+				packages = "dependencies"
+			}
+			fmt.Fprintf(&b, "- %s (%s), %s: `%s`\n", packages, install.Manager, install.Time.Format(time.DateTime), install.Command)
+		}
+	}
+
+	return b.String()
+}
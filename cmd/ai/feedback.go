@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/nir/ai.go/internal/command"
+	"github.com/nir/ai.go/internal/logger"
+	"github.com/nir/ai.go/internal/shell"
+)
+
+// feedbackChunkBytes is how much new output a running command produces before it is sent to Claude with --stream-feedback
+const feedbackChunkBytes = 4 * 1024
+
+// outputReviewer sends the output of a running command to Claude in chunks and stops the command if Claude says so
+// Only one review is in flight at a time, output produced meanwhile is sent with the next one
+type outputReviewer struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	client  Client
+	sh      *shell.Shell
+	log     *logger.Logger
+	request string
+	command string
+
+	mutex     sync.Mutex
+	chunk     strings.Builder // Output since the last review
+	reviewing bool
+	stopped   string // Claude's reason for stopping the command
+	reviews   sync.WaitGroup
+}
+
+// newOutputReviewer creates a reviewer for a command run for the user's request
+func newOutputReviewer(ctx context.Context, client Client, sh *shell.Shell, log *logger.Logger, request, cmd string) *outputReviewer {
+	ctx, cancel := context.WithCancel(ctx)
+	return &outputReviewer{ctx: ctx, cancel: cancel, client: client, sh: sh, log: log, request: request, command: cmd}
+}
+
+// add records a line of output, sending the pending output for review once there is enough of it
+func (r *outputReviewer) add(line string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.stopped != "" {
+		return
+	}
+	r.chunk.WriteString(line)
+	if r.reviewing || r.chunk.Len() < feedbackChunkBytes {
+		return
+	}
+
+	chunk := r.chunk.String()
+	r.chunk.Reset()
+	r.reviewing = true
+	r.reviews.Add(1)
+	go r.review(chunk)
+}
+
+// review asks Claude about a chunk of output and stops the command if Claude wants to abort it
+func (r *outputReviewer) review(chunk string) {
+	defer r.reviews.Done()
+
+	response, err := r.client.ReviewOutput(r.ctx, r.request, r.command, truncateOutput(stripANSI(chunk), maxSummaryBytes))
+	var feedback *command.Feedback
+	if err == nil {
+		feedback, err = command.ParseFeedback(response)
+	}
+
+	r.mutex.Lock()
+	r.reviewing = false
+	if err != nil || !feedback.Abort {
+		r.mutex.Unlock()
+		switch {
+		case errors.Is(err, context.Canceled):
+			// The command finished first
+		case err != nil:
+			r.log.LogError(fmt.Errorf("failed to review command output: %w", err))
+		default:
+			r.log.LogInfo(fmt.Sprintf("Output review: continue (%s)", feedback.Reason))
+		}
+		return
+	}
+	r.stopped = feedback.Reason
+	if r.stopped == "" {
+		r.stopped = "no reason given"
+	}
+	r.mutex.Unlock()
+
+	r.log.LogInfo(fmt.Sprintf("Output review: stopping the command (%s)", r.stopped))
+	fmt.Printf("\n%s🛑 Claude is stopping the command: %s%s\n", colorWarning, r.stopped, colorReset)
+	r.sh.Signal(os.Interrupt, signalGracePeriod)
+}
+
+// finish cancels a review in flight once the command has exited
+// It returns Claude's reason for stopping the command, or "" if it ran to completion
+func (r *outputReviewer) finish() string {
+	r.cancel()
+	r.reviews.Wait()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.stopped
+}
@@ -2,30 +2,62 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/charmbracelet/bubbles/spinner"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/nir/ai.go/internal/alias"
 	"github.com/nir/ai.go/internal/anthropic"
+	"github.com/nir/ai.go/internal/audit"
 	"github.com/nir/ai.go/internal/aws"
+	"github.com/nir/ai.go/internal/clientopts"
+	"github.com/nir/ai.go/internal/command"
+	"github.com/nir/ai.go/internal/config"
 	"github.com/nir/ai.go/internal/logger"
+	"github.com/nir/ai.go/internal/openaicompat"
+	"github.com/nir/ai.go/internal/prompt"
+	"github.com/nir/ai.go/internal/requestid"
+	"github.com/nir/ai.go/internal/secretscan"
+	"github.com/nir/ai.go/internal/session"
 	"github.com/nir/ai.go/internal/shell"
+	"github.com/nir/ai.go/internal/theme"
 )
 
 const (
-	maxFiles = 1000
-
-	// ANSI color codes
-	colorRed    = "\033[31m"
-	colorGreen  = "\033[32m"
-	colorYellow = "\033[33m"
-	colorBlue   = "\033[34m"
-	colorReset  = "\033[0m"
+	// Default maximum number of files listed in the prompt
+	defaultMaxFiles = 1000
+	// Maximum depth of the directory tree shown with --tree
+	maxTreeDepth = 4
+	// Maximum number of output bytes sent to the model for summarization or a failure diagnosis
+	maxSummaryBytes = 16 * 1024
+	// Number of times Claude may suggest a command that already failed before ai gives up, the first time it is asked for another approach
+	maxRepeatedFailures = 2
+	// How long ai waits on exit for audit events that weren't posted yet
+	auditCloseTimeout = 5 * time.Second
+)
+
+// palette is the console theme, the colors below are its ANSI color codes, see applyTheme
+var (
+	palette        theme.Palette
+	colorCommand   string
+	colorError     string
+	colorSuccess   string
+	colorWarning   string
+	colorInfo      string
+	colorHighlight string
+	colorReset     string
 )
 
 // Model represents the application state
@@ -34,6 +66,7 @@ type Model struct {
 	response string
 	err      error
 	done     bool
+	cancel   context.CancelFunc // Cancels the in-flight request when the user quits
 }
 
 // Init initializes the model
@@ -47,6 +80,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyMsg:
 		switch msg.String() {
 		case "ctrl+c", "q":
+			if m.cancel != nil {
+				m.cancel()
+			}
 			return m, tea.Quit
 		}
 	case error:
@@ -88,29 +124,49 @@ const (
 
 // Client interface defines methods that both clients must implement
 type Client interface {
-	GetCommandSuggestion(ctx context.Context, userQuery, currentDir string, filesList []string, commandHistory string) (string, error)
+	GetCommandSuggestion(ctx context.Context, turns []session.Message, userQuery, currentDir string, filesList []string, commandHistory string) (string, error)
+	StreamCommandSuggestion(ctx context.Context, turns []session.Message, userQuery, currentDir string, filesList []string, commandHistory string, onText func(text string)) (string, error)
+	Summarize(ctx context.Context, output string) (string, error)
+	ReviewOutput(ctx context.Context, request, cmd, output string) (string, error)
+	ExplainCommand(ctx context.Context, cmd string) (string, error)
+	DiagnoseError(ctx context.Context, cmd, output string, exitCode int) (string, error)
+	Model() string
+	// Ping checks that the provider is reachable and accepts the credentials, with a request as cheap as the provider allows
+	Ping(ctx context.Context) error
+	// Configure changes the options all providers support, such as the prompt additions or the temperature
+	Configure(update func(options *clientopts.Options))
+	// SystemPrompt returns the system prompt and earlier turns of a command suggestion request as they are sent
+	SystemPrompt(turns []session.Message, userQuery, currentDir string, filesList []string, commandHistory string) (string, []session.Message)
 }
 
 // waitWithSpinner runs a spinner while waiting for Claude's response
-func waitWithSpinner(ctx context.Context, client Client, query, currentDir string, files []string, commandHistory string) (string, error) {
+func waitWithSpinner(ctx context.Context, call func(ctx context.Context) (string, error)) (string, error) {
+	// Allow the user to abort the request from the spinner
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	// Initialize spinner model
 	s := spinner.New()
-	s.Spinner = spinner.Dot
-	s.Style = lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
+	s.Spinner = palette.Spinner
+	s.Style = lipgloss.NewStyle().Bold(palette.SpinnerBold)
+	if palette.SpinnerColor != "" {
+		s.Style = s.Style.Foreground(lipgloss.Color(palette.SpinnerColor))
+	}
 
 	// Create initial model
 	m := Model{
 		spinner: s,
+		cancel:  cancel,
 	}
 
-	// Create a channel for the response
-	responseChan := make(chan string)
-	errChan := make(chan error)
+	// Create a channel for the response, buffered so late senders never block
+	responseChan := make(chan string, 1)
+	errChan := make(chan error, 2)
 	done := make(chan struct{})
 
 	// Run the API call in a goroutine
 	go func() {
-		response, err := client.GetCommandSuggestion(ctx, query, currentDir, files, commandHistory)
+		response, err := call(ctx)
 		if err != nil {
 			errChan <- err
 		} else {
@@ -120,7 +176,8 @@ func waitWithSpinner(ctx context.Context, client Client, query, currentDir strin
 	}()
 
 	// Create bubbletea program without alternate screen to avoid terminal state issues
-	p := tea.NewProgram(m)
+	// Its default output is the original stdout, which --command-only, --json and --script reserve for their result
+	p := tea.NewProgram(m, tea.WithOutput(os.Stdout))
 
 	// Start the program
 	go func() {
@@ -160,7 +217,7 @@ func waitWithSpinner(ctx context.Context, client Client, query, currentDir strin
 
 	// Reset the terminal using stty
 	sh := shell.New(nil)
-	sh.StreamCommand("stty sane", func(line string) {})
+	sh.StreamCommand(context.Background(), "stty sane", func(line string) {})
 
 	if resultErr != nil {
 		return "", resultErr
@@ -170,48 +227,149 @@ func waitWithSpinner(ctx context.Context, client Client, query, currentDir strin
 
 // getClient initializes the appropriate client based on the config
 func getClient(log *logger.Logger) (Client, error) {
+	client, reason, err := selectClient(log.LogError)
+	if err != nil {
+		return nil, err
+	}
+
+	log.LogInfo("Using " + reason)
+	return client, nil
+}
+
+// servedModelLogger returns a served model handler logging the model version behind each response,
+// as providers may update the snapshot behind a model alias without notice
+func servedModelLogger(log *logger.Logger) func(model, fingerprint string) {
+	return func(model, fingerprint string) {
+		message := "Served by model " + model
+		if fingerprint != "" {
+			message += fmt.Sprintf(" (system fingerprint %s)", fingerprint)
+		}
+		log.LogInfo(message)
+	}
+}
+
+// applyTemperatureProfile sets the temperature of the profile selected by --profile or ai.cfg, if any
+func applyTemperatureProfile(client Client, opts *options, cfg *config.Config, log *logger.Logger) error {
+	name := cfg.TemperatureProfile
+	if opts.profile != "" {
+		name = opts.profile
+	}
+	if name == "" {
+		return nil
+	}
+	temperature, err := cfg.ProfileTemperature(name)
+	if err != nil {
+		return err
+	}
+	client.Configure(func(clientOptions *clientopts.Options) {
+		clientOptions.Temperature = &temperature
+	})
+	log.LogInfo(fmt.Sprintf("Using temperature profile %s (%g)", name, temperature))
+	return nil
+}
+
+// selectClient picks and initializes the client to use, returning why it was picked
+// Providers that were tried but failed to initialize are reported to onFallback before moving on
+func selectClient(onFallback func(err error)) (Client, string, error) {
+	// A provider chosen explicitly skips the detection below
+	switch provider := os.Getenv("AI_PROVIDER"); provider {
+	case "":
+	case "openai-compatible":
+		compatClient, err := openaicompat.NewOpenAICompatClient(openaicompat.ConfigFile)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to initialize OpenAI-compatible client: %w", err)
+		}
+		return compatClient, "OpenAI-compatible API client (from AI_PROVIDER)", nil
+	default:
+		return nil, "", fmt.Errorf("unknown AI_PROVIDER %q, expected openai-compatible", provider)
+	}
+
+	// A local server is only used when local.cfg was created by hand, so it takes precedence
+	if openaicompat.Configured(openaicompat.LocalConfigFile) {
+		localClient, err := openaicompat.NewOpenAICompatClient(openaicompat.LocalConfigFile)
+		if err == nil {
+			return localClient, "local OpenAI-compatible server (from local.cfg)", nil
+		}
+		// If there was an error initializing the local client, report it and try the other providers
+		onFallback(fmt.Errorf("failed to initialize local client: %w", err))
+	}
+
 	// Check if API key is set directly, use Anthropic client if it is
 	apiKey := os.Getenv("ANTHROPIC_API_KEY")
 	if apiKey != "" {
 		// If ANTHROPIC_API_KEY environment variable is set, try to use the Anthropic client
 		anthropicClient, err := anthropic.NewAnthropicClient()
 		if err == nil {
-			log.LogInfo("Using Anthropic API client (from environment variable)")
-			return anthropicClient, nil
+			return anthropicClient, "Anthropic API client (from environment variable)", nil
 		}
-		// If there was an error initializing the Anthropic client, log it and try AWS
-		log.LogError(fmt.Errorf("failed to initialize Anthropic client with env var: %w", err))
+		// If there was an error initializing the Anthropic client, report it and try AWS
+		onFallback(fmt.Errorf("failed to initialize Anthropic client with env var: %w", err))
 	}
 
 	// Check if Anthropic API key exists in config
-	homeDir, err := os.UserHomeDir()
+	aiDir, err := config.Dir()
 	if err == nil {
-		configPath := filepath.Join(homeDir, ".ai", "anthropic.cfg")
+		configPath := filepath.Join(aiDir, "anthropic.cfg")
 		if _, err := os.Stat(configPath); err == nil {
 			// Config exists, try to use the Anthropic client
 			anthropicClient, err := anthropic.NewAnthropicClient()
 			if err == nil {
-				log.LogInfo("Using Anthropic API client (from config file)")
-				return anthropicClient, nil
+				return anthropicClient, "Anthropic API client (from config file)", nil
 			}
-			// If there was an error initializing the Anthropic client, log it and try AWS
-			log.LogError(fmt.Errorf("failed to initialize Anthropic client with config: %w", err))
+			// If there was an error initializing the Anthropic client, report it and try AWS
+			onFallback(fmt.Errorf("failed to initialize Anthropic client with config: %w", err))
 		}
 	}
 
 	// Otherwise, use AWS client
 	awsClient, err := aws.NewBedrockClient()
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize AWS client: %w", err)
+		return nil, "", fmt.Errorf("failed to initialize AWS client: %w", err)
 	}
 
-	log.LogInfo("Using AWS Bedrock client")
-	return awsClient, nil
+	return awsClient, "AWS Bedrock client", nil
 }
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: ai \"what you want to do\"")
+	opts, args := parseOptions()
+	if err := applyTheme(opts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if len(args) < 1 && !opts.resumeFromLog {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	// Run a subcommand (e.g. "ai schema") instead of a query if one was given
+	if len(args) > 0 {
+		if exitCode, handled := runSubcommand(opts, args); handled {
+			os.Exit(exitCode)
+		}
+	}
+
+	// ai run-json runs a suggestion from stdin, the model is replaced by a client replaying it
+	var replay *replayClient
+	if len(args) > 0 && args[0] == runJSONCommand {
+		if err := checkRunJSON(opts, args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		var suggestion *command.Command
+		var err error
+		replay, suggestion, err = readRunJSON(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read the suggestion: %v\n", err)
+			os.Exit(1)
+		}
+		reattachTerminal()
+		args = []string{"Run this command: " + suggestion.Command}
+	}
+
+	// With --stdin-file or --stdin-string, executed commands get the input on stdin, read now as it may come from ai's own stdin
+	commandInput, hasCommandInput, err := readCommandInput(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 
@@ -219,8 +377,32 @@ func main() {
 	executableName := filepath.Base(os.Args[0])
 	askModeOnly := executableName == "ask"
 
-	// Combine all arguments as the user query
-	userQuery := strings.Join(os.Args[1:], " ")
+	// With --command-only or --json stdout is reserved for the command, everything else goes to stderr
+	commandOutput := os.Stdout
+	if opts.commandOnly || opts.jsonOutput {
+		askModeOnly = true
+		os.Stdout = os.Stderr
+	}
+	// With --script nothing runs, and stdout is reserved for the script unless it's written to a file
+	if opts.script {
+		askModeOnly = true
+		if opts.scriptOutput == "" {
+			os.Stdout = os.Stderr
+		}
+	}
+
+	// Combine all arguments as the user query, expanding an alias given as the first argument
+	var userQuery string
+	if replay != nil {
+		userQuery = args[0]
+	} else if len(args) > 0 {
+		resolved, err := alias.Resolve(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to expand alias: %v\n", err)
+			os.Exit(1)
+		}
+		userQuery = resolved
+	}
 
 	// Initialize logger
 	log, err := logger.New()
@@ -229,40 +411,289 @@ func main() {
 		os.Exit(1)
 	}
 	defer log.Close()
+	log.SetPalette(palette)
+	if opts.quiet || opts.commandOnly || opts.jsonOutput {
+		log.SetConsoleLevel(logger.LevelError)
+	}
 
 	// Initialize shell
+	// commandReason is the model's reason for the command being run, logged with it
+	var commandReason string
 	sh := shell.New(func(cmd, output string) {
 		if cmd != "" {
-			log.LogCommand(cmd)
+			log.LogCommandWithReason(cmd, commandReason)
 		}
 		if output != "" {
+			// The log is sent back to Claude as history, so it gets the same cleanup as the command output
+			if opts.stripANSI {
+				output = stripANSI(output)
+			}
 			log.LogStreamOutput(output)
 		}
 	})
 
+	// Cap the output kept in memory, so commands like `yes` can't exhaust it
+	sh.MaxOutputBytes = opts.maxOutputBytes
+	sh.KillOnOutputLimit = opts.killOnOutputLimit
+
+	// Show a heartbeat while commands run without producing output
+	if !opts.noProgress {
+		sh.ProgressInterval = opts.progressInterval
+		sh.ProgressHandler = func(elapsed time.Duration) {
+			fmt.Fprintf(os.Stderr, "%s⏳ still running… %ds%s\n", colorInfo, int(elapsed.Seconds()), colorReset)
+		}
+	}
+
+	// Load the general configuration
+	cfg, err := config.Load()
+	if err != nil {
+		log.LogError(fmt.Errorf("failed to load config: %w", err))
+		os.Exit(1)
+	}
+
+	responseSchema, err := schemaVersion(opts, cfg)
+	if err != nil {
+		log.LogError(err)
+		os.Exit(1)
+	}
+	if opts.minConfidence > 0 && responseSchema == command.SchemaV1 {
+		log.LogError(errors.New("--min-confidence needs schema v2, as v1 responses have no confidence (see --schema-version)"))
+		os.Exit(1)
+	}
+
+	filter, err := historyFilter(opts, cfg)
+	if err != nil {
+		log.LogError(err)
+		os.Exit(1)
+	}
+	log.SetHistoryFilter(filter)
+	log.SetTimestampOutput(opts.timestampOutput || cfg.TimestampOutput)
+
+	// With audit_webhook in ai.cfg, every executed command is posted to the webhook in the background
+	// The events still queued are sent before ai exits, however it exits
+	exits := &exitHandler{}
+	defer exits.run()
+	var auditor *audit.Sender
+	if cfg.AuditWebhook != "" {
+		auditor, err = audit.NewSender(cfg.AuditWebhook, log.LogError)
+		if err != nil {
+			log.LogError(err)
+			os.Exit(1)
+		}
+		exits.add(func() { auditor.Close(auditCloseTimeout) })
+	}
+
+	// Compile the file list exclusion patterns from config and flags
+	sh.Exclude, err = shell.NewExcludeMatcher(append(cfg.Exclude, opts.exclude...))
+	if err != nil {
+		log.LogError(err)
+		exits.exit(1)
+	}
+
 	// Get current directory
 	currentDir, err := sh.GetCurrentDirectory()
 	if err != nil {
 		log.LogError(fmt.Errorf("failed to get current directory: %w", err))
-		os.Exit(1)
+		exits.exit(1)
 	}
 
-	// List files in the current directory
-	files, err := sh.ListFiles(maxFiles)
+	// Nothing runs inside a forbidden directory, ask mode can still suggest commands there
+	forbiddenDir, err := cfg.ForbiddenDir(currentDir)
 	if err != nil {
-		log.LogError(fmt.Errorf("failed to list files: %w", err))
-		os.Exit(1)
+		log.LogError(err)
+		exits.exit(1)
+	}
+	if forbiddenDir != "" && !askModeOnly {
+		log.LogError(fmt.Errorf("refusing to run commands in %s, which is inside %s from forbidden_dirs in ai.cfg; use ask mode or --command-only to only get a suggestion", currentDir, forbiddenDir))
+		exits.exit(1)
+	}
+
+	// With --dir the prompt tells where the listed files come from
+	promptDir := currentDir
+	if len(opts.dirs) > 0 {
+		for _, dir := range opts.dirs {
+			if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+				log.LogError(fmt.Errorf("--dir %s is not a directory", dir))
+				exits.exit(1)
+			}
+		}
+		promptDir = fmt.Sprintf("%s (the files listed come from %s, paths are relative to the current directory)", currentDir, strings.Join(opts.dirs, ", "))
+	}
+
+	// List files in the current directory, unless the user opted out of sending them
+	var files []string
+	var filesTruncated bool
+	var fileTree string
+	if !opts.noFiles {
+		limit, err := maxFiles(opts, cfg)
+		if err != nil {
+			log.LogError(err)
+			exits.exit(1)
+		}
+		switch {
+		case opts.tree && len(opts.dirs) > 0:
+			fileTree, err = buildDirTrees(sh, opts.dirs, limit)
+		case opts.tree:
+			fileTree, err = sh.BuildTree(currentDir, limit, maxTreeDepth)
+		case len(opts.dirs) > 0:
+			files, filesTruncated, err = listDirFiles(sh, opts.dirs, limit)
+		default:
+			files, filesTruncated, err = sh.ListFiles(limit)
+		}
+		if err != nil && opts.tree {
+			log.LogError(fmt.Errorf("failed to build directory tree: %w", err))
+			exits.exit(1)
+		}
+		if err != nil {
+			log.LogError(fmt.Errorf("failed to list files: %w", err))
+			exits.exit(1)
+		}
+		if filesTruncated {
+			log.LogInfo(fmt.Sprintf("The directory has more than %d files, only the first %d are sent (see --max-files)", limit, limit))
+		}
+	}
+
+	// Read the custom system prompt before anything is sent
+	var customPrompt string
+	if opts.systemPromptFile != "" {
+		customPrompt, err = loadSystemPromptFile(opts.systemPromptFile)
+		if err != nil {
+			log.LogError(err)
+			exits.exit(1)
+		}
+		if missing := prompt.MissingResponseFields(customPrompt); len(missing) > 0 {
+			fmt.Printf("%s⚠️  %s doesn't mention the response fields %s, responses may fail to parse%s\n",
+				colorWarning, opts.systemPromptFile, strings.Join(missing, ", "), colorReset)
+		}
 	}
 
+	// Fix config file permissions before the config is loaded
+	if opts.fixPerms {
+		if err := anthropic.FixConfigPermissions(); err != nil {
+			log.LogError(err)
+		} else {
+			log.LogInfo("Restricted config file permissions to the owner")
+		}
+	}
+
+	// The model can be set for this run and remembered per directory
+	dirConfig, err := config.LoadDirConfig(currentDir)
+	if err != nil {
+		log.LogError(err)
+		exits.exit(1)
+	}
+	config.SetModel(opts.model, dirConfig.Model)
+
 	// Initialize client
-	client, err := getClient(log)
+	var client Client = replay
+	if replay == nil {
+		client, err = getClient(log)
+		if err != nil {
+			log.LogError(fmt.Errorf("failed to initialize AI client: %w", err))
+			exits.exit(1)
+		}
+	}
+
+	// Only remember a model the client accepted
+	if opts.remember {
+		if err := config.RememberModel(currentDir, opts.model); err != nil {
+			log.LogError(err)
+			exits.exit(1)
+		}
+		log.LogInfo(fmt.Sprintf("Remembered model %s for %s", opts.model, currentDir))
+	}
+	// With --single-step Claude is told that only one command runs, so it doesn't suggest the first of several steps
+	singleStep := opts.singleStep || cfg.SingleStep
+	// default_instructions from ai.cfg come first, so a one-off --append-prompt can refine them
+	var promptAdditions []string
+	if cfg.DefaultInstructions != "" {
+		promptAdditions = append(promptAdditions, cfg.DefaultInstructions)
+	}
+	promptAdditions = append(promptAdditions, opts.appendPrompt...)
+	if singleStep {
+		promptAdditions = append(promptAdditions[:len(promptAdditions):len(promptAdditions)], prompt.SingleStepInstruction)
+	}
+	if opts.script {
+		promptAdditions = append(promptAdditions[:len(promptAdditions):len(promptAdditions)], prompt.ScriptInstruction)
+	}
+	// Claude is told about the input given with --stdin-file or --stdin-string, with a preview so it knows the format
+	if hasCommandInput {
+		preview := inputPreview(commandInput)
+		if isBinary([]byte(commandInput)) {
+			preview = "[binary data]"
+		}
+		promptAdditions = append(promptAdditions[:len(promptAdditions):len(promptAdditions)], prompt.StdinInstruction(len(commandInput), preview))
+	}
+	// --language overrides the language from ai.cfg
+	language := cfg.Language
+	if opts.language != "" {
+		language = opts.language
+	}
+	client.Configure(func(clientOptions *clientopts.Options) {
+		clientOptions.PromptAdditions = promptAdditions
+		clientOptions.SchemaVersion = responseSchema
+		clientOptions.Language = strings.TrimSpace(language)
+		clientOptions.FilesTruncated = filesTruncated
+		clientOptions.FileTree = fileTree
+		clientOptions.CustomSystemPrompt = customPrompt
+		if opts.verbose {
+			clientOptions.ThinkingHandler = log.LogThinking
+		}
+		clientOptions.ServedModelHandler = servedModelLogger(log)
+	})
+	if err := applyTemperatureProfile(client, opts, cfg, log); err != nil {
+		log.LogError(err)
+		exits.exit(1)
+	}
+	if opts.offline {
+		log.LogInfo("Offline mode: no requests will be sent to the model")
+		client = offlineClient{client: client}
+	}
+
+	// Load the session to resume, or start a new one
+	var sess *session.Session
+	if opts.sessionID != "" {
+		sess, err = session.Load(opts.sessionID)
+	} else {
+		sess, err = session.New()
+	}
 	if err != nil {
-		log.LogError(fmt.Errorf("failed to initialize AI client: %w", err))
-		os.Exit(1)
+		log.LogError(fmt.Errorf("failed to load session: %w", err))
+		exits.exit(1)
+	}
+	if len(sess.Messages) > 0 {
+		log.LogInfo(fmt.Sprintf("Resuming session %s with %d previous messages", sess.ID, len(sess.Messages)))
+	}
+	// Continue the last task in the log, its exchanges start the new session and a query given adds instructions
+	originalQuery := userQuery
+	if opts.resumeFromLog {
+		task, err := log.RecoverTask(opts.maxOutputBytes)
+		if err != nil {
+			log.LogError(fmt.Errorf("failed to recover the last task from the log: %w", err))
+			exits.exit(1)
+		}
+		log.LogInfo(fmt.Sprintf("Recovered %d messages of the last task from the log: %s", len(task.Messages), task.Query))
+		sess.Messages = task.Messages
+		originalQuery = task.Query
+		if userQuery == "" {
+			userQuery = task.Next
+		} else {
+			userQuery = fmt.Sprintf("%s\nAdditional instructions: %s", task.Next, userQuery)
+		}
 	}
+	fmt.Printf("%s🧵 Session: %s (resume with: ai --session-id %s \"...\")%s\n", colorInfo, sess.ID, sess.ID, colorReset)
 
-	// Create a context with a timeout
-	ctx := context.Background()
+	// Create a context cancelled when ai is interrupted or terminated
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	exitOnSignal := handleSignals(sh, cancel, log, exits)
+
+	// With --preflight, a misconfigured provider is reported before waiting for the first suggestion
+	if opts.preflight && !opts.offline {
+		if err := preflight(ctx, client, log); err != nil {
+			exits.exit(1)
+		}
+	}
 
 	// Log the user query
 	if askModeOnly {
@@ -272,19 +703,29 @@ func main() {
 	}
 
 	// Process user query in a loop to handle back-and-forth interactions
+	// originalQuery is kept for output reviews, as userQuery turns into follow-up messages
 	commandCount := 0
+	// Claude may describe its plan in the first response (schema v2), it is only shown once
+	planShown := false
+	// failures are the commands that failed so far, Claude sometimes suggests them again and again
+	var failures command.Failures
+	// steps numbers the executed commands, a table of them is printed when the task ends
+	steps := &stepTracker{}
+	defer steps.printSummary()
+	// script collects the suggested commands with --script
+	script := &shellScript{query: originalQuery}
 	for {
 		commandCount++
 
 		// Get command suggestion from Sonnet
 		log.LogInfo("Asking Claude for command suggestion...")
 		if commandCount > 1 {
-			fmt.Println("\n--- Asking Claude for next command... ---\n")
+			fmt.Print("\n--- Asking Claude for next command... ---\n\n")
 		}
 
 		// Fetch recent command history for context
 		var commandHistory string
-		history, histErr := log.GetRecentHistory()
+		history, histErr := log.GetRecentHistoryN(opts.contextLines, opts.contextBytes)
 		if histErr != nil {
 			log.LogError(fmt.Errorf("failed to get command history: %w", histErr))
 			// Continue without history if we can't get it
@@ -293,19 +734,86 @@ func main() {
 			log.LogInfo(fmt.Sprintf("Including %d bytes of command history for context", len(commandHistory)))
 		}
 
-		// Get command suggestion with spinner
-		modelResponse, err := waitWithSpinner(ctx, client, userQuery, currentDir, files, commandHistory)
+		// Refresh the git state, as the previous command may have changed it
+		if opts.gitContext || opts.gitLog > 0 {
+			gitInfo, err := shell.GitContext(opts.gitLog)
+			if err != nil {
+				log.LogError(fmt.Errorf("failed to get git context: %w", err))
+			}
+			client.Configure(func(clientOptions *clientopts.Options) {
+				clientOptions.GitContext = gitInfo.Summary()
+			})
+		}
+
+		// Show the exact prompt for debugging, after trimming it to the context window, optionally without sending it
+		if opts.printPrompt || opts.printPromptOnly {
+			systemPrompt, _ := client.SystemPrompt(sess.Messages, userQuery, promptDir, files, commandHistory)
+			printPrompt(systemPrompt, userQuery)
+			if opts.printPromptOnly {
+				return
+			}
+		}
+
+		// Tag the request with an ID so log lines can be matched with the provider's request ID
+		requestCtx, requestIDs := requestid.New(ctx)
+		log.LogInfo(fmt.Sprintf("Sending request %s", requestIDs.Local))
+
+		// Get command suggestion, either streamed live or with a spinner
+		// With --count, the first request asks for several alternatives in parallel for the user to choose from
+		var modelResponse string
+		var alternatives []alternative
+		if opts.count > 1 && commandCount == 1 && !opts.offline {
+			_, err = waitWithSpinner(requestCtx, func(ctx context.Context) (string, error) {
+				alts, err := fetchAlternatives(ctx, client, sess.Messages, userQuery, promptDir, files, commandHistory, opts.count)
+				alternatives = alts
+				return "", err
+			})
+		} else if opts.stream {
+			modelResponse, err = streamSuggestion(requestCtx, client, sess.Messages, userQuery, promptDir, files, commandHistory)
+		} else {
+			modelResponse, err = waitWithSpinner(requestCtx, func(ctx context.Context) (string, error) {
+				return client.GetCommandSuggestion(ctx, sess.Messages, userQuery, promptDir, files, commandHistory)
+			})
+		}
+		if errors.Is(err, context.Canceled) {
+			if ctx.Err() != nil {
+				exitOnSignal()
+			}
+			log.LogInfo(fmt.Sprintf("Request cancelled by user (%s)", requestIDs))
+			exits.exit(1)
+		}
 		if err != nil {
-			log.LogError(fmt.Errorf("failed to get command suggestion: %w", err))
-			os.Exit(1)
+			log.LogError(fmt.Errorf("failed to get command suggestion (%s): %w", requestIDs, err))
+			exits.exit(1)
+		}
+		log.LogInfo(fmt.Sprintf("Received suggestion (%s)", requestIDs))
+		if alternatives != nil {
+			log.LogInfo(fmt.Sprintf("Received %d distinct alternatives out of %d", len(alternatives), opts.count))
+			modelResponse = chooseAlternative(alternatives, opts.count).response
+		}
+
+		// In offline mode the response is a dump of the request, so there is nothing to parse
+		if opts.offline {
+			fmt.Println(modelResponse)
+			break
 		}
 
 		// Parse the model response
-		cmd, err := aws.ParseCommandResponse(modelResponse)
+		cmd, err := command.ParseCommandResponse(modelResponse)
 		if err != nil {
-			log.LogError(fmt.Errorf("failed to parse model response: %s\nError: %v", modelResponse, err))
+			log.LogError(fmt.Errorf("failed to parse model response (%s): %s\nError: %v", requestIDs, modelResponse, err))
 			fmt.Println("Raw model response:", modelResponse)
-			os.Exit(1)
+			exits.exit(1)
+		}
+		if note := cmd.NormalizeFlags(); note != "" {
+			log.LogInfo(note)
+		}
+
+		// Record the exchange so the session can be resumed later
+		sess.Append("user", userQuery)
+		sess.Append("assistant", modelResponse)
+		if err := sess.Save(); err != nil {
+			log.LogError(fmt.Errorf("failed to save session: %w", err))
 		}
 
 		// Log the command suggestion
@@ -314,100 +822,806 @@ func main() {
 		log.LogInfo(fmt.Sprintf("Safe: %t", cmd.Safe))
 		log.LogInfo(fmt.Sprintf("Is Final: %t", cmd.IsFinal))
 		log.LogInfo(fmt.Sprintf("Needs Output: %t", cmd.NeedsOutput))
+		if len(cmd.SideEffects) > 0 {
+			log.LogInfo(fmt.Sprintf("Side Effects: %s", strings.Join(cmd.SideEffects, "; ")))
+		}
+		if cmd.Confidence != nil {
+			log.LogInfo(fmt.Sprintf("Confidence: %.2f", *cmd.Confidence))
+		}
+
+		// With --single-step the command is the last one, whatever Claude planned
+		if singleStep {
+			cmd.IsFinal, cmd.NeedsOutput, cmd.Plan = true, false, nil
+		}
+
+		// Claude may ask a question instead of suggesting a command (schema v2)
+		if cmd.Clarification != "" && strings.TrimSpace(cmd.Command) == "" {
+			log.LogInfo(fmt.Sprintf("Clarification: %s", cmd.Clarification))
+			fmt.Printf("\n%s❓ %s%s\n", colorInfo, cmd.Clarification, colorReset)
+			if opts.jsonOutput {
+				printJSON(commandOutput, cmd)
+				exits.exit(1)
+			}
+			if opts.commandOnly {
+				exits.exit(1)
+			}
+			if askModeOnly && !opts.script {
+				break
+			}
+
+			answer := readLine("> ")
+			if answer == "" {
+				fmt.Println("No answer given, stopping.")
+				return
+			}
+			log.LogInfo(fmt.Sprintf("Clarification answer: %s", answer))
+			userQuery = fmt.Sprintf("You asked: %s\nMy answer: %s\nPlease continue with my original request: %s",
+				cmd.Clarification, answer, userQuery)
+			continue
+		}
+
+		// Let the user veto the approach of a multi-step task before anything runs
+		if len(cmd.Plan) > 0 && !planShown {
+			planShown = true
+			steps.total = len(cmd.Plan)
+			script.plan = cmd.Plan
+			log.LogInfo(fmt.Sprintf("Plan: %s", strings.Join(cmd.Plan, "; ")))
+			printPlan(cmd.Plan)
+			if !askModeOnly && !opts.yes && !confirm("Proceed with this plan? (y/n): ") {
+				fmt.Println("Plan rejected by user.")
+				return
+			}
+		}
+
+		// A command that already failed would fail the same way, so Claude is asked for another approach instead
+		if status, repeated := failures.Repeated(cmd.Command); repeated && !askModeOnly {
+			if failures.Repeats() >= maxRepeatedFailures {
+				log.LogError(fmt.Errorf("Claude kept suggesting commands that had already failed (%d repeats), stopping: %s", failures.Repeats(), cmd.Command))
+				exits.exit(1)
+			}
+			log.LogInfo(fmt.Sprintf("Suggested command already failed (%s), asking for a different approach: %s", status, cmd.Command))
+			fmt.Printf("%s🔁 Claude suggested a command that already failed, asking for a different approach: %s%s\n", colorWarning, cmd.Command, colorReset)
+			userQuery = fmt.Sprintf("You suggested '%s' again, but I already ran it and it %s, so it would fail the same way. "+
+				"Don't suggest it or a variation of it again. Try a fundamentally different approach to my original request, "+
+				"or if it can't be done, suggest a harmless command such as echo that explains why and set is_final to true: %s",
+				cmd.Command, status, originalQuery)
+			continue
+		}
 
 		// Display the command suggestion
+		if opts.commandOnly {
+			fmt.Fprintln(commandOutput, cmd.Command)
+			break
+		}
+		if opts.jsonOutput {
+			printJSON(commandOutput, cmd)
+			break
+		}
+		if opts.script {
+			fmt.Printf("%s📜 Step %d: %s%s%s\n", colorInfo, len(script.steps)+1, colorCommand, cmd.Command, colorReset)
+			userQuery = script.add(cmd)
+			if !script.done() {
+				continue
+			}
+			if err := writeScript(commandOutput, script.render(), opts.scriptOutput); err != nil {
+				log.LogError(err)
+				exits.exit(1)
+			}
+			if opts.scriptOutput != "" {
+				fmt.Printf("%s📜 Wrote a script of %d commands to %s, review it before running it.%s\n", colorSuccess, len(script.steps), opts.scriptOutput, colorReset)
+			}
+			break
+		}
 		if askModeOnly {
-			fmt.Printf("\n%s💡 Suggested Command:%s\n", colorGreen, colorReset)
-			fmt.Printf("%s%s%s\n\n", colorRed, cmd.Command, colorReset)
+			fmt.Printf("\n%s💡 Suggested Command:%s\n", colorSuccess, colorReset)
+			fmt.Printf("%s%s%s\n\n", colorCommand, cmd.Command, colorReset)
 			fmt.Printf("Reason: %s\n", cmd.Reason)
 			fmt.Printf("Safety: %s\n", getSafetyText(cmd.Safe))
+			printConfidence(cmd.Confidence)
+			printSideEffects(cmd.SideEffects)
+			printMissingPrograms(shell.MissingPrograms(cmd.Command))
+			if command.LooksInjected(cmd.Command, cmd.Reason) {
+				printInjectionWarning()
+			}
+			printInstalls(shell.FindInstalls(cmd.Command))
 
 			if !cmd.IsFinal {
 				if cmd.NeedsOutput {
-					fmt.Printf("\n%s🔄 This is an intermediate command. Claude would need to see its output to determine next steps.%s\n", colorBlue, colorReset)
+					fmt.Printf("\n%s🔄 This is an intermediate command. Claude would need to see its output to determine next steps.%s\n", colorInfo, colorReset)
 				} else {
-					fmt.Printf("\n%s🔄 This is part of a multi-step process. More commands would follow.%s\n", colorBlue, colorReset)
+					fmt.Printf("\n%s🔄 This is part of a multi-step process. More commands would follow.%s\n", colorInfo, colorReset)
 				}
 			} else {
-				fmt.Printf("\n%s✅ This is the final command to complete your request.%s\n", colorGreen, colorReset)
+				fmt.Printf("\n%s✅ This is the final command to complete your request.%s\n", colorSuccess, colorReset)
 			}
 
-			// In ask mode, we're done after the first command suggestion
-			break
+			// In ask mode, we're done after the first command suggestion unless the user wants to run it
+			if opts.execute && forbiddenDir != "" {
+				fmt.Printf("\n%s⛔ Not offering to run it, %s is inside %s from forbidden_dirs.%s\n", colorWarning, currentDir, forbiddenDir, colorReset)
+				break
+			}
+			if !opts.execute || !confirm("\nRun this command now? (y/n): ") {
+				break
+			}
+			askModeOnly = false
+			log.LogInfo("Running the suggested command, continuing in run mode")
+		} else {
+			// Inform the user about the nature of the command
+			if !cmd.IsFinal {
+				if cmd.NeedsOutput {
+					fmt.Printf("\n%s🔄 This is an intermediate command. Claude needs to see its output to determine next steps.%s\n", colorInfo, colorReset)
+				} else {
+					fmt.Printf("\n%s🔄 This is part of a multi-step process. More commands will follow.%s\n", colorInfo, colorReset)
+				}
+			} else {
+				fmt.Printf("\n%s✅ This is the final command to complete your request.%s\n", colorSuccess, colorReset)
+			}
+			printSideEffects(cmd.SideEffects)
 		}
 
-		// Inform the user about the nature of the command
-		if !cmd.IsFinal {
-			if cmd.NeedsOutput {
-				fmt.Printf("\n%s🔄 This is an intermediate command. Claude needs to see its output to determine next steps.%s\n", colorBlue, colorReset)
-			} else {
-				fmt.Printf("\n%s🔄 This is part of a multi-step process. More commands will follow.%s\n", colorBlue, colorReset)
+		// Claude may suggest programs that aren't installed, offer to ask for an alternative before running anything
+		if missing := shell.MissingPrograms(cmd.Command); len(missing) > 0 {
+			log.LogInfo(fmt.Sprintf("Not installed: %s", strings.Join(missing, ", ")))
+			printMissingPrograms(missing)
+			if !opts.yes && confirm("Ask Claude for an alternative? (y/n): ") {
+				userQuery = fmt.Sprintf("I can't run '%s' because these programs are not installed: %s. Please suggest an alternative to continue with my original request: %s",
+					cmd.Command, strings.Join(missing, ", "), userQuery)
+				continue
 			}
-		} else {
-			fmt.Printf("\n%s✅ This is the final command to complete your request.%s\n", colorGreen, colorReset)
 		}
 
-		// Check if the command is safe
-		if !cmd.Safe {
-			fmt.Printf("%s⚠️  Caution: The command is marked as not safe. ⚠️%s\n", colorYellow, colorReset)
-			fmt.Printf("Command: %s%s%s\n", colorRed, cmd.Command, colorReset)
-			fmt.Printf("Reason: %s\n", cmd.Reason)
-			fmt.Print("Do you want to run this command anyway? (y/n): ")
+		// Commands that escalate privileges always need confirmation unless explicitly allowed
+		privileged := shell.RequiresPrivilege(cmd.Command)
+		// Commands matching the always_allow prefixes from ai.cfg are trusted by the user
+		alwaysAllowed := shell.IsAlwaysAllowed(cmd.Command, cfg.AlwaysAllow)
+		if alwaysAllowed && !cmd.Safe {
+			log.LogInfo("Skipping confirmation, the command matches always_allow")
+		}
+		// With --min-confidence, safe commands Claude isn't confident enough about ask too, a missing confidence counts as too low
+		lowConfidence := opts.minConfidence > 0 && (cmd.Confidence == nil || *cmd.Confidence < opts.minConfidence)
+		// With --confirm-all (or confirm_all in ai.cfg), safe commands ask too
+		needsConfirmation := (!cmd.Safe || lowConfidence || opts.confirmAll || cfg.ConfirmAll) && !opts.yes && !alwaysAllowed
+		if privileged && !opts.allowSudo {
+			needsConfirmation = true
+		}
 
-			scanner := bufio.NewScanner(os.Stdin)
-			scanner.Scan()
-			answer := strings.ToLower(scanner.Text())
+		if privileged {
+			fmt.Printf("%s🔐 This command runs with elevated privileges (sudo/doas).%s\n", colorHighlight, colorReset)
+		}
+
+		// Secrets in a command line end up in the process list, the shell history and the log, so they always need confirmation
+		if findings := secretscan.Scan(cmd.Command); len(findings) > 0 {
+			var found []string
+			for _, finding := range findings {
+				found = append(found, fmt.Sprintf("%s (%s)", finding.Kind, finding.Masked))
+			}
+			log.LogInfo(fmt.Sprintf("Possible secrets in command: %s", strings.Join(found, ", ")))
+			fmt.Printf("%s🔑 The command seems to contain secrets: %s. Running it exposes them to other processes and the log.%s\n",
+				colorWarning, strings.Join(found, ", "), colorReset)
+			needsConfirmation = true
+		}
+
+		// A file name like "; rm -rf ~" echoed into the command can smuggle in a command Claude didn't intend
+		if command.LooksInjected(cmd.Command, cmd.Reason) {
+			log.LogInfo("The command may contain text injected from file names")
+			printInjectionWarning()
+			needsConfirmation = true
+		}
 
+		// Installing software changes the system beyond the current directory, so it needs confirmation even with --yes
+		installs := shell.FindInstalls(cmd.Command)
+		if len(installs) > 0 {
+			log.LogInfo(fmt.Sprintf("The command installs software: %s", describeInstalls(installs)))
+			printInstalls(installs)
+			if !alwaysAllowed {
+				needsConfirmation = true
+			}
+		}
+
+		// With --sandbox, unsafe commands run in a copy of the directory and their changes are applied once approved
+		sandboxed := opts.sandbox && !cmd.Safe
+		if sandboxed && strings.Contains(cmd.Command, currentDir) {
+			log.LogInfo("Not using a sandbox, the command refers to the current directory by its absolute path")
+			fmt.Printf("%s🧪 The command refers to %s by its absolute path, so a sandbox can't contain it. It will change the real directory.%s\n", colorWarning, currentDir, colorReset)
+			sandboxed = false
+			needsConfirmation = true
+		}
+
+		if needsConfirmation {
+			if !cmd.Safe {
+				fmt.Printf("%s⚠️  Caution: The command is marked as not safe. ⚠️%s\n", colorWarning, colorReset)
+			} else if lowConfidence {
+				fmt.Printf("%s🤔 Claude's confidence in this command is below --min-confidence %.2f.%s\n", colorWarning, opts.minConfidence, colorReset)
+			}
+			fmt.Printf("Command: %s%s%s\n", colorCommand, cmd.Command, colorReset)
+			fmt.Printf("Reason: %s\n", cmd.Reason)
+			printConfidence(cmd.Confidence)
+
+			// Besides yes and no, the user can reject the command with feedback for Claude to revise it
+			// With run-json there is no model to revise the command
+			answers := "y/n, or r to revise it"
+			if replay != nil {
+				answers = "y/n"
+			}
+			question := fmt.Sprintf("Do you want to run this command? (%s): ", answers)
+			if !cmd.Safe {
+				question = fmt.Sprintf("Do you want to run this command anyway? (%s): ", answers)
+			}
+			answer := strings.ToLower(readLine(question))
+			if replay == nil && (answer == "r" || answer == "revise") {
+				feedback := readLine("What should change? ")
+				if feedback == "" {
+					fmt.Println("No feedback given, command execution cancelled by user.")
+					return
+				}
+				log.LogInfo(fmt.Sprintf("Revision requested: %s", feedback))
+				userQuery = fmt.Sprintf("I didn't run the command '%s'. My feedback: %s\nPlease suggest a revised command for my original request: %s",
+					cmd.Command, feedback, userQuery)
+				continue
+			}
 			if answer != "y" && answer != "yes" {
 				fmt.Println("Command execution cancelled by user.")
 				return
 			}
 		}
 
+		var sandbox *shell.Sandbox
+		if sandboxed {
+			sandbox, err = shell.NewSandbox(currentDir)
+			if err != nil {
+				log.LogError(err)
+				fmt.Printf("%s🧪 The command can't run in a sandbox: %v%s\n", colorWarning, err, colorReset)
+				if !confirm("Run it in the real directory instead? (y/n): ") {
+					fmt.Println("Command execution cancelled by user.")
+					return
+				}
+			} else {
+				log.LogInfo(fmt.Sprintf("Running the command in the sandbox %s", sandbox.Dir))
+				fmt.Printf("%s🧪 Running the command in a sandbox copy of the directory, you can review its changes before they are applied.%s\n", colorInfo, colorReset)
+				sh.Dir = sandbox.Dir
+			}
+		}
+
+		// With --confirm-edits, the files the command writes to are backed up so each change can be kept or reverted
+		// A sandboxed command can't change them, its changes are reviewed as a whole instead
+		var backup *shell.Backup
+		if opts.confirmEdits && sandbox == nil {
+			var proceed bool
+			backup, proceed = backupEdits(cmd.Command, currentDir, log)
+			if !proceed {
+				fmt.Println("Command execution cancelled by user.")
+				return
+			}
+		}
+
 		// Execute the command with streaming output
-		fmt.Printf("\n🔄 Executing command: %s%s%s\n", colorRed, cmd.Command, colorReset)
+		commandReason = cmd.Reason
+		fmt.Printf("\n🔄 %s, executing command: %s%s%s\n", steps.label(), colorCommand, cmd.Command, colorReset)
 		fmt.Println("-------------------------------------------------------------------------")
 
+		// Snapshot the directory so changes made by the command can be reported
+		var before shell.Snapshot
+		if opts.trackChanges {
+			before, err = shell.SnapshotTree(currentDir)
+			if err != nil {
+				log.LogError(err)
+			}
+		}
+
 		var output string
 		var execErr error
+		// stoppedReason is Claude's reason for stopping the command early with --stream-feedback
+		var stoppedReason string
+
+		started := time.Now()
+		interactive := shell.IsInteractive(cmd.Command)
+		if interactive {
+			// Interactive commands need the real terminal, so their output can't be captured
+			fmt.Printf("%s⌨️  This command looks interactive and will be connected to your terminal. Its output will not be captured.%s\n", colorWarning, colorReset)
+			if hasCommandInput {
+				fmt.Printf("%s⌨️  It reads from the terminal, not the input given with --stdin-file or --stdin-string.%s\n", colorWarning, colorReset)
+			}
+			log.LogInfo("Running interactive command with terminal passthrough")
+			execErr = sh.RunInteractive(cmd.Command)
+			output = "(interactive command, output was not captured)\n"
+		} else {
+			// With --stream-feedback, Claude sees the output while the command runs
+			var reviewer *outputReviewer
+			if opts.streamFeedback {
+				reviewer = newOutputReviewer(ctx, client, sh, log, originalQuery, cmd.Command)
+			}
+
+			// The output is buffered for throughput unless --unbuffered is given
+			console := newConsoleWriter(os.Stdout, opts.unbuffered)
+
+			// With --command-timeout each command gets its own time limit, waiting for Claude doesn't count towards it
+			commandCtx, cancelCommand := ctx, context.CancelFunc(func() {})
+			if opts.commandTimeout > 0 {
+				commandCtx, cancelCommand = context.WithTimeout(ctx, opts.commandTimeout)
+			}
+
+			// Use the streaming command execution, with stderr in a distinct color so errors stand out
+			// Each command gets the whole input given with --stdin-file or --stdin-string
+			var stdin io.Reader
+			if hasCommandInput {
+				stdin = strings.NewReader(commandInput)
+			}
+
+			// A command that may be stopped on a timeout or by Claude gets its own process group, so its children are stopped with it
+			ownGroup := opts.commandTimeout > 0 || opts.streamFeedback
+			output, execErr = sh.StreamCommandInput(commandCtx, cmd.Command, stdin, ownGroup, func(line string) {
+				// This function is called for each line of output as it's produced
+				// We don't need to do anything here since the LogHandler in the shell will log it
+				console.Print(line)
+				if reviewer != nil {
+					reviewer.add(line)
+				}
+			}, func(line string) {
+				console.Print(colorize(colorWarning, line))
+				if reviewer != nil {
+					reviewer.add(line)
+				}
+			})
+			console.Close()
+			cancelCommand()
+
+			if reviewer != nil {
+				stoppedReason = reviewer.finish()
+			}
+		}
 
-		// Use the streaming command execution
-		output, execErr = sh.StreamCommand(cmd.Command, func(line string) {
-			// This function is called for each line of output as it's produced
-			// We don't need to do anything here since the LogHandler in the shell will log it
-			fmt.Print(line) // Print directly to console for immediate feedback
-		})
+		sh.Dir = ""
+		steps.record(cmd.Command, cmd.Safe, execErr, stoppedReason != "", time.Since(started))
+
+		// The command was stopped by a signal, exit once the signal handler has shut down
+		if ctx.Err() != nil {
+			exitOnSignal()
+		}
 
 		fmt.Println("-------------------------------------------------------------------------")
 
-		if execErr != nil {
+		// A command over its time limit was stopped, Claude is told so below
+		timedOut := errors.Is(execErr, context.DeadlineExceeded)
+		if timedOut {
+			log.LogInfo(fmt.Sprintf("Command stopped after exceeding --command-timeout %s", opts.commandTimeout))
+			fmt.Printf("%s⏱️  The command was stopped after running longer than %s.%s\n", colorWarning, opts.commandTimeout, colorReset)
+		} else if execErr != nil {
 			log.LogError(fmt.Errorf("command execution failed: %w", execErr))
-			fmt.Printf("%s⚠️ Command execution error: %v%s\n", colorYellow, execErr, colorReset)
+			fmt.Printf("%s⚠️ Command execution error: %v%s\n", colorWarning, execErr, colorReset)
 			// Don't exit on command failure, just log it
 		}
+		if execErr != nil {
+			failures.Record(cmd.Command, exitStatus(execErr))
+		}
+		if auditor != nil {
+			auditor.Send(audit.NewEvent(currentDir, originalQuery, cmd.Command, cmd.Safe, shell.ExitCode(execErr)))
+		}
 
-		// If this is the final command or we don't need output, break the loop
-		if cmd.IsFinal && !cmd.NeedsOutput {
-			fmt.Printf("%s✅ Task completed successfully!%s\n", colorGreen, colorReset)
+		// With --record-installs, successful installs are kept in the session for later cleanup
+		if opts.recordInstalls && len(installs) > 0 && execErr == nil {
+			for _, install := range installs {
+				sess.RecordInstall(cmd.Command, install.Manager, install.Packages)
+			}
+			if err := sess.Save(); err != nil {
+				log.LogError(fmt.Errorf("failed to save session: %w", err))
+			} else {
+				fmt.Printf("%s📦 Recorded the installed software in session %s, list it with: ai export --session-id %s%s\n", colorInfo, sess.ID, sess.ID, colorReset)
+			}
+		}
+
+		// Colors and other escape sequences are only noise to Claude, the console already showed them
+		if opts.stripANSI {
+			output = stripANSI(output)
+		}
+
+		// Binary output is useless to Claude and can't be sent as text, so only its size is passed on
+		binary := isBinary([]byte(output))
+		if binary {
+			log.LogInfo(fmt.Sprintf("Command produced %d bytes of binary output, omitting it from the conversation", len(output)))
+			output = fmt.Sprintf("[binary output, %d bytes omitted]\n", len(output))
+		} else {
+			// Text with a few invalid bytes is kept, with the bytes replaced so it can be sent as text
+			output = strings.ToValidUTF8(output, string(utf8.RuneError))
+		}
+
+		// feedback is what Claude is sent of the output, with --feedback-grep only the matching lines
+		// The console and the log already have the full output, and summaries and diagnoses use it too
+		feedback := output
+		if opts.feedbackPattern != nil && !interactive && !binary && output != "" {
+			feedback = grepOutput(output, opts.feedbackPattern)
+			log.LogInfo(fmt.Sprintf("Sending %d of %d bytes of output matching --feedback-grep", len(feedback), len(output)))
+		}
+
+		// Report the files the command created, modified or deleted
+		if opts.trackChanges {
+			after, err := shell.SnapshotTree(currentDir)
+			if err != nil {
+				log.LogError(err)
+			} else {
+				printChanges(before.Diff(after), before.Truncated || after.Truncated)
+			}
+		}
+
+		// Show the changes made in the sandbox and apply them if the user approves
+		if sandbox != nil {
+			feedback += reviewSandbox(sandbox, opts.yes, log)
+		}
+
+		// Show the changes made to the backed up files and revert those the user rejects
+		if backup != nil {
+			feedback += reviewEdits(backup, log)
+		}
+
+		// Summarize the command output if requested
+		if opts.summarize && !interactive && strings.TrimSpace(output) != "" {
+			log.LogInfo("Asking Claude to summarize the command output...")
+			summary, err := waitWithSpinner(ctx, func(ctx context.Context) (string, error) {
+				return client.Summarize(ctx, truncateOutput(output, maxSummaryBytes))
+			})
+			if err != nil {
+				log.LogError(fmt.Errorf("failed to summarize command output: %w", err))
+			} else {
+				fmt.Printf("%s📝 Summary:%s\n%s\n", colorInfo, colorReset, strings.TrimSpace(summary))
+			}
+		}
+
+		// With --explain-errors Claude diagnoses a failed command, the diagnosis is also sent with its output for the next step
+		// Commands that were stopped or timed out didn't fail on their own, and interactive ones have no output to go on
+		if opts.explainErrors && execErr != nil && !interactive && !timedOut && stoppedReason == "" {
+			log.LogInfo("Asking Claude to diagnose the failed command...")
+			diagnosis, err := waitWithSpinner(ctx, func(ctx context.Context) (string, error) {
+				return client.DiagnoseError(ctx, cmd.Command, truncateOutput(output, maxSummaryBytes), shell.ExitCode(execErr))
+			})
+			if err != nil {
+				log.LogError(fmt.Errorf("failed to diagnose the failed command: %w", err))
+			} else {
+				diagnosis = strings.TrimSpace(diagnosis)
+				log.LogInfo(fmt.Sprintf("Diagnosis: %s", diagnosis))
+				fmt.Printf("%s🩺 Why it failed:%s\n%s\n", colorInfo, colorReset, diagnosis)
+				feedback += fmt.Sprintf("\n[Diagnosis of the failure: %s]\n", diagnosis)
+			}
+		}
+
+		// With --single-step nothing follows, even a command that was cut short
+		if singleStep && (stoppedReason != "" || timedOut) {
+			fmt.Printf("%s⏹ Stopped after one command (--single-step).%s\n", colorWarning, colorReset)
+			break
+		}
+
+		// Claude stopped the command early, so the plan no longer holds and it needs to see what happened
+		if stoppedReason != "" {
+			userQuery = fmt.Sprintf("I ran the command '%s' and you stopped it early because: %s. It %s, the output up to then was:\n%s\nPlease provide the next command to continue with my original request: %s",
+				cmd.Command, stoppedReason, exitStatus(execErr), feedback, userQuery)
+			continue
+		}
+
+		// The command ran out of time, so Claude needs to know it didn't finish
+		if timedOut {
+			userQuery = fmt.Sprintf("I ran the command '%s' and it was stopped because it ran longer than the %s time limit per command. The output up to then was:\n%s\nPlease provide the next command to continue with my original request: %s",
+				cmd.Command, opts.commandTimeout, feedback, userQuery)
+			continue
+		}
+
+		// The final command completes the request, NormalizeFlags made sure Claude doesn't also need its output
+		if cmd.IsFinal {
+			fmt.Printf("%s✅ Task completed successfully!%s\n", colorSuccess, colorReset)
 			break
 		}
 
 		// If the command needs output for next steps, update the user query
+		// The exit status is always included, as a non-zero code doesn't always mean failure (e.g. grep finding nothing)
 		if cmd.NeedsOutput {
-			userQuery = fmt.Sprintf("I ran the command '%s' and got the output:\n%s\nPlease provide the next command to continue with my original request: %s",
-				cmd.Command, output, userQuery)
+			userQuery = fmt.Sprintf("I ran the command '%s', it %s, and got the output:\n%s\nPlease provide the next command to continue with my original request: %s",
+				cmd.Command, exitStatus(execErr), feedback, userQuery)
 		} else {
 			// Just continue with the next command in sequence
-			userQuery = fmt.Sprintf("I successfully ran '%s'. What's the next command to continue with my original request: %s",
-				cmd.Command, userQuery)
+			userQuery = fmt.Sprintf("I ran '%s', it %s. What's the next command to continue with my original request: %s",
+				cmd.Command, exitStatus(execErr), userQuery)
+		}
+	}
+}
+
+// schemaVersion returns the response schema version selected by --schema-version or ai.cfg, defaulting to v1
+func schemaVersion(opts *options, cfg *config.Config) (string, error) {
+	version := command.SchemaV1
+	if cfg.SchemaVersion != "" {
+		version = cfg.SchemaVersion
+	}
+	if opts.schemaVersion != "" {
+		version = opts.schemaVersion
+	}
+	return version, command.ValidateSchemaVersion(version)
+}
+
+// listDirFiles lists the files of the --dir directories, prefixed with the directory
+// The file limit applies to all directories combined
+func listDirFiles(sh *shell.Shell, dirs []string, limit int) ([]string, bool, error) {
+	var files []string
+	for _, dir := range dirs {
+		dirFiles, truncated, err := sh.ListFilesIn(dir, limit-len(files))
+		if err != nil {
+			return nil, false, err
+		}
+		for _, file := range dirFiles {
+			files = append(files, filepath.Join(dir, file))
+		}
+		if truncated {
+			return files, true, nil
+		}
+	}
+	return files, false, nil
+}
+
+// buildDirTrees renders a tree for each --dir directory, each one gets an equal share of the entry limit
+func buildDirTrees(sh *shell.Shell, dirs []string, limit int) (string, error) {
+	share := max(limit/len(dirs), 1)
+	var b strings.Builder
+	for _, dir := range dirs {
+		tree, err := sh.BuildTree(dir, share, maxTreeDepth)
+		if err != nil {
+			return "", err
+		}
+		// The tree's root line is ".", name the directory instead
+		b.WriteString(strings.TrimSuffix(dir, "/") + "/\n" + strings.TrimPrefix(tree, ".\n"))
+	}
+	return b.String(), nil
+}
+
+// historyFilter returns the history filter selected by --history-filter or ai.cfg, defaulting to commands and their output
+func historyFilter(opts *options, cfg *config.Config) (logger.HistoryFilter, error) {
+	name := cfg.HistoryFilter
+	if opts.historyFilter != "" {
+		name = opts.historyFilter
+	}
+	return logger.ParseHistoryFilter(name)
+}
+
+// loadSystemPromptFile reads the custom system prompt of --system-prompt-file
+func loadSystemPromptFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read system prompt file: %w", err)
+	}
+	if strings.TrimSpace(string(data)) == "" {
+		return "", fmt.Errorf("system prompt file %s is empty", path)
+	}
+	return string(data), nil
+}
+
+// maxFiles returns the file list limit selected by --max-files or ai.cfg, defaulting to defaultMaxFiles
+func maxFiles(opts *options, cfg *config.Config) (int, error) {
+	limit := defaultMaxFiles
+	if cfg.MaxFiles != 0 {
+		limit = cfg.MaxFiles
+	}
+	if opts.maxFiles != 0 {
+		limit = opts.maxFiles
+	}
+	if limit < 0 {
+		return 0, fmt.Errorf("invalid file limit %d, it must be positive", limit)
+	}
+	return limit, nil
+}
+
+// printPrompt displays the rendered system prompt and the user message of a request
+func printPrompt(systemPrompt, userMessage string) {
+	fmt.Printf("%s--- System prompt ---%s\n%s\n\n", colorInfo, colorReset, systemPrompt)
+	fmt.Printf("%s--- User message ---%s\n%s\n\n", colorInfo, colorReset, userMessage)
+}
+
+// printChanges displays the files changed by a command
+func printChanges(changes shell.Changes, truncated bool) {
+	if truncated {
+		fmt.Printf("%s⚠️ The directory has more than %d files, so the change report may be incomplete.%s\n", colorWarning, shell.MaxSnapshotFiles, colorReset)
+	}
+
+	if changes.Empty() {
+		fmt.Println("📁 No files were changed.")
+		return
+	}
+
+	fmt.Println("📁 Files changed by the command:")
+	for _, path := range changes.Created {
+		fmt.Printf("  %s+ %s%s\n", colorSuccess, path, colorReset)
+	}
+	for _, path := range changes.Modified {
+		fmt.Printf("  %s~ %s%s\n", colorWarning, path, colorReset)
+	}
+	for _, path := range changes.Deleted {
+		fmt.Printf("  %s- %s%s\n", colorError, path, colorReset)
+	}
+}
+
+// confirm asks the user a yes/no question on the terminal
+func confirm(question string) bool {
+	answer := strings.ToLower(readLine(question))
+	return answer == "y" || answer == "yes"
+}
+
+// readLine asks a question and returns the user's trimmed answer
+func readLine(question string) string {
+	fmt.Print(question)
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Scan()
+	return strings.TrimSpace(scanner.Text())
+}
+
+// printSideEffects lists the side effects Claude expects from a command (schema v2)
+func printSideEffects(sideEffects []string) {
+	if len(sideEffects) == 0 {
+		return
+	}
+
+	fmt.Printf("%s⚡ Side effects:%s\n", colorWarning, colorReset)
+	for _, effect := range sideEffects {
+		fmt.Printf("  - %s\n", effect)
+	}
+}
+
+// printMissingPrograms warns about programs used by a command that aren't installed
+func printMissingPrograms(missing []string) {
+	if len(missing) == 0 {
+		return
+	}
+
+	fmt.Printf("%s⚠️  Not found on PATH: %s%s\n", colorWarning, strings.Join(missing, ", "), colorReset)
+}
+
+// printInjectionWarning tells the user the command may run something its reason doesn't explain
+func printInjectionWarning() {
+	fmt.Printf("%s🧨 The command contains control characters or chained commands its reason doesn't explain, possibly injected from a file name. Check it carefully.%s\n", colorHighlight, colorReset)
+}
+
+// printInstalls warns that the command installs software, if it does
+func printInstalls(installs []shell.Install) {
+	if len(installs) == 0 {
+		return
+	}
+	fmt.Printf("%s📦 This command will install software: %s.%s\n", colorHighlight, describeInstalls(installs), colorReset)
+}
+
+// describeInstalls lists installations as "packages (manager)"
+func describeInstalls(installs []shell.Install) string {
+	var parts []string
+	for _, install := range installs {
+		packages := strings.Join(install.Packages, " ")
+		if packages == "" {
+			packages = "dependencies"
 		}
+		parts = append(parts, fmt.Sprintf("%s (%s)", packages, install.Manager))
 	}
+	return strings.Join(parts, ", ")
+}
+
+// exitStatus describes how a command ended for the follow-up query
+func exitStatus(execErr error) string {
+	if code := shell.ExitCode(execErr); code >= 0 {
+		return fmt.Sprintf("exited with code %d", code)
+	}
+	// Leave out the output, which StreamCommand adds to its errors
+	cause := errors.Unwrap(execErr)
+	if cause == nil {
+		cause = execErr
+	}
+	return fmt.Sprintf("did not exit normally (%v)", cause)
+}
+
+// printPlan displays the steps Claude intends to take
+func printPlan(plan []string) {
+	fmt.Printf("\n%s📋 Plan:%s\n", colorInfo, colorReset)
+	for i, step := range plan {
+		fmt.Printf("  %d. %s\n", i+1, step)
+	}
+}
+
+// printConfidence displays the model's confidence in a command, if it reported one
+func printConfidence(confidence *float64) {
+	if confidence == nil {
+		return
+	}
+	fmt.Printf("Confidence: %.0f%%\n", *confidence*100)
+}
+
+// applyTheme sets the console colors from the theme selected by --theme or ai.cfg
+// It runs before the configuration is otherwise loaded, so errors in ai.cfg are left to be reported later
+func applyTheme(opts *options) error {
+	name := opts.theme
+	if name == "" {
+		if cfg, err := config.Load(); err == nil {
+			name = cfg.Theme
+		}
+	}
+
+	selected, err := theme.Select(name)
+	if err != nil {
+		return err
+	}
+	palette = selected
+	colorCommand = palette.Command
+	colorError = palette.Error
+	colorSuccess = palette.Success
+	colorWarning = palette.Warning
+	colorInfo = palette.Info
+	colorHighlight = palette.Highlight
+	colorReset = palette.Reset
+	return nil
 }
 
 // getSafetyText returns a colored text representation of the safety status
 func getSafetyText(safe bool) string {
 	if safe {
-		return colorGreen + "Safe to run automatically" + colorReset
+		return colorSuccess + "Safe to run automatically" + colorReset
+	}
+	return colorWarning + "Requires approval (potentially unsafe)" + colorReset
+}
+
+// colorize wraps a line of command output in a color, unless the theme has no colors (e.g. with NO_COLOR)
+func colorize(color, line string) string {
+	if color == "" {
+		return line
+	}
+	// Keep the newline outside the color so the reset isn't pushed onto the next line
+	text := strings.TrimSuffix(line, "\n")
+	return color + text + colorReset + line[len(text):]
+}
+
+// ansiPattern matches ANSI escape sequences: CSI sequences such as colors, OSC sequences such as hyperlinks, character set selection and two byte escapes
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(\x07|\x1b\\)|\x1b[()][0-9A-Za-z]|\x1b[=>@-Z\\-_]`)
+
+// stripANSI removes ANSI escape sequences, such as colors, from command output
+func stripANSI(output string) string {
+	return ansiPattern.ReplaceAllString(output, "")
+}
+
+// binarySampleBytes is how much of the start of some data isBinary looks at
+const binarySampleBytes = 8192
+
+// isBinary reports whether data looks like binary rather than text: its start contains NUL bytes,
+// or more than a tenth of it isn't valid UTF-8. Text with a few invalid bytes, e.g. a Latin-1 file name, isn't binary.
+func isBinary(data []byte) bool {
+	sample := data[:min(len(data), binarySampleBytes)]
+	if bytes.IndexByte(sample, 0) >= 0 {
+		return true
+	}
+	invalid := 0
+	for i := 0; i < len(sample); {
+		r, size := utf8.DecodeRune(sample[i:])
+		if r == utf8.RuneError && size == 1 {
+			invalid++
+		}
+		i += size
+	}
+	return invalid*10 > len(sample)
+}
+
+// grepOutput keeps the lines of output matching pattern, noting how many were left out so Claude knows the output is partial
+func grepOutput(output string, pattern *regexp.Regexp) string {
+	lines := strings.SplitAfter(output, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	var b strings.Builder
+	matched := 0
+	for _, line := range lines {
+		if pattern.MatchString(strings.TrimSuffix(line, "\n")) {
+			b.WriteString(line)
+			if !strings.HasSuffix(line, "\n") {
+				b.WriteString("\n")
+			}
+			matched++
+		}
+	}
+	fmt.Fprintf(&b, "[only the %d of %d lines matching %s are shown]\n", matched, len(lines), pattern)
+	return b.String()
+}
+
+// truncateOutput limits output to maxBytes, keeping the end where errors usually appear
+func truncateOutput(output string, maxBytes int) string {
+	if len(output) <= maxBytes {
+		return output
 	}
-	return colorYellow + "Requires approval (potentially unsafe)" + colorReset
+	omitted := len(output) - maxBytes
+	return fmt.Sprintf("[... %d bytes truncated ...]\n%s", omitted, output[omitted:])
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestIsBinary(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		binary bool
+	}{
+		{name: "empty"},
+		{name: "text", data: "total 8\n-rw-r--r-- 1 nir nir 12 main.go\n"},
+		{name: "a Latin-1 file name", data: "caf\xe9.txt\nmain.go\n" + strings.Repeat("README.md\n", 10)},
+		{name: "NUL bytes", data: "ELF\x00\x01\x02", binary: true},
+		{name: "mostly invalid UTF-8", data: "\x89PNG\r\n\x1a\n\xff\xfe\xfd\xfc\xc0\xc1", binary: true},
+		{name: "NUL after the sample", data: strings.Repeat("a", binarySampleBytes) + "\x00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isBinary([]byte(tt.data)); got != tt.binary {
+				t.Errorf("isBinary(%q) = %v, want %v", tt.data, got, tt.binary)
+			}
+		})
+	}
+}
+
+func TestInputPreview(t *testing.T) {
+	preview := inputPreview("caf\xe9,price\n" + strings.Repeat("é", stdinPreviewBytes))
+	if !strings.HasPrefix(preview, "caf�,price\n") {
+		t.Errorf("the invalid byte isn't replaced in the preview: %q", preview[:20])
+	}
+	if !utf8.ValidString(preview) || !strings.HasSuffix(preview, "é\n[...]") {
+		t.Errorf("the preview isn't cut at a character boundary: %q", preview[len(preview)-20:])
+	}
+}
+
+func TestFindSubcommand(t *testing.T) {
+	tests := []struct {
+		args       []string
+		subcommand bool
+	}{
+		{args: []string{"schema"}, subcommand: true},
+		{args: []string{"doctor"}, subcommand: true},
+		{args: []string{"explain", "tar -xzvf backup.tar.gz -C /tmp"}, subcommand: true},
+		{args: []string{"explain", "--", "tar", "-xzvf", "backup.tar.gz"}, subcommand: true},
+		{args: []string{"export", "--session-id", "3f9a1c2b", "--format", "json"}, subcommand: true},
+		{args: []string{"reset", "--all"}, subcommand: true},
+		{args: []string{"alias", "add", "gitlog", "show me the last {{.Args}} commits"}, subcommand: true},
+		{args: []string{"explain", "why", "the", "disk", "is", "full"}},
+		{args: []string{"export", "the", "users", "table", "to", "csv"}},
+		{args: []string{"reset", "the", "branch", "to", "origin/main"}},
+		{args: []string{"schema", "of", "the", "users", "table"}},
+		{args: []string{"doctor", "this", "file"}},
+		{args: []string{"alias", "ll", "to", "ls", "-la"}},
+		{args: []string{"explain why the disk is full"}},
+		{args: []string{"history", "of", "my", "git", "branch"}},
+	}
+
+	for _, tt := range tests {
+		if _, ok := findSubcommand(tt.args); ok != tt.subcommand {
+			t.Errorf("findSubcommand(%q) = %v, want %v", tt.args, ok, tt.subcommand)
+		}
+	}
+}
+
+func TestExitHandler(t *testing.T) {
+	var exits exitHandler
+	var ran []string
+	exits.add(func() { ran = append(ran, "audit") })
+	exits.add(func() { ran = append(ran, "later") })
+
+	exits.run()
+	exits.run()
+	if want := []string{"later", "audit"}; !slices.Equal(ran, want) {
+		t.Errorf("the cleanups ran as %q, want %q once in reverse order", ran, want)
+	}
+}
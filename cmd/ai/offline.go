@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/nir/ai.go/internal/clientopts"
+	"github.com/nir/ai.go/internal/prompt"
+	"github.com/nir/ai.go/internal/session"
+)
+
+// offlineClient wraps a Client and returns a dump of each request instead of sending it
+type offlineClient struct {
+	client Client
+}
+
+// GetCommandSuggestion returns the request that would be sent for a command suggestion
+func (o offlineClient) GetCommandSuggestion(ctx context.Context, turns []session.Message, userQuery, currentDir string, filesList []string, commandHistory string) (string, error) {
+	systemPrompt, turns := o.SystemPrompt(turns, userQuery, currentDir, filesList, commandHistory)
+	messages := append(append([]session.Message{}, turns...), session.Message{Role: "user", Content: userQuery})
+	return formatRequestDump(o.Model(), systemPrompt, messages), nil
+}
+
+// StreamCommandSuggestion returns the request that would be sent, nothing is streamed since no request is made
+func (o offlineClient) StreamCommandSuggestion(ctx context.Context, turns []session.Message, userQuery, currentDir string, filesList []string, commandHistory string, onText func(text string)) (string, error) {
+	return o.GetCommandSuggestion(ctx, turns, userQuery, currentDir, filesList, commandHistory)
+}
+
+// Summarize returns the request that would be sent for an output summary
+func (o offlineClient) Summarize(ctx context.Context, output string) (string, error) {
+	return formatRequestDump(o.Model(), prompt.SummarizeSystemPrompt, []session.Message{{Role: "user", Content: output}}), nil
+}
+
+// ReviewOutput returns the request that would be sent for an output review
+func (o offlineClient) ReviewOutput(ctx context.Context, request, cmd, output string) (string, error) {
+	return formatRequestDump(o.Model(), prompt.ReviewOutputSystemPrompt, []session.Message{{Role: "user", Content: prompt.ReviewOutputMessage(request, cmd, output)}}), nil
+}
+
+// ExplainCommand returns the request that would be sent for a command explanation
+func (o offlineClient) ExplainCommand(ctx context.Context, cmd string) (string, error) {
+	return formatRequestDump(o.Model(), prompt.ExplainSystemPrompt, []session.Message{{Role: "user", Content: cmd}}), nil
+}
+
+// DiagnoseError returns the request that would be sent for a failure diagnosis
+func (o offlineClient) DiagnoseError(ctx context.Context, cmd, output string, exitCode int) (string, error) {
+	return formatRequestDump(o.Model(), prompt.DiagnoseSystemPrompt, []session.Message{{Role: "user", Content: prompt.DiagnoseMessage(cmd, output, exitCode)}}), nil
+}
+
+// Model returns the model ID of the wrapped client
+func (o offlineClient) Model() string {
+	return o.client.Model()
+}
+
+// Ping succeeds without checking anything, as no requests are sent
+func (o offlineClient) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Configure changes the options of the wrapped client
+func (o offlineClient) Configure(update func(options *clientopts.Options)) {
+	o.client.Configure(update)
+}
+
+// SystemPrompt returns the system prompt and earlier turns built by the wrapped client
+func (o offlineClient) SystemPrompt(turns []session.Message, userQuery, currentDir string, filesList []string, commandHistory string) (string, []session.Message) {
+	return o.client.SystemPrompt(turns, userQuery, currentDir, filesList, commandHistory)
+}
+
+// formatRequestDump renders a request in a human readable form
+func formatRequestDump(model, systemPrompt string, messages []session.Message) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "=== Offline mode: request not sent ===\n")
+	fmt.Fprintf(&b, "Model: %s\n\n", model)
+	fmt.Fprintf(&b, "--- System prompt ---\n%s\n\n", systemPrompt)
+	fmt.Fprintf(&b, "--- Messages ---\n")
+	for _, message := range messages {
+		fmt.Fprintf(&b, "[%s]\n%s\n", message.Role, message.Content)
+	}
+	return b.String()
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nir/ai.go/internal/logger"
+	"github.com/nir/ai.go/internal/shell"
+)
+
+// maxCount is the largest number of alternatives --count can request, each one is a separate request
+const maxCount = 5
+
+// options holds the command line flags
+type options struct {
+	summarize bool
+	offline   bool
+	// printPrompt shows the system prompt and user message of each request, printPromptOnly exits instead of sending it
+	printPrompt     bool
+	printPromptOnly bool
+	exclude         stringList
+	// dirs lists the directories whose files are sent instead of the current directory's
+	dirs          stringList
+	noFiles       bool
+	maxFiles      int
+	tree          bool
+	gitContext    bool
+	gitLog        int
+	schemaVersion string
+	verbose       bool
+	quiet         bool
+	// appendPrompt holds extra instructions appended to the system prompt
+	appendPrompt stringList
+	// profile names the temperature profile of all requests, see config.TemperatureProfiles
+	profile string
+	// systemPromptFile replaces the built-in command suggestion system prompt
+	systemPromptFile string
+	yes              bool
+	confirmAll       bool
+	// minConfidence asks for confirmation of commands the model is less confident about, 0 disables it
+	minConfidence float64
+	// execute offers to run the suggestion in ask mode
+	execute bool
+	// singleStep runs only the first suggested command, whatever is_final and needs_output say
+	singleStep bool
+	// script collects the suggested commands into a shell script instead of running them, written to scriptOutput or stdout
+	script       bool
+	scriptOutput string
+	// commandOnly prints only the suggested command on stdout and never runs it
+	commandOnly bool
+	// jsonOutput prints the whole suggestion as JSON on stdout and never runs it, for ai run-json
+	jsonOutput bool
+	allowSudo  bool
+	fixPerms   bool
+	// noProgress disables the heartbeat shown while a command is silent
+	noProgress       bool
+	progressInterval time.Duration
+	// commandTimeout limits how long each executed command may run, 0 for no limit
+	commandTimeout time.Duration
+	trackChanges   bool
+	// preflight checks the provider's connectivity and credentials before the first request
+	preflight bool
+	// sandbox runs unsafe commands in a copy of the directory
+	sandbox bool
+	// confirmEdits backs up the files a command writes to and asks whether to keep each change
+	confirmEdits bool
+	// timestampOutput prefixes each line of command output in the log file with the time
+	timestampOutput bool
+	// recordInstalls records the packages installed by commands in the session
+	recordInstalls bool
+	// stdinFile and stdinString give the input passed to executed commands on stdin
+	stdinFile   string
+	stdinString string
+	sessionID   string
+	// resumeFromLog continues the last task found in the log, the query is optional then
+	resumeFromLog bool
+	contextLines  int
+	contextBytes  int
+	historyFilter string
+	// stream shows the suggestion's reason and command as they are generated
+	stream bool
+	// streamFeedback sends the output of a running command to the model in chunks, so it can stop the command early
+	streamFeedback bool
+	// explainErrors asks the model why a failed command failed and how to fix it, before the next step
+	explainErrors bool
+	// model overrides the configured model, remember stores it as the current directory's default
+	model    string
+	remember bool
+	// count asks for several alternative suggestions to choose from for the first command
+	count int
+	// maxOutputBytes caps the command output kept in memory and sent back to the model
+	maxOutputBytes    int
+	killOnOutputLimit bool
+	// unbuffered prints each line of command output as soon as it is read, instead of every consoleFlushInterval
+	unbuffered bool
+	// theme selects the console colors, overriding ai.cfg
+	theme string
+	// language is the language of the reasons in suggestions, overriding ai.cfg
+	language string
+	// stripANSI removes escape sequences from the output logged and sent to the model
+	stripANSI bool
+	// feedbackGrep limits the output sent back to the model to the lines matching it, feedbackPattern is its compiled form
+	feedbackGrep    string
+	feedbackPattern *regexp.Regexp
+}
+
+// stringList is a flag.Value collecting every occurrence of a repeatable flag
+type stringList []string
+
+// String returns the collected values
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set appends a value
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// parseOptions parses the command line flags and returns them with the remaining arguments
+func parseOptions() (*options, []string) {
+	opts := &options{}
+
+	flag.BoolVar(&opts.summarize, "summarize", false, "Summarize the output of each executed command")
+	flag.BoolVar(&opts.offline, "offline", false, "Print the request that would be sent to the model instead of sending it")
+	flag.BoolVar(&opts.printPrompt, "print-prompt", false, "Print the system prompt and user message before each request")
+	flag.BoolVar(&opts.printPromptOnly, "print-prompt-only", false, "Print the system prompt and user message of the first request, then exit without sending it")
+	flag.StringVar(&opts.schemaVersion, "schema-version", "", "Command response format: v1 (five fields) or v2 (adds side_effects and clarification), overrides ai.cfg")
+	flag.BoolVar(&opts.stream, "stream", false, "Stream the response and show the command as soon as it is generated, followed by the reason")
+	flag.StringVar(&opts.model, "model", "", "Model ID to use for this run, overrides AI_MODEL_ID, the directory's .ai.json and the provider config")
+	flag.BoolVar(&opts.remember, "remember", false, "With --model, save the model in the current directory's .ai.json so later runs there use it by default")
+	flag.IntVar(&opts.count, "count", 1, "Ask for this many alternative commands in parallel and choose one of them")
+	flag.BoolVar(&opts.streamFeedback, "stream-feedback", false, "Send the output of a running command to Claude in chunks, so it can stop the command early")
+	flag.StringVar(&opts.feedbackGrep, "feedback-grep", "", "Send only the lines of command output matching this regular expression back to Claude, the console and the log show all of it")
+	flag.BoolVar(&opts.explainErrors, "explain-errors", false, "When a command fails, ask Claude why and how to fix it before the next step")
+	flag.BoolVar(&opts.script, "script", false, "Collect the commands Claude suggests for a multi-step task into a bash script instead of running them, printed to stdout")
+	flag.StringVar(&opts.scriptOutput, "script-output", "", "With --script, write the script to this file instead of stdout")
+	flag.BoolVar(&opts.singleStep, "single-step", false, "Run only one command and stop, treating every suggestion as final instead of continuing with the next step")
+	flag.BoolVar(&opts.execute, "execute", false, "In ask mode, offer to run the suggested command after showing it")
+	flag.BoolVar(&opts.commandOnly, "command-only", false, "Print only the suggested command on stdout, without running it, for use in $(...)")
+	flag.BoolVar(&opts.jsonOutput, "json", false, "Print the suggestion as JSON on stdout, without running it, e.g. to review it and run it later with ai run-json")
+	flag.BoolVar(&opts.yes, "yes", false, "Run commands marked as unsafe without asking for confirmation")
+	flag.BoolVar(&opts.confirmAll, "confirm-all", false, "Ask for confirmation before every command, including those marked as safe")
+	flag.Float64Var(&opts.minConfidence, "min-confidence", 0, "Ask for confirmation before commands with a confidence below this value (0-1), even if marked as safe, requires schema v2")
+	flag.BoolVar(&opts.allowSudo, "allow-sudo", false, "Treat sudo/doas commands like any other command instead of always asking for confirmation")
+	flag.BoolVar(&opts.fixPerms, "fix-perms", false, "Restrict config files containing API keys to be readable by the owner only")
+	flag.IntVar(&opts.maxOutputBytes, "max-output-bytes", shell.DefaultMaxOutputBytes, "Maximum command output kept in memory, the rest is only shown (0 for no limit)")
+	flag.BoolVar(&opts.stripANSI, "strip-ansi", true, "Remove ANSI escape sequences such as colors from the command output logged and sent to the model, the console keeps them")
+	flag.BoolVar(&opts.killOnOutputLimit, "kill-on-output-limit", false, "Stop a command once its output exceeds --max-output-bytes")
+	flag.BoolVar(&opts.unbuffered, "unbuffered", false, "Print each line of command output as soon as it is read, instead of buffering it for up to 50ms, slower for commands with a lot of output")
+	flag.BoolVar(&opts.noProgress, "no-progress", false, "Don't show a \"still running\" message while a command produces no output")
+	flag.DurationVar(&opts.progressInterval, "progress-interval", 10*time.Second, "How long a command may be silent before a \"still running\" message is shown")
+	flag.DurationVar(&opts.commandTimeout, "command-timeout", 0, "Stop each executed command after this long (e.g. 60s) and tell Claude it timed out, 0 for no limit. Only the command's own run time counts, waiting for Claude has separate, fixed timeouts per request")
+	flag.BoolVar(&opts.trackChanges, "track-changes", false, "Report the files created, modified or deleted by each command")
+	flag.BoolVar(&opts.preflight, "preflight", false, "Check that the provider is reachable and accepts the credentials before sending the query, failing fast with a hint if not")
+	flag.BoolVar(&opts.confirmEdits, "confirm-edits", false, "Back up the existing files a command writes to, then show each change and ask whether to keep or revert it")
+	flag.BoolVar(&opts.sandbox, "sandbox", false, "Run commands Claude marks as unsafe in a temporary copy of the current directory and show their changes before applying them")
+	flag.BoolVar(&opts.timestampOutput, "timestamp-output", false, "Prefix each line of command output in the log file with the time it was printed, [HH:MM:SS], to see which steps are slow. The console is unaffected")
+	flag.StringVar(&opts.stdinFile, "stdin-file", "", "Pass the contents of this file to each executed command on stdin, - reads ai's own stdin")
+	flag.StringVar(&opts.stdinString, "stdin-string", "", "Pass this text to each executed command on stdin")
+	flag.BoolVar(&opts.recordInstalls, "record-installs", false, "Record the packages installed by commands in the session, shown by ai export, so they can be cleaned up later")
+	flag.StringVar(&opts.sessionID, "session-id", "", "Resume (or start) the session with this ID")
+	flag.BoolVar(&opts.resumeFromLog, "resume-from-log", false, "Continue the last task found in ~/.ai/action.log, e.g. after ai was interrupted, the query is optional")
+	flag.IntVar(&opts.contextLines, "context-lines", logger.DefaultHistoryLines, "Maximum number of command history lines sent as context")
+	flag.IntVar(&opts.contextBytes, "context-bytes", logger.DefaultHistoryBytes, "Maximum number of command history bytes sent as context")
+	flag.StringVar(&opts.historyFilter, "history-filter", "", "Log entries sent as command history: commands, commands+output or all, overrides ai.cfg (default commands+output)")
+	flag.StringVar(&opts.profile, "profile", "", "Temperature profile of the requests: precise (0), balanced (0.5), creative (0.9) or one from temperature_profiles in ai.cfg, overrides the configured temperature")
+	flag.StringVar(&opts.language, "language", "", "Language of the reasons and other text in suggestions, e.g. German, overrides ai.cfg. Commands are never translated")
+	flag.StringVar(&opts.theme, "theme", "", "Console colors: default, mono or high-contrast, overrides ai.cfg (NO_COLOR forces mono)")
+	flag.BoolVar(&opts.quiet, "quiet", false, "Don't print info messages on the console, they are still written to the log file")
+	flag.BoolVar(&opts.verbose, "verbose", false, "Log additional details, such as the model's reasoning, to the log file")
+	flag.Var(&opts.appendPrompt, "append-prompt", "Extra instruction appended to the system prompt (repeatable)")
+	flag.StringVar(&opts.systemPromptFile, "system-prompt-file", "", "Use this file as the system prompt instead of the built-in one, {{dir}}, {{files}} and {{history}} are substituted")
+	flag.BoolVar(&opts.noFiles, "no-files", false, "Don't send the list of files in the current directory to the model")
+	flag.IntVar(&opts.maxFiles, "max-files", 0, "Maximum number of files listed in the prompt, overrides ai.cfg (default 1000)")
+	flag.BoolVar(&opts.tree, "tree", false, "Show the files in the prompt as an indented directory tree instead of a flat list")
+	flag.BoolVar(&opts.gitContext, "git-context", false, "Include the git branch and a summary of changed files in the prompt")
+	flag.IntVar(&opts.gitLog, "git-log", 0, fmt.Sprintf("Include the subjects of the last N commits in the prompt, along with the --git-context summary (at most %d)", shell.MaxGitLog))
+	flag.Var(&opts.dirs, "dir", "Directory whose files are listed in the prompt instead of the current directory's (repeatable)")
+	flag.Var(&opts.exclude, "exclude", "Glob pattern of files or directories to leave out of the file list (repeatable)")
+
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: ai [flags] \"what you want to do\"")
+		fmt.Fprintln(flag.CommandLine.Output(), "       ai schema             Print the JSON Schema of the command response format")
+		fmt.Fprintln(flag.CommandLine.Output(), "       ai explain <command>  Explain what a command does without running it")
+		fmt.Fprintln(flag.CommandLine.Output(), "       ai export --session-id <id> [--format md|json]  Print a stored session")
+		fmt.Fprintln(flag.CommandLine.Output(), "       ai alias list|add|remove  Manage query aliases")
+		fmt.Fprintln(flag.CommandLine.Output(), "       ai doctor             Check the configuration and connectivity to the provider")
+		fmt.Fprintln(flag.CommandLine.Output(), "       ai reset [--all]      Delete the log and sessions, with --all the configuration too")
+		fmt.Fprintln(flag.CommandLine.Output(), "       ai run-json           Run a suggestion printed by ai --json, read from stdin, without asking the model")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if opts.yes && opts.confirmAll {
+		fmt.Fprintln(flag.CommandLine.Output(), "--yes and --confirm-all can't be used together")
+		os.Exit(2)
+	}
+
+	if opts.remember && opts.model == "" {
+		fmt.Fprintln(flag.CommandLine.Output(), "--remember needs --model")
+		os.Exit(2)
+	}
+
+	if opts.count < 1 || opts.count > maxCount {
+		fmt.Fprintf(flag.CommandLine.Output(), "--count must be between 1 and %d\n", maxCount)
+		os.Exit(2)
+	}
+
+	if opts.gitLog < 0 || opts.gitLog > shell.MaxGitLog {
+		fmt.Fprintf(flag.CommandLine.Output(), "--git-log must be between 0 and %d\n", shell.MaxGitLog)
+		os.Exit(2)
+	}
+
+	if opts.minConfidence < 0 || opts.minConfidence > 1 {
+		fmt.Fprintln(flag.CommandLine.Output(), "--min-confidence must be between 0 and 1")
+		os.Exit(2)
+	}
+
+	if opts.commandOnly && opts.execute {
+		fmt.Fprintln(flag.CommandLine.Output(), "--command-only and --execute can't be used together")
+		os.Exit(2)
+	}
+
+	if opts.jsonOutput && (opts.commandOnly || opts.execute) {
+		fmt.Fprintln(flag.CommandLine.Output(), "--json can't be used with --command-only or --execute")
+		os.Exit(2)
+	}
+
+	if opts.scriptOutput != "" && !opts.script {
+		fmt.Fprintln(flag.CommandLine.Output(), "--script-output needs --script")
+		os.Exit(2)
+	}
+
+	if opts.script && (opts.commandOnly || opts.jsonOutput || opts.execute) {
+		fmt.Fprintln(flag.CommandLine.Output(), "--script can't be used with --command-only, --json or --execute")
+		os.Exit(2)
+	}
+
+	if opts.stdinFile != "" && opts.stdinString != "" {
+		fmt.Fprintln(flag.CommandLine.Output(), "--stdin-file and --stdin-string can't be used together")
+		os.Exit(2)
+	}
+
+	if opts.resumeFromLog && opts.sessionID != "" {
+		fmt.Fprintln(flag.CommandLine.Output(), "--resume-from-log and --session-id can't be used together")
+		os.Exit(2)
+	}
+
+	if opts.commandTimeout < 0 {
+		fmt.Fprintln(flag.CommandLine.Output(), "--command-timeout can't be negative")
+		os.Exit(2)
+	}
+
+	if opts.feedbackGrep != "" {
+		pattern, err := regexp.Compile(opts.feedbackGrep)
+		if err != nil {
+			fmt.Fprintf(flag.CommandLine.Output(), "--feedback-grep is not a valid regular expression: %v\n", err)
+			os.Exit(2)
+		}
+		opts.feedbackPattern = pattern
+	}
+
+	if opts.contextLines <= 0 || opts.contextBytes <= 0 {
+		fmt.Fprintln(flag.CommandLine.Output(), "--context-lines and --context-bytes must be positive")
+		os.Exit(2)
+	}
+
+	return opts, flag.Args()
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nir/ai.go/internal/logger"
+)
+
+// preflightTimeout bounds the check made with --preflight
+const preflightTimeout = 15 * time.Second
+
+// preflight checks that the provider is reachable and accepts the credentials, reporting what to fix if not
+func preflight(ctx context.Context, client Client, log *logger.Logger) error {
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+
+	start := time.Now()
+	if err := client.Ping(ctx); err != nil {
+		log.LogError(fmt.Errorf("preflight check failed: %w", err))
+		if hint := connectivityHint(err); hint != "" {
+			fmt.Printf("%s%s%s\n", colorError, hint, colorReset)
+		}
+		fmt.Println("Run 'ai doctor' for a full check of the configuration.")
+		return err
+	}
+	log.LogInfo(fmt.Sprintf("Preflight check passed, the provider responded in %s", time.Since(start).Round(time.Millisecond)))
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nir/ai.go/internal/config"
+	"github.com/nir/ai.go/internal/openaicompat"
+)
+
+// configFiles are the configuration files in ~/.ai
+var configFiles = []string{openaicompat.ConfigFile, openaicompat.LocalConfigFile, "anthropic.cfg", "model.cfg", "ai.cfg", "aliases.json"}
+
+// historyFiles are the files in ~/.ai that record queries and command output
+var historyFiles = []string{"action.log"}
+
+// sessionsDir holds the session files, <id>.json
+const sessionsDir = "sessions"
+
+// runReset deletes the log and the sessions in ~/.ai, and with --all the configuration files too
+// Only known files are touched, symlinks are removed themselves and never followed
+func runReset(opts *options, args []string) int {
+	flags := flag.NewFlagSet("reset", flag.ContinueOnError)
+	all := flags.Bool("all", false, "Also delete the configuration files, including API keys and aliases")
+	yes := flags.Bool("yes", opts.yes, "Don't ask for confirmation")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: ai reset [--all] [--yes]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+
+	aiDir, err := config.Dir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find the config directory: %v\n", err)
+		return 1
+	}
+
+	names := historyFiles
+	if *all {
+		names = append(append([]string(nil), historyFiles...), configFiles...)
+	}
+	targets, err := resetTargets(aiDir, names)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(targets) == 0 {
+		fmt.Printf("Nothing to delete in %s\n", aiDir)
+		return 0
+	}
+
+	fmt.Printf("Files to delete from %s:\n", aiDir)
+	for _, target := range targets {
+		fmt.Printf("  %s\n", target)
+	}
+	if !*all {
+		fmt.Println("Configuration files are kept, pass --all to delete them too.")
+	}
+	if !*yes && !confirm("Delete them? (y/n): ") {
+		fmt.Println("Nothing was deleted.")
+		return 1
+	}
+
+	exitCode := 0
+	for _, target := range targets {
+		if err := os.Remove(filepath.Join(aiDir, target)); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "%sFailed to delete %s: %v%s\n", colorError, target, err, colorReset)
+			exitCode = 1
+			continue
+		}
+		fmt.Printf("%sDeleted %s%s\n", colorSuccess, target, colorReset)
+	}
+
+	// The sessions directory is removed once empty, it fails harmlessly if other files are left in it
+	if info, err := os.Lstat(filepath.Join(aiDir, sessionsDir)); err == nil && info.IsDir() {
+		os.Remove(filepath.Join(aiDir, sessionsDir))
+	}
+	return exitCode
+}
+
+// resetTargets returns the paths, relative to aiDir, of the named files and the session files that exist
+func resetTargets(aiDir string, names []string) ([]string, error) {
+	var targets []string
+	for _, name := range names {
+		info, err := os.Lstat(filepath.Join(aiDir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to check %s: %w", name, err)
+		}
+		if info.Mode().IsRegular() || info.Mode()&os.ModeSymlink != 0 {
+			targets = append(targets, name)
+		}
+	}
+
+	info, err := os.Lstat(filepath.Join(aiDir, sessionsDir))
+	switch {
+	case os.IsNotExist(err):
+		return targets, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to check %s: %w", sessionsDir, err)
+	case info.Mode()&os.ModeSymlink != 0:
+		// A symlinked sessions directory may point anywhere, so only the link is removed
+		return append(targets, sessionsDir), nil
+	case !info.IsDir():
+		return targets, nil
+	}
+
+	entries, err := os.ReadDir(filepath.Join(aiDir, sessionsDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".json") && (entry.Type().IsRegular() || entry.Type()&os.ModeSymlink != 0) {
+			targets = append(targets, filepath.Join(sessionsDir, entry.Name()))
+		}
+	}
+	return targets, nil
+}
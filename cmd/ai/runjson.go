@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/nir/ai.go/internal/clientopts"
+	"github.com/nir/ai.go/internal/command"
+	"github.com/nir/ai.go/internal/session"
+)
+
+// runJSONCommand is the subcommand running a suggestion printed by --json, e.g. ai --json "..." | ai run-json
+// Unlike the other subcommands it runs the command through the regular pipeline in main, so it isn't in subcommands
+const runJSONCommand = "run-json"
+
+// maxRunJSONBytes caps the suggestion read from stdin
+const maxRunJSONBytes = 1024 * 1024
+
+// errNoModel is returned when a run-json run would need another suggestion
+var errNoModel = errors.New("run-json runs a single command without a model, so there is nothing to continue with")
+
+// replayClient is a Client that returns a given suggestion instead of asking a model, once
+type replayClient struct {
+	mutex    sync.Mutex
+	response string
+}
+
+// readRunJSON reads a suggestion from r and returns a client replaying it
+// The suggestion is marked as final, as there is no model to continue with after it ran
+func readRunJSON(r io.Reader) (*replayClient, *command.Command, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxRunJSONBytes+1))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read the suggestion from stdin: %w", err)
+	}
+	if len(data) > maxRunJSONBytes {
+		return nil, nil, fmt.Errorf("the suggestion on stdin is larger than %d bytes", maxRunJSONBytes)
+	}
+	if strings.TrimSpace(string(data)) == "" {
+		return nil, nil, errors.New("no suggestion on stdin, pipe in the output of ai --json")
+	}
+
+	cmd, err := command.ParseCommandResponse(string(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	if strings.TrimSpace(cmd.Command) == "" {
+		return nil, nil, fmt.Errorf("the suggestion has no command, only the question: %s", cmd.Clarification)
+	}
+	// A plan would promise steps that never come
+	cmd.IsFinal, cmd.NeedsOutput, cmd.Plan = true, false, nil
+
+	response, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode suggestion: %w", err)
+	}
+	return &replayClient{response: string(response)}, cmd, nil
+}
+
+// checkRunJSON rejects arguments and the flags that need a model
+func checkRunJSON(opts *options, args []string) error {
+	switch {
+	case len(args) > 0:
+		return fmt.Errorf("%s takes no arguments, the suggestion is read from stdin, e.g. ai --json \"...\" | ai %s", runJSONCommand, runJSONCommand)
+	case opts.jsonOutput || opts.commandOnly || opts.script:
+		return fmt.Errorf("--json, --command-only and --script can't be used with %s", runJSONCommand)
+	case opts.count > 1:
+		return fmt.Errorf("--count can't be used with %s", runJSONCommand)
+	case opts.resumeFromLog:
+		return fmt.Errorf("--resume-from-log can't be used with %s", runJSONCommand)
+	case opts.stdinFile == "-":
+		return fmt.Errorf("--stdin-file - can't be used with %s, which reads the suggestion from stdin", runJSONCommand)
+	case opts.summarize || opts.streamFeedback || opts.explainErrors:
+		return fmt.Errorf("--summarize, --stream-feedback and --explain-errors need a model, so they can't be used with %s", runJSONCommand)
+	}
+	return nil
+}
+
+// printJSON prints a suggestion on a single line for --json
+func printJSON(w io.Writer, cmd *command.Command) {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode suggestion: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintln(w, string(data))
+}
+
+// reattachTerminal reads stdin from the terminal once the suggestion was read from a pipe, so confirmations can be answered
+// Without a terminal, stdin is left at its end and confirmations are declined
+func reattachTerminal() {
+	if tty, err := os.Open("/dev/tty"); err == nil {
+		os.Stdin = tty
+	}
+}
+
+// GetCommandSuggestion returns the suggestion read from stdin the first time, and errNoModel after
+func (r *replayClient) GetCommandSuggestion(ctx context.Context, turns []session.Message, userQuery, currentDir string, filesList []string, commandHistory string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	response := r.response
+	if response == "" {
+		return "", errNoModel
+	}
+	r.response = ""
+	return response, nil
+}
+
+// StreamCommandSuggestion returns the suggestion like GetCommandSuggestion, in a single piece
+func (r *replayClient) StreamCommandSuggestion(ctx context.Context, turns []session.Message, userQuery, currentDir string, filesList []string, commandHistory string, onText func(text string)) (string, error) {
+	response, err := r.GetCommandSuggestion(ctx, turns, userQuery, currentDir, filesList, commandHistory)
+	if err == nil {
+		onText(response)
+	}
+	return response, err
+}
+
+// Summarize fails, there is no model to summarize with
+func (r *replayClient) Summarize(ctx context.Context, output string) (string, error) {
+	return "", errNoModel
+}
+
+// ReviewOutput fails, there is no model to review with
+func (r *replayClient) ReviewOutput(ctx context.Context, request, cmd, output string) (string, error) {
+	return "", errNoModel
+}
+
+// ExplainCommand fails, there is no model to explain with
+func (r *replayClient) ExplainCommand(ctx context.Context, cmd string) (string, error) {
+	return "", errNoModel
+}
+
+// DiagnoseError fails, there is no model to diagnose with
+func (r *replayClient) DiagnoseError(ctx context.Context, cmd, output string, exitCode int) (string, error) {
+	return "", errNoModel
+}
+
+// Model tells that no model is used
+func (r *replayClient) Model() string {
+	return "none (" + runJSONCommand + ")"
+}
+
+// Ping succeeds, there is no provider to check
+func (r *replayClient) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Configure does nothing, the options only affect the requests that are never sent
+func (r *replayClient) Configure(update func(options *clientopts.Options)) {}
+
+// SystemPrompt returns nothing, no prompt is sent
+func (r *replayClient) SystemPrompt(turns []session.Message, userQuery, currentDir string, filesList []string, commandHistory string) (string, []session.Message) {
+	return "", nil
+}
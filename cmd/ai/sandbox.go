@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/nir/ai.go/internal/logger"
+	"github.com/nir/ai.go/internal/shell"
+)
+
+// reviewSandbox shows the files a command changed in its sandbox and applies the changes to the real directory
+// if the user approves, or right away with autoApply. The sandbox is removed afterwards.
+// It returns a note telling Claude where the command ran and what became of its changes.
+func reviewSandbox(sandbox *shell.Sandbox, autoApply bool, log *logger.Logger) string {
+	defer func() {
+		if err := sandbox.Close(); err != nil {
+			log.LogError(err)
+		}
+	}()
+
+	changes, err := sandbox.Changes()
+	if err != nil {
+		log.LogError(err)
+		return "\n[The command ran in a sandbox copy of the directory. Its changes couldn't be determined and were discarded, so the directory is unchanged]\n"
+	}
+
+	printChanges(changes, false)
+	if changes.Empty() {
+		return "\n[The command ran in a sandbox copy of the directory and changed no files there]\n"
+	}
+
+	if !autoApply && !confirm(fmt.Sprintf("Apply these changes to %s? (y/n): ", sandbox.Source)) {
+		log.LogInfo("Discarded the changes made in the sandbox")
+		fmt.Println("Changes discarded, the directory is unchanged.")
+		return "\n[The command ran in a sandbox copy of the directory. The user discarded its changes, so the directory is unchanged]\n"
+	}
+
+	if err := sandbox.Apply(changes); err != nil {
+		log.LogError(fmt.Errorf("failed to apply the sandbox changes: %w", err))
+		fmt.Printf("%s⚠️ Only some of the changes were applied: %v%s\n", colorWarning, err, colorReset)
+		return fmt.Sprintf("\n[The command ran in a sandbox copy of the directory. Applying its changes to the directory failed partway: %v]\n", err)
+	}
+	count := len(changes.Created) + len(changes.Modified) + len(changes.Deleted)
+	log.LogInfo(fmt.Sprintf("Applied %d changes from the sandbox to %s", count, sandbox.Source))
+	fmt.Printf("%s✅ Applied the changes to %s.%s\n", colorSuccess, sandbox.Source, colorReset)
+	return "\n[The command ran in a sandbox copy of the directory, and the user applied its changes to the directory]\n"
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/nir/ai.go/internal/command"
+)
+
+// maxScriptSteps caps the commands collected with --script, in case Claude never marks one as final
+const maxScriptSteps = 20
+
+// shellScript collects the commands suggested for a request with --script, instead of running them
+type shellScript struct {
+	query string
+	plan  []string
+	steps []*command.Command
+}
+
+// add collects a suggested command and returns the query asking Claude for the next one
+func (s *shellScript) add(cmd *command.Command) string {
+	s.steps = append(s.steps, cmd)
+	return fmt.Sprintf("I'm not running '%s' now but adding it to a script to run later, so I can't show you its output. "+
+		"Please provide the next command of the script, assuming the previous ones succeeded, to continue with my original request: %s",
+		cmd.Command, s.query)
+}
+
+// done reports whether the script is complete: the last command is final or the script reached maxScriptSteps
+func (s *shellScript) done() bool {
+	return len(s.steps) > 0 && (s.steps[len(s.steps)-1].IsFinal || len(s.steps) >= maxScriptSteps)
+}
+
+// render returns the collected commands as a bash script that stops at the first failure, with the reasons as comments
+// Commands whose output Claude needed are marked with a TODO, as the following ones were suggested without seeing it
+func (s *shellScript) render() string {
+	var b strings.Builder
+	b.WriteString("#!/usr/bin/env bash\n")
+	b.WriteString(comment("Generated by ai for: " + s.query))
+	b.WriteString("# Review every command before running this script.\n")
+	if len(s.plan) > 0 {
+		b.WriteString("#\n# Plan:\n")
+		for i, step := range s.plan {
+			b.WriteString(comment(fmt.Sprintf("  %d. %s", i+1, step)))
+		}
+	}
+	if len(s.steps) > 0 && !s.steps[len(s.steps)-1].IsFinal {
+		b.WriteString("#\n# TODO: Claude didn't mark the last command as final, more may be needed.\n")
+	}
+	b.WriteString("\nset -e\n")
+
+	for i, step := range s.steps {
+		b.WriteString("\n")
+		b.WriteString(comment(fmt.Sprintf("Step %d: %s", i+1, step.Reason)))
+		if !step.Safe {
+			b.WriteString("# Marked as not safe to run automatically.\n")
+		}
+		b.WriteString(step.Command)
+		b.WriteString("\n")
+		if step.NeedsOutput && i < len(s.steps)-1 {
+			b.WriteString("# TODO: Claude needed this command's output to choose the next steps, which were suggested without it. Check them against the actual output.\n")
+		}
+	}
+	return b.String()
+}
+
+// comment turns text into shell comment lines
+func comment(text string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		b.WriteString(strings.TrimRight("# "+line, " "))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// writeScript writes the script to path, executable so it can be run directly, or to w if path is empty
+func writeScript(w io.Writer, script, path string) error {
+	if path == "" {
+		_, err := fmt.Fprint(w, script)
+		return err
+	}
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		return fmt.Errorf("failed to write script: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/nir/ai.go/internal/logger"
+	"github.com/nir/ai.go/internal/shell"
+)
+
+// signalGracePeriod is how long a running command may take to exit after being signaled before it is killed
+const signalGracePeriod = 5 * time.Second
+
+// exitHandler runs the cleanups that have to happen however ai exits, such as flushing the audit webhook
+// os.Exit skips deferred calls, so once a cleanup is added every exit goes through exit.
+type exitHandler struct {
+	once     sync.Once
+	cleanups []func()
+}
+
+// add registers a cleanup, the cleanups run in reverse order like deferred calls
+// It must not be called once the cleanups may run.
+func (e *exitHandler) add(cleanup func()) {
+	e.cleanups = append(e.cleanups, cleanup)
+}
+
+// run runs the cleanups, only the first call does, concurrent calls wait for it to finish
+func (e *exitHandler) run() {
+	e.once.Do(func() {
+		for i := len(e.cleanups) - 1; i >= 0; i-- {
+			e.cleanups[i]()
+		}
+	})
+}
+
+// exit runs the cleanups and exits with code
+func (e *exitHandler) exit(code int) {
+	e.run()
+	os.Exit(code)
+}
+
+// handleSignals shuts down cleanly on SIGINT or SIGTERM, e.g. from a process manager
+// The request in flight is cancelled, the running command receives the signal, the cleanups of exits run
+// and the terminal is restored. It returns a function for code that notices the cancellation,
+// which waits for the shutdown to finish and exits the same way.
+func handleSignals(sh *shell.Shell, cancel context.CancelFunc, log *logger.Logger, exits *exitHandler) (exitOnSignal func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	exitCode := make(chan int, 1)
+
+	go func() {
+		sig := <-signals
+		log.LogInfo(fmt.Sprintf("Received %s, shutting down", sig))
+		cancel()
+		sh.Signal(sig, signalGracePeriod)
+		exits.run()
+		restoreTerminal()
+		log.Close()
+
+		code := signalExitCode(sig)
+		exitCode <- code
+		os.Exit(code)
+	}()
+
+	return func() {
+		os.Exit(<-exitCode)
+	}
+}
+
+// signalExitCode returns the exit code of a process killed by sig, 128 plus the signal number
+func signalExitCode(sig os.Signal) int {
+	if number, ok := sig.(syscall.Signal); ok {
+		return 128 + int(number)
+	}
+	return 1
+}
+
+// restoreTerminal shows the cursor and resets colors and the terminal mode, which a spinner may have changed
+func restoreTerminal() {
+	fmt.Print("\033[?25h\033[0m")
+	if shell.StdinIsTerminal() {
+		stty := exec.Command("stty", "sane")
+		stty.Stdin = os.Stdin
+		stty.Run()
+	}
+}
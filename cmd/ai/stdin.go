@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxStdinBytes caps the input given to executed commands with --stdin-file or --stdin-string
+const maxStdinBytes = 16 * 1024 * 1024
+
+// stdinPreviewBytes is how much of the input Claude is shown, so it can tell its format
+const stdinPreviewBytes = 1024
+
+// readCommandInput returns the input for executed commands given with --stdin-file or --stdin-string, and whether one was given
+// --stdin-file - reads ai's own stdin, which is then reattached to the terminal so confirmations can be answered
+func readCommandInput(opts *options) (string, bool, error) {
+	switch {
+	case opts.stdinString != "":
+		return opts.stdinString, true, nil
+	case opts.stdinFile == "":
+		return "", false, nil
+	}
+
+	var r io.Reader = os.Stdin
+	if opts.stdinFile != "-" {
+		file, err := os.Open(opts.stdinFile)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to open --stdin-file: %w", err)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, maxStdinBytes+1))
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read --stdin-file: %w", err)
+	}
+	if len(data) > maxStdinBytes {
+		return "", false, fmt.Errorf("the input of --stdin-file is larger than %d MiB", maxStdinBytes>>20)
+	}
+	if opts.stdinFile == "-" {
+		reattachTerminal()
+	}
+	return string(data), true, nil
+}
+
+// inputPreview returns the start of the input shown to Claude, cut at a character boundary
+// Invalid UTF-8 is replaced, so a stray byte doesn't hide the rest of the preview
+func inputPreview(input string) string {
+	if len(input) <= stdinPreviewBytes {
+		return strings.ToValidUTF8(input, string(utf8.RuneError))
+	}
+	end := stdinPreviewBytes
+	for end > 0 && !utf8.RuneStart(input[end]) {
+		end--
+	}
+	return strings.ToValidUTF8(input[:end], string(utf8.RuneError)) + "\n[...]"
+}
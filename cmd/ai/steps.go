@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nir/ai.go/internal/shell"
+)
+
+// maxStepCommandWidth caps the width of the command column in the summary of steps, longer commands are cut
+const maxStepCommandWidth = 60
+
+// stepRecord is a command that was executed during a task, as shown in the summary at the end
+type stepRecord struct {
+	command  string
+	safe     bool
+	status   string
+	duration time.Duration
+}
+
+// stepTracker numbers the commands executed during a task and summarizes them once it ends
+type stepTracker struct {
+	// total is the number of steps in Claude's plan, 0 when there is none
+	total   int
+	records []stepRecord
+}
+
+// label returns the number of the step about to run, e.g. "Step 3/5", or "Step 3/?" when the number of steps isn't known
+// A task may take more steps than planned, the total is unknown then too
+func (t *stepTracker) label() string {
+	step := len(t.records) + 1
+	if t.total > 0 && step <= t.total {
+		return fmt.Sprintf("Step %d/%d", step, t.total)
+	}
+	return fmt.Sprintf("Step %d/?", step)
+}
+
+// record adds an executed command, stopped tells whether Claude stopped it early with --stream-feedback
+func (t *stepTracker) record(command string, safe bool, execErr error, stopped bool, duration time.Duration) {
+	t.records = append(t.records, stepRecord{
+		command:  command,
+		safe:     safe,
+		status:   stepStatus(execErr, stopped),
+		duration: duration,
+	})
+}
+
+// printSummary prints a table of the executed commands, only for tasks that took more than one
+func (t *stepTracker) printSummary() {
+	if len(t.records) < 2 {
+		return
+	}
+
+	fmt.Printf("\n%s📊 Summary of %d steps:%s\n", colorInfo, len(t.records), colorReset)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tCommand\tSafety\tStatus\tDuration")
+	for i, record := range t.records {
+		safety := "safe"
+		if !record.safe {
+			safety = "unsafe"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", i+1, stepCommand(record.command), safety, record.status, formatDuration(record.duration))
+	}
+	w.Flush()
+}
+
+// stepStatus describes how an executed command ended, in a few words
+func stepStatus(execErr error, stopped bool) string {
+	code := shell.ExitCode(execErr)
+	switch {
+	case stopped:
+		return "stopped by Claude"
+	case errors.Is(execErr, context.DeadlineExceeded):
+		return "timed out"
+	case code >= 0:
+		return fmt.Sprintf("exit %d", code)
+	default:
+		return "failed to run"
+	}
+}
+
+// stepCommand returns a command on a single line, cut to maxStepCommandWidth characters
+func stepCommand(command string) string {
+	command = strings.Join(strings.Fields(command), " ")
+	runes := []rune(command)
+	if len(runes) > maxStepCommandWidth {
+		return string(runes[:maxStepCommandWidth-1]) + "…"
+	}
+	return command
+}
+
+// formatDuration rounds a duration to a precision that suits its length, e.g. 350ms, 4.2s or 3m12s
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	case d < time.Minute:
+		return d.Round(100 * time.Millisecond).String()
+	default:
+		return d.Round(time.Second).String()
+	}
+}
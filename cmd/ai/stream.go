@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/nir/ai.go/internal/command"
+	"github.com/nir/ai.go/internal/session"
+)
+
+// streamSuggestion streams a command suggestion, showing the command as soon as it is generated and then the reason
+// The returned response is still parsed as a whole, so a stream that can't be parsed incrementally is only shown at the end
+func streamSuggestion(ctx context.Context, client Client, turns []session.Message, userQuery, currentDir string, filesList []string, commandHistory string) (string, error) {
+	view := &streamView{}
+	streamer := command.NewFieldStreamer(func(name string, value interface{}) {
+		text, ok := value.(string)
+		if !ok {
+			return
+		}
+		switch name {
+		case "reason":
+			view.reason, view.reasonDone = text, true
+			view.showReason()
+		case "command":
+			fmt.Printf("Command: %s%s%s\n", colorCommand, text, colorReset)
+			view.commandShown = true
+			view.showReason()
+		}
+	})
+	streamer.SetPartialHandler(func(name, text string) {
+		if name == "reason" {
+			view.reason = text
+			view.showReason()
+		}
+	})
+
+	fmt.Printf("\n%s💭 Claude is responding...%s\n", colorInfo, colorReset)
+	response, err := client.StreamCommandSuggestion(ctx, turns, userQuery, currentDir, filesList, commandHistory, streamer.Write)
+	view.finish()
+	return response, err
+}
+
+// streamView shows the command of a streamed suggestion before its reason, whichever the model generates first
+// The reason is shown as it is generated once the command was shown, and held back until then
+type streamView struct {
+	commandShown bool
+	// reason is the reason generated so far, reasonDone is set once it is complete
+	reason     string
+	reasonDone bool
+	// printed is the start of the reason already printed, after the "Reason: " label once started is set
+	printed string
+	started bool
+	ended   bool
+}
+
+// showReason prints the part of the reason that wasn't printed yet, once the command was shown
+func (v *streamView) showReason() {
+	if !v.commandShown || v.ended || (v.reason == "" && !v.reasonDone) || !strings.HasPrefix(v.reason, v.printed) {
+		return
+	}
+	if !v.started {
+		fmt.Print("Reason: ")
+		v.started = true
+	}
+	fmt.Print(v.reason[len(v.printed):])
+	v.printed = v.reason
+	if v.reasonDone {
+		fmt.Println()
+		v.ended = true
+	}
+}
+
+// finish shows a reason held back for a command that never came, and ends a reason cut off by the end of the stream
+func (v *streamView) finish() {
+	switch {
+	case !v.commandShown && v.reason != "":
+		fmt.Printf("Reason: %s\n", v.reason)
+	case v.started && !v.ended:
+		fmt.Println()
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nir/ai.go/internal/clientopts"
+	"github.com/nir/ai.go/internal/command"
+	"github.com/nir/ai.go/internal/config"
+	"github.com/nir/ai.go/internal/logger"
+)
+
+// subcommand is a command run instead of a query, e.g. "ai schema"
+type subcommand struct {
+	// run runs the subcommand with the arguments following its name and returns the process exit code
+	run func(opts *options, args []string) int
+	// accepts reports whether the arguments following the name are the subcommand's,
+	// so a query that only starts with the name, e.g. "ai export the table to csv", is still sent as a query
+	accepts func(args []string) bool
+}
+
+// subcommands maps subcommand names to the subcommands
+var subcommands map[string]subcommand
+
+// init registers the subcommands, this can't be done in the declaration because "alias" refers back to the map
+func init() {
+	subcommands = map[string]subcommand{
+		"schema":  {run: runSchema, accepts: noArgs},
+		"explain": {run: runExplain, accepts: explainArgs},
+		"export":  {run: runExport, accepts: flagArgs},
+		"alias":   {run: runAlias, accepts: aliasArgs},
+		"doctor":  {run: runDoctor, accepts: noArgs},
+		"reset":   {run: runReset, accepts: flagArgs},
+	}
+}
+
+// noArgs accepts no arguments
+func noArgs(args []string) bool {
+	return len(args) == 0
+}
+
+// flagArgs accepts arguments starting with a flag, the subcommand's flag set rejects the ones it doesn't know
+func flagArgs(args []string) bool {
+	return len(args) == 0 || strings.HasPrefix(args[0], "-")
+}
+
+// explainArgs accepts the command as a single argument, or following "--"
+func explainArgs(args []string) bool {
+	return len(args) <= 1 || args[0] == "--"
+}
+
+// aliasArgs accepts the alias actions
+func aliasArgs(args []string) bool {
+	return len(args) == 0 || args[0] == "list" || args[0] == "add" || args[0] == "remove"
+}
+
+// findSubcommand returns the subcommand named by the first argument, if the arguments following it are the subcommand's
+// The name has to be an argument of its own, "ai 'explain why the disk is full'" is a query.
+func findSubcommand(args []string) (subcommand, bool) {
+	sub, ok := subcommands[args[0]]
+	if !ok || !sub.accepts(args[1:]) {
+		return subcommand{}, false
+	}
+	return sub, true
+}
+
+// runSubcommand runs the subcommand named by the first argument, if there is one
+func runSubcommand(opts *options, args []string) (exitCode int, handled bool) {
+	sub, ok := findSubcommand(args)
+	if !ok {
+		return 0, false
+	}
+	return sub.run(opts, args[1:]), true
+}
+
+// runSchema prints the JSON Schema of the selected version of the command response format
+func runSchema(opts *options, args []string) int {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		return 1
+	}
+
+	version, err := schemaVersion(opts, cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	schema, err := command.JSONSchema(version)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to generate schema: %v\n", err)
+		return 1
+	}
+
+	fmt.Println(string(schema))
+	return 0
+}
+
+// runExplain explains a shell command without running it
+// Unlike a regular request it doesn't look at the current directory, only the command is sent
+func runExplain(opts *options, args []string) int {
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	cmd := strings.TrimSpace(strings.Join(args, " "))
+	if cmd == "" {
+		fmt.Fprintln(os.Stderr, "Usage: ai explain \"<command>\"")
+		fmt.Fprintln(os.Stderr, "       ai explain -- <command>")
+		return 2
+	}
+
+	log, err := logger.New()
+	if err != nil {
+		fmt.Printf("Failed to initialize logger: %v\n", err)
+		return 1
+	}
+	defer log.Close()
+	if opts.quiet {
+		log.SetConsoleLevel(logger.LevelError)
+	}
+
+	client, err := getClient(log)
+	if err != nil {
+		log.LogError(err)
+		return 1
+	}
+	client.Configure(func(clientOptions *clientopts.Options) {
+		if opts.verbose {
+			clientOptions.ThinkingHandler = log.LogThinking
+		}
+		clientOptions.ServedModelHandler = servedModelLogger(log)
+	})
+	cfg, err := config.Load()
+	if err != nil {
+		log.LogError(fmt.Errorf("failed to load config: %w", err))
+		return 1
+	}
+	if err := applyTemperatureProfile(client, opts, cfg, log); err != nil {
+		log.LogError(err)
+		return 1
+	}
+	if opts.offline {
+		client = offlineClient{client: client}
+	}
+
+	explanation, err := waitWithSpinner(context.Background(), func(ctx context.Context) (string, error) {
+		return client.ExplainCommand(ctx, cmd)
+	})
+	if err != nil {
+		log.LogError(fmt.Errorf("failed to explain command: %w", err))
+		return 1
+	}
+
+	fmt.Printf("\n%s📖 %s%s\n\n%s\n", colorSuccess, cmd, colorReset, strings.TrimSpace(explanation))
+	return 0
+}
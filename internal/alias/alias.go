@@ -0,0 +1,123 @@
+package alias
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/nir/ai.go/internal/config"
+)
+
+// validName restricts alias names to a single word
+var validName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// templateData is the data available to alias templates
+type templateData struct {
+	// Args holds the arguments given after the alias name, joined by spaces
+	Args string
+}
+
+// Load reads the aliases from ~/.ai/aliases.json, mapping names to query templates
+// A missing file is not an error, no aliases are returned instead
+func Load() (map[string]string, error) {
+	aliasPath, err := path()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(aliasPath)
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read aliases file: %w", err)
+	}
+
+	aliases := map[string]string{}
+	if err := json.Unmarshal(data, &aliases); err != nil {
+		return nil, fmt.Errorf("failed to parse aliases file: %w", err)
+	}
+	return aliases, nil
+}
+
+// Save writes the aliases to ~/.ai/aliases.json
+func Save(aliases map[string]string) error {
+	aliasPath, err := path()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(aliases, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal aliases: %w", err)
+	}
+
+	if err := os.WriteFile(aliasPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write aliases file: %w", err)
+	}
+	return nil
+}
+
+// ValidateName checks that an alias name is a single word
+func ValidateName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid alias name %q: only letters, digits, '-' and '_' are allowed", name)
+	}
+	return nil
+}
+
+// ValidateTemplate checks that a query template can be parsed
+func ValidateTemplate(queryTemplate string) error {
+	if _, err := template.New("alias").Option("missingkey=error").Parse(queryTemplate); err != nil {
+		return fmt.Errorf("invalid alias template: %w", err)
+	}
+	return nil
+}
+
+// Expand renders a query template with the extra arguments
+// Templates without {{.Args}} get the arguments appended instead, so they are never silently dropped
+func Expand(queryTemplate string, args []string) (string, error) {
+	tmpl, err := template.New("alias").Option("missingkey=error").Parse(queryTemplate)
+	if err != nil {
+		return "", fmt.Errorf("invalid alias template: %w", err)
+	}
+
+	joined := strings.Join(args, " ")
+	var b strings.Builder
+	if err := tmpl.Execute(&b, templateData{Args: joined}); err != nil {
+		return "", fmt.Errorf("failed to expand alias template: %w", err)
+	}
+
+	query := b.String()
+	if joined != "" && !strings.Contains(queryTemplate, ".Args") {
+		query += " " + joined
+	}
+	return strings.TrimSpace(query), nil
+}
+
+// Resolve turns command line arguments into a query, expanding the first argument if it is an alias
+func Resolve(args []string) (string, error) {
+	aliases, err := Load()
+	if err != nil {
+		return "", err
+	}
+
+	queryTemplate, ok := aliases[args[0]]
+	if !ok {
+		return strings.Join(args, " "), nil
+	}
+	return Expand(queryTemplate, args[1:])
+}
+
+// path returns the path of the aliases file
+func path() (string, error) {
+	aiDir, err := config.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(aiDir, "aliases.json"), nil
+}
@@ -1,30 +1,94 @@
 package anthropic
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
+
+	"github.com/nir/ai.go/internal/clientopts"
+	"github.com/nir/ai.go/internal/command"
+	"github.com/nir/ai.go/internal/config"
+	"github.com/nir/ai.go/internal/httpheaders"
+	"github.com/nir/ai.go/internal/network"
+	"github.com/nir/ai.go/internal/prompt"
+	"github.com/nir/ai.go/internal/ratelimit"
+	"github.com/nir/ai.go/internal/recorder"
+	"github.com/nir/ai.go/internal/requestid"
+	"github.com/nir/ai.go/internal/session"
+	"golang.org/x/time/rate"
 )
 
 // ModelID is the Claude 3.7 Sonnet model ID
 const ModelID = "claude-3-7-sonnet-20250219"
 
+// APIVersion is the default value of the anthropic-version header
+const APIVersion = "2023-06-01"
+
+// apiBaseURL is the address of the Anthropic API
+const apiBaseURL = "https://api.anthropic.com"
+
+// modelIDPattern matches Anthropic API model IDs, e.g. claude-3-7-sonnet-20250219 or claude-3-7-sonnet-latest
+var modelIDPattern = regexp.MustCompile(`^claude-[a-z0-9.-]+$`)
+
+// ValidateModel checks that a model ID looks like a valid Anthropic API model ID
+func ValidateModel(modelID string) error {
+	if !modelIDPattern.MatchString(modelID) {
+		return fmt.Errorf("model_id %q doesn't look like an Anthropic model ID (expected something like %q)", modelID, ModelID)
+	}
+	return nil
+}
+
 // ClientConfig holds the configuration for the Anthropic client
 type ClientConfig struct {
 	APIKey  string `json:"api_key,omitempty"`
 	ModelID string `json:"model_id,omitempty"`
+	// APIKeyFile is a path to a file containing the API key (takes precedence over APIKey)
+	APIKeyFile string `json:"api_key_file,omitempty"`
+	// APIKeyCommand is a command whose output is the API key (takes precedence over APIKeyFile)
+	APIKeyCommand string `json:"api_key_command,omitempty"`
+	// ThinkingBudgetTokens enables extended thinking with the given token budget (0 disables it)
+	ThinkingBudgetTokens int `json:"thinking_budget_tokens,omitempty"`
+	// RequestsPerMinute limits the request rate on the client side (0 disables it)
+	RequestsPerMinute int `json:"requests_per_minute,omitempty"`
+	// APIVersion is sent as the anthropic-version header (defaults to APIVersion)
+	APIVersion string `json:"api_version,omitempty"`
+	// BetaFeatures are sent as the anthropic-beta header to enable beta features, e.g. "prompt-caching-2024-07-31"
+	BetaFeatures []string `json:"beta_features,omitempty"`
+	// Temperature is the sampling temperature of requests (defaults to 0.5)
+	Temperature *float64 `json:"temperature,omitempty"`
+	// MaxTokens is the initial max_tokens of command suggestions (defaults to 2048)
+	MaxTokens int `json:"max_tokens,omitempty"`
+	// ContextTokens is the model's context window, suggestion requests that wouldn't fit are trimmed
+	// (defaults to the window of known models, trimming is disabled for unknown ones)
+	ContextTokens int `json:"context_tokens,omitempty"`
+	// Headers are added to every request, e.g. for a gateway in front of the API
+	Headers map[string]string `json:"headers,omitempty"`
+	// OverrideHeaders lets Headers replace the headers the client sets, such as x-api-key
+	OverrideHeaders bool `json:"override_headers,omitempty"`
 }
 
+// protectedHeaders are set by the client itself and can only be replaced with OverrideHeaders
+var protectedHeaders = []string{"Content-Type", "x-api-key", "anthropic-version", "anthropic-beta"}
+
 // AnthropicClient handles interactions with Anthropic API
 type AnthropicClient struct {
-	config *ClientConfig
+	config  *ClientConfig
+	limiter *rate.Limiter
+	// baseURL is the address requests are sent to, apiBaseURL except in tests
+	baseURL string
+
+	clientopts.Holder
 }
 
 // MessageContent represents a content item in a message
@@ -39,45 +103,43 @@ type Message struct {
 	Content []MessageContent `json:"content,omitempty"`
 }
 
+// ThinkingConfig enables extended thinking on a request
+type ThinkingConfig struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
 // AnthropicRequest represents the request to Claude
 type AnthropicRequest struct {
-	Model       string    `json:"model"`
-	MaxTokens   int       `json:"max_tokens"`
-	Temperature float64   `json:"temperature"`
-	System      string    `json:"system,omitempty"`
-	Messages    []Message `json:"messages"`
+	Model       string          `json:"model"`
+	MaxTokens   int             `json:"max_tokens"`
+	Temperature float64         `json:"temperature"`
+	System      string          `json:"system,omitempty"`
+	Messages    []Message       `json:"messages"`
+	Thinking    *ThinkingConfig `json:"thinking,omitempty"`
+	Stream      bool            `json:"stream,omitempty"`
+	// StopSequences end generation as soon as one of them is produced
+	StopSequences []string `json:"stop_sequences,omitempty"`
 }
 
 // AnthropicResponse represents the response from Claude
 type AnthropicResponse struct {
 	Content []struct {
-		Type string `json:"type"`
-		Text string `json:"text"`
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		Thinking string `json:"thinking,omitempty"`
 	} `json:"content"`
-	Model      string `json:"model"`
-	StopReason string `json:"stop_reason"`
-}
-
-// Command represents the parsed command response from the model
-type Command struct {
-	Safe        bool   `json:"safe"`
-	Command     string `json:"command"`
-	Reason      string `json:"reason"`
-	IsFinal     bool   `json:"is_final"`
-	NeedsOutput bool   `json:"needs_output"`
+	Model        string `json:"model"`
+	StopReason   string `json:"stop_reason"`
+	StopSequence string `json:"stop_sequence,omitempty"`
 }
 
 // loadClientConfig loads the client configuration from ~/.ai/anthropic.cfg
 func loadClientConfig() (*ClientConfig, error) {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get user home directory: %w", err)
-	}
-
 	// Ensure the .ai directory exists
-	aiDir := filepath.Join(homeDir, ".ai")
-	if err := os.MkdirAll(aiDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create .ai directory: %w", err)
+	aiDir, err := config.Dir()
+	if err != nil {
+		return nil, err
 	}
 
 	configPath := filepath.Join(aiDir, "anthropic.cfg")
@@ -95,10 +157,14 @@ func loadClientConfig() (*ClientConfig, error) {
 			return nil, fmt.Errorf("failed to marshal default config: %w", err)
 		}
 
-		if err := os.WriteFile(configPath, configData, 0644); err != nil {
+		// The config may hold an API key, so keep it private to the user
+		if err := os.WriteFile(configPath, configData, 0600); err != nil {
 			return nil, fmt.Errorf("failed to write default config file: %w", err)
 		}
 
+		if err := applyModelOverrides(&defaultConfig); err != nil {
+			return nil, err
+		}
 		return &defaultConfig, nil
 	}
 
@@ -113,19 +179,134 @@ func loadClientConfig() (*ClientConfig, error) {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
+	// Warn if an API key is stored in a file other users can read
+	if config.APIKey != "" {
+		if info, err := os.Stat(configPath); err == nil && hasInsecurePermissions(info.Mode()) {
+			fmt.Fprintf(os.Stderr, "Warning: %s contains an API key and is readable by other users (mode %04o). Run with --fix-perms or chmod 600 it.\n",
+				configPath, info.Mode().Perm())
+		}
+	}
+
 	// Use default model ID if not specified
 	if config.ModelID == "" {
 		config.ModelID = ModelID
 	}
+	if config.APIVersion == "" {
+		config.APIVersion = APIVersion
+	}
+
+	// Resolve the API key from a command or file if configured
+	apiKey, err := resolveAPIKey(&config)
+	if err != nil {
+		return nil, err
+	}
+	config.APIKey = apiKey
 
 	// Check for API key in environment if not in config
 	if config.APIKey == "" {
 		config.APIKey = os.Getenv("ANTHROPIC_API_KEY")
 	}
 
+	if err := applyModelOverrides(&config); err != nil {
+		return nil, err
+	}
 	return &config, nil
 }
 
+// applyModelOverrides applies the AI_* environment variables, which take precedence over the file, and the defaults
+func applyModelOverrides(clientConfig *ClientConfig) error {
+	overrides, err := config.LoadModelOverrides()
+	if err != nil {
+		return err
+	}
+
+	if overrides.ModelID != "" {
+		clientConfig.ModelID = overrides.ModelID
+	}
+	if overrides.Temperature != nil {
+		clientConfig.Temperature = overrides.Temperature
+	}
+	if overrides.MaxTokens > 0 {
+		clientConfig.MaxTokens = overrides.MaxTokens
+	}
+	if overrides.ContextTokens > 0 {
+		clientConfig.ContextTokens = overrides.ContextTokens
+	}
+
+	if clientConfig.Temperature == nil {
+		temperature := config.DefaultTemperature
+		clientConfig.Temperature = &temperature
+	}
+	if clientConfig.MaxTokens == 0 {
+		clientConfig.MaxTokens = config.DefaultMaxTokens
+	}
+	if clientConfig.ContextTokens == 0 {
+		clientConfig.ContextTokens = prompt.ContextTokens(clientConfig.ModelID)
+	}
+	return nil
+}
+
+// hasInsecurePermissions reports whether a file mode allows group or world read access
+func hasInsecurePermissions(mode os.FileMode) bool {
+	return mode.Perm()&0044 != 0
+}
+
+// FixConfigPermissions restricts ~/.ai/anthropic.cfg to be readable by the owner only
+func FixConfigPermissions() error {
+	aiDir, err := config.Dir()
+	if err != nil {
+		return err
+	}
+
+	configPath := filepath.Join(aiDir, "anthropic.cfg")
+	info, err := os.Stat(configPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat config file: %w", err)
+	}
+
+	if !hasInsecurePermissions(info.Mode()) {
+		return nil
+	}
+
+	if err := os.Chmod(configPath, 0600); err != nil {
+		return fmt.Errorf("failed to fix config file permissions: %w", err)
+	}
+	return nil
+}
+
+// resolveAPIKey returns the API key from api_key_command, api_key_file or api_key, in that order
+func resolveAPIKey(config *ClientConfig) (string, error) {
+	if config.APIKeyCommand != "" {
+		output, err := exec.Command("bash", "-c", config.APIKeyCommand).Output()
+		if err != nil {
+			return "", fmt.Errorf("failed to run api_key_command: %w", err)
+		}
+		return strings.TrimSpace(string(output)), nil
+	}
+
+	if config.APIKeyFile != "" {
+		keyPath := config.APIKeyFile
+		if strings.HasPrefix(keyPath, "~/") {
+			homeDir, err := os.UserHomeDir()
+			if err != nil {
+				return "", fmt.Errorf("failed to get user home directory: %w", err)
+			}
+			keyPath = filepath.Join(homeDir, keyPath[2:])
+		}
+
+		keyData, err := os.ReadFile(keyPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read api_key_file: %w", err)
+		}
+		return strings.TrimSpace(string(keyData)), nil
+	}
+
+	return strings.TrimSpace(config.APIKey), nil
+}
+
 // NewAnthropicClient creates a new client for Anthropic API
 func NewAnthropicClient() (*AnthropicClient, error) {
 	clientConfig, err := loadClientConfig()
@@ -138,164 +319,451 @@ func NewAnthropicClient() (*AnthropicClient, error) {
 		return nil, errors.New("Anthropic API key not found in config or environment variable ANTHROPIC_API_KEY")
 	}
 
+	// Catch typos in the model ID before the first request fails with an opaque API error
+	if err := ValidateModel(clientConfig.ModelID); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", err)
+	}
+
+	if err := httpheaders.Validate(clientConfig.Headers, clientConfig.OverrideHeaders, protectedHeaders...); err != nil {
+		return nil, err
+	}
+
 	return &AnthropicClient{
-		config: clientConfig,
+		config:  clientConfig,
+		limiter: ratelimit.New(clientConfig.RequestsPerMinute),
+		baseURL: apiBaseURL,
 	}, nil
 }
 
-// ParseCommandResponse parses the model's response into a command structure
-func ParseCommandResponse(responseText string) (*Command, error) {
-	// Check if the response is wrapped in markdown code block
-	jsonText := responseText
+// Model returns the configured model ID
+func (c *AnthropicClient) Model() string {
+	return c.config.ModelID
+}
 
-	// Strip markdown code block formatting if present
-	markdownStart := "```json"
-	markdownEnd := "```"
-	if strings.Contains(jsonText, markdownStart) {
-		startIndex := strings.Index(jsonText, markdownStart) + len(markdownStart)
-		endIndex := strings.LastIndex(jsonText, markdownEnd)
-		if endIndex > startIndex {
-			jsonText = jsonText[startIndex:endIndex]
-		}
+// Headers returns the custom headers added to every request
+func (c *AnthropicClient) Headers() map[string]string {
+	return c.config.Headers
+}
+
+// SystemPrompt builds the system prompt of a command suggestion request and the earlier turns sent with it,
+// trimmed to fit the model's context window as the request is
+func (c *AnthropicClient) SystemPrompt(turns []session.Message, userQuery, currentDir string, filesList []string, commandHistory string) (string, []session.Message) {
+	systemPrompt, turns, _ := c.fitToContext(turns, userQuery, currentDir, filesList, commandHistory, c.config.MaxTokens+c.config.ThinkingBudgetTokens)
+	return systemPrompt, turns
+}
+
+// applyThinking enables extended thinking on the request if a budget is configured
+func (c *AnthropicClient) applyThinking(request *AnthropicRequest) {
+	if c.config.ThinkingBudgetTokens <= 0 {
+		return
+	}
+
+	request.Thinking = &ThinkingConfig{
+		Type:         "enabled",
+		BudgetTokens: c.config.ThinkingBudgetTokens,
+	}
+	// Extended thinking requires a temperature of 1 and max_tokens above the budget
+	request.Temperature = 1
+	request.MaxTokens += c.config.ThinkingBudgetTokens
+}
+
+// buildMessages converts the previous conversation turns and the new query into request messages
+func buildMessages(turns []session.Message, userQuery string) []Message {
+	messages := make([]Message, 0, len(turns)+1)
+	for _, turn := range turns {
+		messages = append(messages, Message{
+			Role:    turn.Role,
+			Content: []MessageContent{{Type: "text", Text: turn.Content}},
+		})
 	}
 
-	// Trim any leading/trailing whitespace
-	jsonText = strings.TrimSpace(jsonText)
+	return append(messages, Message{
+		Role:    "user",
+		Content: []MessageContent{{Type: "text", Text: userQuery}},
+	})
+}
 
-	var cmd Command
-	if err := json.Unmarshal([]byte(jsonText), &cmd); err != nil {
-		return nil, fmt.Errorf("failed to parse command response: %w", err)
+// jsonPrefill starts the assistant turn so the model has to answer with a JSON object
+const jsonPrefill = "{"
+
+// jsonStopSequence is the closing brace of the JSON object, raw newlines can't appear inside JSON strings
+// so it stops generation before any chatter following the object.
+// It only matches pretty-printed JSON: a compact object is followed by whatever the model adds after it,
+// which withPrefill drops.
+const jsonStopSequence = "\n}"
+
+// withPrefill completes a response with the text the assistant turn was prefilled with
+// When prefilled, the response is an object that may be followed by prose the stop sequence didn't catch,
+// so only the object is kept. A response that isn't a complete object is returned as is, for the parser to report.
+func withPrefill(prefill, responseText string) string {
+	if prefill == "" {
+		return responseText
 	}
-	return &cmd, nil
+	return command.ExtractJSONObject(prefill + responseText)
 }
 
-// GetCommandSuggestion asks the model for command suggestions
-func (c *AnthropicClient) GetCommandSuggestion(ctx context.Context, userQuery, currentDir string, filesList []string, commandHistory string) (string, error) {
-	// Create system prompt with history if provided
-	var systemPrompt string
-	if commandHistory != "" {
-		systemPrompt = fmt.Sprintf(
-			"You are an AI assistant providing shell commands to execute tasks. Your job is to translate user requests into the exact commands needed.\n"+
-				"Current directory: %s\n"+
-				"Files in directory (limited to 1000): %v\n\n"+
-				"Recent command history (for context):\n%s\n\n"+
-				"Provide the exact command or commands to run in response to the user's request. "+
-				"Format your response as JSON with these fields:\n"+
-				"- 'safe': a boolean indicating if the command is safe to run automatically\n"+
-				"- 'command': the exact command(s) to run\n"+
-				"- 'reason': a brief explanation of what the command does\n"+
-				"- 'is_final': a boolean indicating if this is the final command to complete the user's request (true) or if more commands will be needed (false)\n"+
-				"- 'needs_output': a boolean indicating if you need to see the output of this command to determine the next step\n\n"+
-				"If you need more information, respond with JSON where 'needs_output' is true and the 'command' field contains the command needed to gather that information. "+
-				"The output of this command will be shown to you.\n\n"+
-				"IMPORTANT: Return ONLY the raw JSON data without any markdown formatting like ```json or ```. Just the plain JSON object.",
-			currentDir, filesList, commandHistory)
-	} else {
-		systemPrompt = fmt.Sprintf(
-			"You are an AI assistant providing shell commands to execute tasks. Your job is to translate user requests into the exact commands needed.\n"+
-				"Current directory: %s\n"+
-				"Files in directory (limited to 1000): %v\n\n"+
-				"Provide the exact command or commands to run in response to the user's request. "+
-				"Format your response as JSON with these fields:\n"+
-				"- 'safe': a boolean indicating if the command is safe to run automatically\n"+
-				"- 'command': the exact command(s) to run\n"+
-				"- 'reason': a brief explanation of what the command does\n"+
-				"- 'is_final': a boolean indicating if this is the final command to complete the user's request (true) or if more commands will be needed (false)\n"+
-				"- 'needs_output': a boolean indicating if you need to see the output of this command to determine the next step\n\n"+
-				"If you need more information, respond with JSON where 'needs_output' is true and the 'command' field contains the command needed to gather that information. "+
-				"The output of this command will be shown to you.\n\n"+
-				"IMPORTANT: Return ONLY the raw JSON data without any markdown formatting like ```json or ```. Just the plain JSON object.",
-			currentDir, filesList)
+// fitToContext builds the system prompt of a suggestion request, trimming it and the earlier turns
+// to fit the model's context window with room for a response of reservedTokens
+func (c *AnthropicClient) fitToContext(turns []session.Message, userQuery, currentDir string, filesList []string, commandHistory string, reservedTokens int) (string, []session.Message, prompt.Trimmed) {
+	files := c.PromptFiles(filesList)
+	var trimmed prompt.Trimmed
+	if c.config.ContextTokens > 0 {
+		var parts prompt.Parts
+		parts, trimmed = prompt.FitToContext(prompt.Parts{
+			Fixed:   c.BuildSystemPrompt(currentDir, prompt.Files{}, "") + "\n" + userQuery,
+			Files:   files,
+			History: commandHistory,
+			Turns:   turns,
+		}, c.config.ContextTokens-reservedTokens)
+		files, commandHistory, turns = parts.Files, parts.History, parts.Turns
 	}
+	return c.BuildSystemPrompt(currentDir, files, commandHistory), turns, trimmed
+}
 
+// suggestionRequest builds the request for a command suggestion
+// It returns the text the assistant turn was prefilled with, which is missing from the response
+func (c *AnthropicClient) suggestionRequest(ctx context.Context, turns []session.Message, userQuery, currentDir string, filesList []string, commandHistory string) (AnthropicRequest, string) {
+	systemPrompt, turns, trimmed := c.fitToContext(turns, userQuery, currentDir, filesList, commandHistory, c.config.MaxTokens+c.config.ThinkingBudgetTokens)
+	if trimmed.Significant() {
+		fmt.Fprintf(os.Stderr, "The request exceeds the model's context window of %d tokens, %s…\n", c.config.ContextTokens, trimmed)
+	}
 	request := AnthropicRequest{
 		Model:       c.config.ModelID,
-		MaxTokens:   2048,
-		Temperature: 0.5,
+		MaxTokens:   c.config.MaxTokens,
+		Temperature: c.RequestTemperature(ctx, *c.config.Temperature),
 		System:      systemPrompt,
-		Messages: []Message{
-			{
-				Role: "user",
-				Content: []MessageContent{
-					{Type: "text", Text: userQuery},
-				},
-			},
-		},
+		Messages:    buildMessages(turns, userQuery),
+	}
+
+	c.applyThinking(&request)
+
+	// Extended thinking doesn't support prefilling the response
+	if request.Thinking != nil {
+		return request, ""
 	}
 
-	// Convert request to JSON
+	request.StopSequences = []string{jsonStopSequence}
+	request.Messages = append(request.Messages, Message{
+		Role:    "assistant",
+		Content: []MessageContent{{Type: "text", Text: jsonPrefill}},
+	})
+	return request, jsonPrefill
+}
+
+// maxSuggestionTokens caps max_tokens when retrying a suggestion that was cut off
+const maxSuggestionTokens = 8192
+
+// GetCommandSuggestion asks the model for command suggestions
+// A response cut off by max_tokens is retried with a higher limit, up to maxSuggestionTokens (or max_tokens if higher)
+func (c *AnthropicClient) GetCommandSuggestion(ctx context.Context, turns []session.Message, userQuery, currentDir string, filesList []string, commandHistory string) (string, error) {
+	request, prefill := c.suggestionRequest(ctx, turns, userQuery, currentDir, filesList, commandHistory)
+	tokenLimit := max(maxSuggestionTokens, c.config.MaxTokens) + c.config.ThinkingBudgetTokens
+
+	for {
+		// Convert request to JSON
+		requestBytes, err := json.Marshal(request)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal request: %w", err)
+		}
+
+		// We'll implement the HTTP request in a separate function
+		responseText, stopReason, err := c.sendRequest(ctx, requestBytes)
+		if err != nil {
+			return "", err
+		}
+
+		if stopReason != "max_tokens" {
+			return withPrefill(prefill, responseText), nil
+		}
+		if request.MaxTokens >= tokenLimit {
+			return "", fmt.Errorf("%w (max_tokens=%d)", command.ErrTruncated, request.MaxTokens)
+		}
+
+		request.MaxTokens = min(request.MaxTokens*2, tokenLimit)
+		fmt.Fprintf(os.Stderr, "Response was cut off, retrying with max_tokens=%d…\n", request.MaxTokens)
+	}
+}
+
+// StreamCommandSuggestion asks the model for command suggestions, calling onText with each chunk of text as it arrives
+// It returns the full response text once the stream is complete
+func (c *AnthropicClient) StreamCommandSuggestion(ctx context.Context, turns []session.Message, userQuery, currentDir string, filesList []string, commandHistory string, onText func(text string)) (string, error) {
+	request, prefill := c.suggestionRequest(ctx, turns, userQuery, currentDir, filesList, commandHistory)
+	request.Stream = true
+
 	requestBytes, err := json.Marshal(request)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	// We'll implement the HTTP request in a separate function
-	responseText, err := c.sendRequest(ctx, requestBytes)
+	if prefill != "" && onText != nil {
+		onText(prefill)
+	}
+
+	// The streamed text has already been shown, so a cut off response is reported rather than retried
+	responseText, stopReason, err := c.sendStreamingRequest(ctx, requestBytes, onText)
 	if err != nil {
 		return "", err
 	}
+	if stopReason == "max_tokens" {
+		return "", fmt.Errorf("%w (max_tokens=%d)", command.ErrTruncated, request.MaxTokens)
+	}
 
-	return responseText, nil
+	return withPrefill(prefill, responseText), nil
 }
 
-// sendRequest sends the request to the Anthropic API
-func (c *AnthropicClient) sendRequest(ctx context.Context, requestBody []byte) (string, error) {
-	// Create HTTP client with timeout
-	httpClient := &http.Client{
-		Timeout: time.Second * 120, // 2 minute timeout
+// Summarize asks the model for a concise summary of a command's output
+func (c *AnthropicClient) Summarize(ctx context.Context, output string) (string, error) {
+	return c.complete(ctx, prompt.SummarizeSystemPrompt, output)
+}
+
+// ReviewOutput asks the model whether a running command should be stopped, given its latest output
+func (c *AnthropicClient) ReviewOutput(ctx context.Context, request, cmd, output string) (string, error) {
+	return c.complete(ctx, prompt.ReviewOutputSystemPrompt, prompt.ReviewOutputMessage(request, cmd, output))
+}
+
+// ExplainCommand asks the model for a breakdown of what a shell command does
+func (c *AnthropicClient) ExplainCommand(ctx context.Context, cmd string) (string, error) {
+	return c.complete(ctx, prompt.ExplainSystemPrompt, cmd)
+}
+
+// DiagnoseError asks the model why a command failed and how to fix it, given its output and exit code
+func (c *AnthropicClient) DiagnoseError(ctx context.Context, cmd, output string, exitCode int) (string, error) {
+	return c.complete(ctx, prompt.DiagnoseSystemPrompt, prompt.DiagnoseMessage(cmd, output, exitCode))
+}
+
+// complete sends a single plain-text message with the given system prompt and returns the model's answer
+func (c *AnthropicClient) complete(ctx context.Context, systemPrompt, text string) (string, error) {
+	request := AnthropicRequest{
+		Model:       c.config.ModelID,
+		MaxTokens:   1024,
+		Temperature: c.RequestTemperature(ctx, *c.config.Temperature),
+		System:      systemPrompt,
+		Messages: []Message{
+			{
+				Role: "user",
+				Content: []MessageContent{
+					{Type: "text", Text: text},
+				},
+			},
+		},
 	}
+	c.applyThinking(&request)
 
-	// Create request
-	req, err := http.NewRequestWithContext(
-		ctx,
-		"POST",
-		"https://api.anthropic.com/v1/messages",
-		strings.NewReader(string(requestBody)),
-	)
+	requestBytes, err := json.Marshal(request)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return "", fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	// Set headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("x-api-key", c.config.APIKey)
-	req.Header.Set("anthropic-version", "2023-06-01")
+	// A cut off summary or explanation is still useful, so the stop reason is ignored
+	responseText, _, err := c.sendRequest(ctx, requestBytes)
+	return responseText, err
+}
 
-	// Send request
-	resp, err := httpClient.Do(req)
+// sendRequest sends the request to the Anthropic API and returns the response text and stop reason
+func (c *AnthropicClient) sendRequest(ctx context.Context, requestBody []byte) (string, string, error) {
+	resp, err := c.post(ctx, requestBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %w", err)
+		return "", "", err
 	}
 	defer resp.Body.Close()
 
 	// Read response body
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %w", err)
+		return "", "", fmt.Errorf("failed to read response body: %w", err)
 	}
 
 	// Check status code
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(respBody))
+		return "", "", fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(respBody))
 	}
 
 	// Parse response
 	var response AnthropicResponse
 	if err := json.Unmarshal(respBody, &response); err != nil {
-		return "", fmt.Errorf("failed to parse API response: %w", err)
+		return "", "", fmt.Errorf("failed to parse API response: %w", err)
 	}
 
 	// Extract the text from the response
 	if len(response.Content) == 0 {
-		return "", errors.New("empty response from model")
+		return "", "", errors.New("empty response from model")
 	}
 
-	var responseText string
+	var responseText, thinking string
 	for _, content := range response.Content {
-		if content.Type == "text" {
+		switch content.Type {
+		case "text":
 			responseText += content.Text
+		case "thinking":
+			thinking += content.Thinking
 		}
 	}
 
-	return responseText, nil
+	// The stop sequence isn't part of the text, restore it so the response is complete
+	if response.StopReason == "stop_sequence" {
+		responseText += response.StopSequence
+	}
+
+	c.HandleThinking(thinking)
+	c.HandleServedModel(response.Model, "")
+
+	return responseText, response.StopReason, nil
+}
+
+// streamEvent is a server-sent event of a streamed response
+type streamEvent struct {
+	Type string `json:"type"`
+	// Message is set on the message_start event
+	Message struct {
+		Model string `json:"model"`
+	} `json:"message"`
+	Delta struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		Thinking string `json:"thinking"`
+		// StopReason and StopSequence are set on the message_delta event
+		StopReason   string `json:"stop_reason"`
+		StopSequence string `json:"stop_sequence"`
+	} `json:"delta"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// sendStreamingRequest sends a streaming request to the Anthropic API and reads the server-sent events
+// It returns the response text and stop reason
+func (c *AnthropicClient) sendStreamingRequest(ctx context.Context, requestBody []byte, onText func(text string)) (string, string, error) {
+	resp, err := c.post(ctx, requestBody)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read response body: %w", err)
+		}
+		return "", "", fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var responseText, thinking strings.Builder
+	var stopReason, servedModel string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+
+		var event streamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return "", "", fmt.Errorf("failed to parse stream event: %w", err)
+		}
+
+		switch event.Type {
+		case "message_start":
+			servedModel = event.Message.Model
+		case "content_block_delta":
+			switch event.Delta.Type {
+			case "text_delta":
+				responseText.WriteString(event.Delta.Text)
+				if onText != nil {
+					onText(event.Delta.Text)
+				}
+			case "thinking_delta":
+				thinking.WriteString(event.Delta.Thinking)
+			}
+		case "message_delta":
+			stopReason = event.Delta.StopReason
+			// The stop sequence isn't part of the text, restore it so the response is complete
+			if event.Delta.StopReason == "stop_sequence" {
+				responseText.WriteString(event.Delta.StopSequence)
+				if onText != nil {
+					onText(event.Delta.StopSequence)
+				}
+			}
+		case "error":
+			return "", "", fmt.Errorf("API stream failed: %s: %s", event.Error.Type, event.Error.Message)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", fmt.Errorf("failed to read response stream: %w", err)
+	}
+
+	if responseText.Len() == 0 {
+		return "", "", errors.New("empty response from model")
+	}
+
+	c.HandleThinking(thinking.String())
+	c.HandleServedModel(servedModel, "")
+
+	return responseText.String(), stopReason, nil
+}
+
+// post waits for the rate limiter and posts a request body to the messages endpoint
+func (c *AnthropicClient) post(ctx context.Context, requestBody []byte) (*http.Response, error) {
+	// Wait for the local rate limiter before sending
+	if err := ratelimit.Wait(ctx, c.limiter); err != nil {
+		return nil, err
+	}
+
+	return c.do(ctx, "POST", c.baseURL+"/v1/messages", strings.NewReader(string(requestBody)))
+}
+
+// Ping checks that the API is reachable and accepts the API key and model ID, without using any tokens
+func (c *AnthropicClient) Ping(ctx context.Context) error {
+	resp, err := c.do(ctx, "GET", c.baseURL+"/v1/models/"+url.PathEscape(c.config.ModelID), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
+
+// do sends an authenticated request to the Anthropic API
+func (c *AnthropicClient) do(ctx context.Context, method, requestURL string, body io.Reader) (*http.Response, error) {
+	// Requests may be recorded or replayed, see AI_RECORD and AI_REPLAY
+	transport, err := recorder.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	// Create HTTP client with timeout
+	httpClient := &http.Client{
+		Timeout:   time.Second * 120, // 2 minute timeout
+		Transport: transport,
+	}
+
+	// Create request
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Set headers
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", c.config.APIKey)
+	req.Header.Set("anthropic-version", c.config.APIVersion)
+	if len(c.config.BetaFeatures) > 0 {
+		req.Header.Set("anthropic-beta", strings.Join(c.config.BetaFeatures, ","))
+	}
+	httpheaders.Apply(req.Header, c.config.Headers)
+
+	// Send request
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", network.Wrap(err, req.URL.Host, "check your network and proxy settings"))
+	}
+
+	// Record the provider's ID so it can be logged next to ours, also for failed requests
+	requestid.SetProvider(ctx, resp.Header.Get("request-id"))
+	return resp, nil
 }
@@ -0,0 +1,168 @@
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nir/ai.go/internal/clientopts"
+	"github.com/nir/ai.go/internal/command"
+	"github.com/nir/ai.go/internal/prompt"
+)
+
+// newTestClient returns a client sending its requests to a test server running handler
+func newTestClient(t *testing.T, handler http.HandlerFunc) *AnthropicClient {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	temperature := 0.5
+	return &AnthropicClient{
+		config: &ClientConfig{
+			ModelID:     ModelID,
+			APIKey:      "test-key",
+			APIVersion:  APIVersion,
+			Temperature: &temperature,
+			MaxTokens:   2048,
+		},
+		baseURL: server.URL,
+	}
+}
+
+// respond writes a messages API response with the given text
+func respond(t *testing.T, w http.ResponseWriter, text, stopReason string) {
+	t.Helper()
+	response := map[string]any{
+		"model":       ModelID,
+		"content":     []map[string]string{{"type": "text", "text": text}},
+		"stop_reason": stopReason,
+	}
+	if stopReason == "stop_sequence" {
+		response["stop_sequence"] = jsonStopSequence
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetCommandSuggestionProseAfterJSON(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var request AnthropicRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		last := request.Messages[len(request.Messages)-1]
+		if last.Role != "assistant" || last.Content[0].Text != jsonPrefill {
+			t.Errorf("the response isn't prefilled, last message: %+v", last)
+		}
+
+		// A compact object doesn't match the stop sequence, so the model's chatter follows it
+		respond(t, w, `"safe": true, "command": "ls -la", "reason": "Lists the files", "is_final": true, "needs_output": false}`+
+			"\n\nThis lists all the files, including hidden ones. Let me know if you need anything else!", "end_turn")
+	})
+
+	response, err := client.GetCommandSuggestion(context.Background(), nil, "list the files", "/tmp", nil, "")
+	if err != nil {
+		t.Fatalf("GetCommandSuggestion failed: %v", err)
+	}
+	if !json.Valid([]byte(response)) {
+		t.Errorf("the response isn't only the JSON object: %q", response)
+	}
+
+	cmd, err := command.ParseCommandResponse(response)
+	if err != nil {
+		t.Fatalf("ParseCommandResponse failed: %v", err)
+	}
+	if cmd.Command != "ls -la" || !cmd.IsFinal {
+		t.Errorf("got %+v", cmd)
+	}
+}
+
+// TestGetCommandSuggestionConcurrent sends suggestion requests in parallel while Configure changes the options,
+// run it with -race to catch unsynchronized access
+func TestGetCommandSuggestionConcurrent(t *testing.T) {
+	var served atomic.Int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		served.Add(1)
+		respond(t, w, `"safe": true, "command": "ls", "reason": "Lists the files", "is_final": true, "needs_output": false`, "stop_sequence")
+	})
+	client.Configure(func(options *clientopts.Options) {
+		options.ServedModelHandler = func(model, fingerprint string) {}
+	})
+
+	const requests = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, requests)
+	temperature := 0.2
+	updates := []func(options *clientopts.Options, i int){
+		func(options *clientopts.Options, i int) {
+			options.PromptAdditions = []string{fmt.Sprintf("addition %d", i)}
+		},
+		func(options *clientopts.Options, i int) { options.Language = "French" },
+		func(options *clientopts.Options, i int) { options.Temperature = &temperature },
+		func(options *clientopts.Options, i int) { options.FileTree = "a.txt" },
+	}
+	for i := range requests {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			response, err := client.GetCommandSuggestion(context.Background(), nil, fmt.Sprintf("request %d", i), "/tmp", []string{"a.txt"}, "")
+			if err == nil {
+				_, err = command.ParseCommandResponse(response)
+			}
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			client.Configure(func(options *clientopts.Options) { updates[i%len(updates)](options, i) })
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if served.Load() != requests {
+		t.Errorf("the server got %d requests, want %d", served.Load(), requests)
+	}
+}
+
+// TestSystemPromptTrimmed checks that the system prompt shown for debugging is the one sent, trimmed to the context window
+func TestSystemPromptTrimmed(t *testing.T) {
+	var sent string
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var request AnthropicRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		sent = request.System
+		respond(t, w, `"safe": true, "command": "ls", "reason": "Lists the files", "is_final": true, "needs_output": false`, "stop_sequence")
+	})
+	client.config.MaxTokens = 100
+
+	files := make([]string, 2000)
+	for i := range files {
+		files[i] = fmt.Sprintf("dir/file-%04d.txt", i)
+	}
+	untrimmed, _ := client.SystemPrompt(nil, "list the files", "/tmp", files, "")
+	client.config.ContextTokens = prompt.EstimateTokens(untrimmed) / 2
+
+	systemPrompt, _ := client.SystemPrompt(nil, "list the files", "/tmp", files, "")
+	if len(systemPrompt) >= len(untrimmed) {
+		t.Fatalf("the system prompt wasn't trimmed to the context window of %d tokens", client.config.ContextTokens)
+	}
+	if _, err := client.GetCommandSuggestion(context.Background(), nil, "list the files", "/tmp", files, ""); err != nil {
+		t.Fatalf("GetCommandSuggestion failed: %v", err)
+	}
+	if systemPrompt != sent {
+		t.Error("the system prompt differs from the one sent")
+	}
+}
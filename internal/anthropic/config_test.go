@@ -0,0 +1,84 @@
+package anthropic
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureStderr returns what fn writes to stderr
+func captureStderr(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = stderr }()
+
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+	fn()
+	w.Close()
+	return <-output
+}
+
+func TestConfigPermissions(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("ANTHROPIC_API_KEY", "")
+	configPath := filepath.Join(home, ".ai", "anthropic.cfg")
+	if err := os.MkdirAll(filepath.Dir(configPath), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		mode        os.FileMode
+		wantWarning bool
+	}{
+		{name: "readable by others", mode: 0644, wantWarning: true},
+		{name: "group readable", mode: 0640, wantWarning: true},
+		{name: "owner only", mode: 0600, wantWarning: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(configPath, []byte(`{"api_key": "sk-ant-test"}`), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(configPath, tt.mode); err != nil {
+				t.Fatal(err)
+			}
+
+			warning := captureStderr(t, func() {
+				if _, err := loadClientConfig(); err != nil {
+					t.Errorf("loadClientConfig failed: %v", err)
+				}
+			})
+			if got := strings.Contains(warning, "readable by other users"); got != tt.wantWarning {
+				t.Errorf("warned = %v, want %v, stderr: %q", got, tt.wantWarning, warning)
+			}
+
+			if err := FixConfigPermissions(); err != nil {
+				t.Fatalf("FixConfigPermissions failed: %v", err)
+			}
+			info, err := os.Stat(configPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0600 {
+				t.Errorf("mode after FixConfigPermissions = %04o, want 0600", info.Mode().Perm())
+			}
+			if warning := captureStderr(t, func() { loadClientConfig() }); strings.Contains(warning, "readable by other users") {
+				t.Errorf("still warned after FixConfigPermissions: %q", warning)
+			}
+		})
+	}
+}
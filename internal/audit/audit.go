@@ -0,0 +1,148 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+)
+
+// queueSize is the number of events waiting to be sent, further events are dropped with a warning
+const queueSize = 64
+
+// sendTimeout limits each POST to the webhook
+const sendTimeout = 10 * time.Second
+
+// Event is a command that was executed, as posted to the audit webhook
+type Event struct {
+	Timestamp time.Time `json:"timestamp"`
+	User      string    `json:"user"`
+	Host      string    `json:"host"`
+	Directory string    `json:"directory"`
+	// Query is the user's request the command was suggested for
+	Query   string `json:"query"`
+	Command string `json:"command"`
+	// Safe is whether Claude marked the command as safe to run without confirmation
+	Safe bool `json:"safe"`
+	// ExitCode is -1 when the command didn't exit normally, e.g. when it was stopped
+	ExitCode int `json:"exit_code"`
+}
+
+// NewEvent returns an event for a command, filling in the time, the user and the host
+func NewEvent(directory, query, command string, safe bool, exitCode int) Event {
+	event := Event{
+		Timestamp: time.Now().UTC(),
+		Directory: directory,
+		Query:     query,
+		Command:   command,
+		Safe:      safe,
+		ExitCode:  exitCode,
+	}
+	if current, err := user.Current(); err == nil {
+		event.User = current.Username
+	}
+	event.Host, _ = os.Hostname()
+	return event
+}
+
+// Sender posts events to a webhook in the background, so a slow or unreachable webhook never holds up commands
+type Sender struct {
+	url    string
+	client *http.Client
+	events chan Event
+	// warn reports events that couldn't be delivered
+	warn func(err error)
+	done chan struct{}
+	once sync.Once
+}
+
+// NewSender starts a sender posting events to webhookURL, warn is called for each event that couldn't be delivered
+func NewSender(webhookURL string, warn func(err error)) (*Sender, error) {
+	parsed, err := url.Parse(webhookURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, errors.New("audit_webhook must be an http or https URL")
+	}
+
+	s := &Sender{
+		url:    webhookURL,
+		client: &http.Client{Timeout: sendTimeout},
+		events: make(chan Event, queueSize),
+		warn:   warn,
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// Send queues an event without waiting for it to be posted
+func (s *Sender) Send(event Event) {
+	select {
+	case s.events <- event:
+	default:
+		s.warn(fmt.Errorf("too many audit events waiting to be sent, dropped the one for %q", event.Command))
+	}
+}
+
+// Close waits up to timeout for the queued events to be posted, those still queued after that are dropped
+// Send must not be called after Close
+func (s *Sender) Close(timeout time.Duration) {
+	s.once.Do(func() {
+		close(s.events)
+	})
+	select {
+	case <-s.done:
+	case <-time.After(timeout):
+		s.warn(fmt.Errorf("gave up waiting for the audit webhook after %s, %d events weren't sent", timeout, len(s.events)))
+	}
+}
+
+// run posts the queued events one at a time, in order
+func (s *Sender) run() {
+	defer close(s.done)
+	for event := range s.events {
+		if err := s.post(event); err != nil {
+			s.warn(fmt.Errorf("failed to send audit event for %q: %w", event.Command, err))
+		}
+	}
+}
+
+// post sends a single event to the webhook
+func (s *Sender) post(event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// The URL may hold a token, so only the cause is reported
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("the webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -7,41 +7,92 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/nir/ai.go/internal/clientopts"
+	"github.com/nir/ai.go/internal/command"
+	aiconfig "github.com/nir/ai.go/internal/config"
+	"github.com/nir/ai.go/internal/network"
+	"github.com/nir/ai.go/internal/prompt"
+	"github.com/nir/ai.go/internal/ratelimit"
+	"github.com/nir/ai.go/internal/recorder"
+	"github.com/nir/ai.go/internal/requestid"
+	"github.com/nir/ai.go/internal/session"
+	"golang.org/x/time/rate"
 )
 
 // BedrockClient handles interactions with AWS Bedrock
 type BedrockClient struct {
+	config  *ModelConfig
+	limiter *rate.Limiter
+	// loadOptions are the options the AWS config was loaded with, to reload it when the credentials expire
+	loadOptions []func(*config.LoadOptions) error
+
+	clientopts.Holder
+
+	// mutex protects client, which is replaced when the AWS config is reloaded, see refreshCredentials
+	mutex  sync.RWMutex
 	client *bedrockruntime.Client
-	config *ModelConfig
 }
 
 // ModelID is the Claude 3.7 Sonnet model ID
 const ModelID = "anthropic.claude-3-7-sonnet-20250219-v1:0"
 
+// modelIDPattern matches Bedrock model IDs with an optional cross-region or global inference profile prefix,
+// e.g. anthropic.claude-3-7-sonnet-20250219-v1:0, us.anthropic.claude-3-7-sonnet-20250219-v1:0
+// or global.anthropic.claude-sonnet-4-20250514-v1:0
+var modelIDPattern = regexp.MustCompile(`^(([a-z]{2,4}(-gov)?|global)\.)?anthropic\.claude-[a-z0-9.-]+-v\d+(:\d+)?$`)
+
+// ValidateModel checks that a model ID looks like a valid Bedrock model ID or ARN
+func ValidateModel(modelID string) error {
+	if strings.HasPrefix(modelID, "arn:") {
+		return nil
+	}
+	if !modelIDPattern.MatchString(modelID) {
+		return fmt.Errorf("modelid %q doesn't look like a Bedrock Claude model ID, which needs a version suffix (expected something like %q)", modelID, ModelID)
+	}
+	return nil
+}
+
 // ModelConfig holds the configuration for the AWS client
 type ModelConfig struct {
 	Region   string `json:"region,omitempty"`
 	ModelID  string `json:"modelid,omitempty"`
 	Profile  string `json:"profile,omitempty"`
 	Endpoint string `json:"endpoint,omitempty"`
+	// CredentialsSource pins where credentials come from: "default" (the SDK's credential chain), "env", "profile" or "sso"
+	CredentialsSource string `json:"credentials_source,omitempty"`
+	// ThinkingBudgetTokens enables extended thinking with the given token budget (0 disables it)
+	ThinkingBudgetTokens int `json:"thinking_budget_tokens,omitempty"`
+	// RequestsPerMinute limits the request rate on the client side (0 disables it)
+	RequestsPerMinute int `json:"requests_per_minute,omitempty"`
+	// Temperature is the sampling temperature of requests (defaults to 0.5)
+	Temperature *float64 `json:"temperature,omitempty"`
+	// MaxTokens is the initial max_tokens of command suggestions (defaults to 2048)
+	MaxTokens int `json:"max_tokens,omitempty"`
+	// ContextTokens is the model's context window, suggestion requests that wouldn't fit are trimmed
+	// (defaults to the window of known models, trimming is disabled for unknown ones)
+	ContextTokens int `json:"context_tokens,omitempty"`
+	// PrefillJSON starts the assistant turn of command suggestions with "{", so the model answers with the JSON object only
+	PrefillJSON bool `json:"prefill_json,omitempty"`
 }
 
 // loadModelConfig loads the model configuration from ~/.ai/model.cfg
 func loadModelConfig() (*ModelConfig, error) {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get user home directory: %w", err)
-	}
-
 	// Ensure the .ai directory exists
-	aiDir := filepath.Join(homeDir, ".ai")
-	if err := os.MkdirAll(aiDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create .ai directory: %w", err)
+	aiDir, err := aiconfig.Dir()
+	if err != nil {
+		return nil, err
 	}
 
 	configPath := filepath.Join(aiDir, "model.cfg")
@@ -62,6 +113,9 @@ func loadModelConfig() (*ModelConfig, error) {
 			return nil, fmt.Errorf("failed to write default config file: %w", err)
 		}
 
+		if err := applyModelOverrides(&defaultConfig); err != nil {
+			return nil, err
+		}
 		return &defaultConfig, nil
 	}
 
@@ -83,9 +137,54 @@ func loadModelConfig() (*ModelConfig, error) {
 		config.ModelID = ModelID
 	}
 
+	if err := applyModelOverrides(&config); err != nil {
+		return nil, err
+	}
 	return &config, nil
 }
 
+// applyModelOverrides applies the AI_* environment variables, which take precedence over the file, and the defaults
+func applyModelOverrides(modelConfig *ModelConfig) error {
+	overrides, err := aiconfig.LoadModelOverrides()
+	if err != nil {
+		return err
+	}
+
+	if overrides.ModelID != "" {
+		modelConfig.ModelID = overrides.ModelID
+	}
+	if overrides.Region != "" {
+		modelConfig.Region = overrides.Region
+	}
+	if overrides.Profile != "" {
+		modelConfig.Profile = overrides.Profile
+	}
+	if overrides.Endpoint != "" {
+		modelConfig.Endpoint = overrides.Endpoint
+	}
+	if overrides.Temperature != nil {
+		modelConfig.Temperature = overrides.Temperature
+	}
+	if overrides.MaxTokens > 0 {
+		modelConfig.MaxTokens = overrides.MaxTokens
+	}
+	if overrides.ContextTokens > 0 {
+		modelConfig.ContextTokens = overrides.ContextTokens
+	}
+
+	if modelConfig.Temperature == nil {
+		temperature := aiconfig.DefaultTemperature
+		modelConfig.Temperature = &temperature
+	}
+	if modelConfig.MaxTokens == 0 {
+		modelConfig.MaxTokens = aiconfig.DefaultMaxTokens
+	}
+	if modelConfig.ContextTokens == 0 {
+		modelConfig.ContextTokens = prompt.ContextTokens(modelConfig.ModelID)
+	}
+	return nil
+}
+
 // NewBedrockClient creates a new client for Bedrock
 func NewBedrockClient() (*BedrockClient, error) {
 	modelConfig, err := loadModelConfig()
@@ -93,6 +192,11 @@ func NewBedrockClient() (*BedrockClient, error) {
 		return nil, fmt.Errorf("failed to load model config: %w", err)
 	}
 
+	// Catch typos in the model ID before the first request fails with an opaque API error
+	if err := ValidateModel(modelConfig.ModelID); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", err)
+	}
+
 	// Setup options for AWS config
 	var options []func(*config.LoadOptions) error
 
@@ -106,25 +210,128 @@ func NewBedrockClient() (*BedrockClient, error) {
 		options = append(options, config.WithRegion(modelConfig.Region))
 	}
 
+	// Restrict the credentials to the configured source
+	credentialsOptions, err := credentialsOptions(modelConfig)
+	if err != nil {
+		return nil, err
+	}
+	options = append(options, credentialsOptions...)
+
+	client, err := newRuntimeClient(context.TODO(), modelConfig, options)
+	if err != nil {
+		return nil, err
+	}
+	return &BedrockClient{
+		client:      client,
+		config:      modelConfig,
+		limiter:     ratelimit.New(modelConfig.RequestsPerMinute),
+		loadOptions: options,
+	}, nil
+}
+
+// newRuntimeClient loads the AWS config with the given options and creates a Bedrock client from it
+func newRuntimeClient(ctx context.Context, modelConfig *ModelConfig, options []func(*config.LoadOptions) error) (*bedrockruntime.Client, error) {
 	// Load AWS config with any custom options
-	cfg, err := config.LoadDefaultConfig(context.TODO(), options...)
+	cfg, err := config.LoadDefaultConfig(ctx, options...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
-	// Create client with custom endpoint if specified
-	clientOptions := []func(*bedrockruntime.Options){}
+	// Requests may be recorded or replayed like those of the other clients, see AI_RECORD and AI_REPLAY
+	// Replayed requests are still signed, so credentials are needed, but they aren't checked
+	cfg.HTTPClient, err = recorder.WrapClient(cfg.HTTPClient)
+	if err != nil {
+		return nil, err
+	}
+
+	return bedrockruntime.NewFromConfig(cfg, clientOptions(modelConfig)...), nil
+}
+
+// credentialsOptions returns the AWS config options that pin the credentials to the configured source
+// The default credential chain silently falls back to e.g. an instance role, a pinned source fails clearly instead
+func credentialsOptions(modelConfig *ModelConfig) ([]func(*config.LoadOptions) error, error) {
+	switch modelConfig.CredentialsSource {
+	case "", "default":
+		return nil, nil
+
+	case "env":
+		envConfig, err := config.NewEnvConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read AWS environment variables: %w", err)
+		}
+		if !envConfig.Credentials.HasKeys() {
+			return nil, errors.New("credentials_source is env, but AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are not set")
+		}
+		provider := credentials.StaticCredentialsProvider{Value: envConfig.Credentials, Source: []aws.CredentialSource{aws.CredentialSourceEnvVars}}
+		return []func(*config.LoadOptions) error{config.WithCredentialsProvider(provider)}, nil
+
+	case "profile", "sso":
+		// A profile set in the config takes precedence over credentials in environment variables
+		if modelConfig.Profile == "" {
+			return nil, fmt.Errorf("credentials_source is %s, but no profile is configured", modelConfig.CredentialsSource)
+		}
+		envConfig, err := config.NewEnvConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read AWS environment variables: %w", err)
+		}
+		// Honor AWS_CONFIG_FILE and AWS_SHARED_CREDENTIALS_FILE like LoadDefaultConfig does
+		sharedConfig, err := config.LoadSharedConfigProfile(context.TODO(), modelConfig.Profile, func(o *config.LoadSharedConfigOptions) {
+			if envConfig.SharedConfigFile != "" {
+				o.ConfigFiles = []string{envConfig.SharedConfigFile}
+			}
+			if envConfig.SharedCredentialsFile != "" {
+				o.CredentialsFiles = []string{envConfig.SharedCredentialsFile}
+			}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to load AWS profile %s: %w", modelConfig.Profile, err)
+		}
+		if modelConfig.CredentialsSource == "sso" && sharedConfig.SSOSessionName == "" && sharedConfig.SSOStartURL == "" {
+			return nil, fmt.Errorf("credentials_source is sso, but AWS profile %s has no SSO settings", modelConfig.Profile)
+		}
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("unknown credentials_source %q, expected default, env, profile or sso", modelConfig.CredentialsSource)
+}
+
+// clientOptions returns the Bedrock client options for the model config, such as a custom endpoint
+func clientOptions(modelConfig *ModelConfig) []func(*bedrockruntime.Options) {
+	var clientOptions []func(*bedrockruntime.Options)
 	if modelConfig.Endpoint != "" {
+		// BaseEndpoint replaces the deprecated EndpointResolver, the endpoint rules still apply to it
 		clientOptions = append(clientOptions, func(o *bedrockruntime.Options) {
-			o.EndpointResolver = bedrockruntime.EndpointResolverFromURL(modelConfig.Endpoint)
+			o.BaseEndpoint = aws.String(modelConfig.Endpoint)
 		})
 	}
+	return clientOptions
+}
 
-	client := bedrockruntime.NewFromConfig(cfg, clientOptions...)
-	return &BedrockClient{
-		client: client,
-		config: modelConfig,
-	}, nil
+// Model returns the configured model ID
+func (c *BedrockClient) Model() string {
+	return c.config.ModelID
+}
+
+// SystemPrompt builds the system prompt of a command suggestion request and the earlier turns sent with it,
+// trimmed to fit the model's context window as the request is
+func (c *BedrockClient) SystemPrompt(turns []session.Message, userQuery, currentDir string, filesList []string, commandHistory string) (string, []session.Message) {
+	systemPrompt, turns, _ := c.fitToContext(turns, userQuery, currentDir, filesList, commandHistory, c.config.MaxTokens+c.config.ThinkingBudgetTokens)
+	return systemPrompt, turns
+}
+
+// applyThinking enables extended thinking on the request if a budget is configured
+func (c *BedrockClient) applyThinking(request *SonnetRequest) {
+	if c.config.ThinkingBudgetTokens <= 0 {
+		return
+	}
+
+	request.Thinking = &ThinkingConfig{
+		Type:         "enabled",
+		BudgetTokens: c.config.ThinkingBudgetTokens,
+	}
+	// Extended thinking requires a temperature of 1 and max_tokens above the budget
+	request.Temperature = 1
+	request.MaxTokens += c.config.ThinkingBudgetTokens
 }
 
 // MessageContent represents a content item in a message
@@ -139,144 +346,440 @@ type Message struct {
 	Content []MessageContent `json:"content"`
 }
 
+// ThinkingConfig enables extended thinking on a request
+type ThinkingConfig struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
 // SonnetRequest represents the request to Claude Sonnet
 type SonnetRequest struct {
-	AnthropicVersion string    `json:"anthropic_version"`
-	MaxTokens        int       `json:"max_tokens"`
-	Temperature      float64   `json:"temperature"`
-	System           string    `json:"system,omitempty"`
-	Messages         []Message `json:"messages"`
+	AnthropicVersion string          `json:"anthropic_version"`
+	MaxTokens        int             `json:"max_tokens"`
+	Temperature      float64         `json:"temperature"`
+	System           string          `json:"system,omitempty"`
+	Messages         []Message       `json:"messages"`
+	Thinking         *ThinkingConfig `json:"thinking,omitempty"`
+	// StopSequences end generation as soon as one of them is produced
+	StopSequences []string `json:"stop_sequences,omitempty"`
 }
 
 // SonnetResponse represents the response from Claude Sonnet
 type SonnetResponse struct {
 	Content []struct {
-		Type string `json:"type"`
-		Text string `json:"text"`
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		Thinking string `json:"thinking,omitempty"`
 	} `json:"content"`
-	Model      string `json:"model"`
-	StopReason string `json:"stop_reason"`
-}
-
-// Command represents the parsed command response from the model
-type Command struct {
-	Safe        bool   `json:"safe"`
-	Command     string `json:"command"`
-	Reason      string `json:"reason"`
-	IsFinal     bool   `json:"is_final"`
-	NeedsOutput bool   `json:"needs_output"`
-}
-
-// ParseCommandResponse parses the model's response into a command structure
-func ParseCommandResponse(responseText string) (*Command, error) {
-	// Check if the response is wrapped in markdown code block
-	jsonText := responseText
-
-	// Strip markdown code block formatting if present
-	markdownStart := "```json"
-	markdownEnd := "```"
-	if strings.Contains(jsonText, markdownStart) {
-		startIndex := strings.Index(jsonText, markdownStart) + len(markdownStart)
-		endIndex := strings.LastIndex(jsonText, markdownEnd)
-		if endIndex > startIndex {
-			jsonText = jsonText[startIndex:endIndex]
-		}
+	Model        string `json:"model"`
+	StopReason   string `json:"stop_reason"`
+	StopSequence string `json:"stop_sequence,omitempty"`
+}
+
+// buildMessages converts the previous conversation turns and the new query into request messages
+func buildMessages(turns []session.Message, userQuery string) []Message {
+	messages := make([]Message, 0, len(turns)+1)
+	for _, turn := range turns {
+		messages = append(messages, Message{
+			Role:    turn.Role,
+			Content: []MessageContent{{Type: "text", Text: turn.Content}},
+		})
 	}
 
-	// Trim any leading/trailing whitespace
-	jsonText = strings.TrimSpace(jsonText)
+	return append(messages, Message{
+		Role:    "user",
+		Content: []MessageContent{{Type: "text", Text: userQuery}},
+	})
+}
+
+// fitToContext builds the system prompt of a suggestion request, trimming it and the earlier turns
+// to fit the model's context window with room for a response of reservedTokens
+func (c *BedrockClient) fitToContext(turns []session.Message, userQuery, currentDir string, filesList []string, commandHistory string, reservedTokens int) (string, []session.Message, prompt.Trimmed) {
+	files := c.PromptFiles(filesList)
+	var trimmed prompt.Trimmed
+	if c.config.ContextTokens > 0 {
+		var parts prompt.Parts
+		parts, trimmed = prompt.FitToContext(prompt.Parts{
+			Fixed:   c.BuildSystemPrompt(currentDir, prompt.Files{}, "") + "\n" + userQuery,
+			Files:   files,
+			History: commandHistory,
+			Turns:   turns,
+		}, c.config.ContextTokens-reservedTokens)
+		files, commandHistory, turns = parts.Files, parts.History, parts.Turns
+	}
+	return c.BuildSystemPrompt(currentDir, files, commandHistory), turns, trimmed
+}
 
-	var cmd Command
-	if err := json.Unmarshal([]byte(jsonText), &cmd); err != nil {
-		return nil, fmt.Errorf("failed to parse command response: %w", err)
+// jsonPrefill starts the assistant turn so the model has to answer with a JSON object
+const jsonPrefill = "{"
+
+// jsonStopSequence is the closing brace of the JSON object, raw newlines can't appear inside JSON strings
+// so it stops generation before any chatter following the object.
+// It only matches pretty-printed JSON: a compact object is followed by whatever the model adds after it,
+// which withPrefill drops.
+const jsonStopSequence = "\n}"
+
+// withPrefill completes a response with the text the assistant turn was prefilled with
+// When prefilled, the response is an object that may be followed by prose the stop sequence didn't catch,
+// so only the object is kept. A response that isn't a complete object is returned as is, for the parser to report.
+func withPrefill(prefill, responseText string) string {
+	if prefill == "" {
+		return responseText
 	}
-	return &cmd, nil
+	return command.ExtractJSONObject(prefill + responseText)
 }
 
+// suggestionRequest builds the request for a command suggestion
+// It returns the text the assistant turn was prefilled with (with prefill_json), which is missing from the response
+func (c *BedrockClient) suggestionRequest(ctx context.Context, turns []session.Message, userQuery, currentDir string, filesList []string, commandHistory string) (SonnetRequest, string) {
+	systemPrompt, turns, trimmed := c.fitToContext(turns, userQuery, currentDir, filesList, commandHistory, c.config.MaxTokens+c.config.ThinkingBudgetTokens)
+	if trimmed.Significant() {
+		fmt.Fprintf(os.Stderr, "The request exceeds the model's context window of %d tokens, %s…\n", c.config.ContextTokens, trimmed)
+	}
+	request := SonnetRequest{
+		AnthropicVersion: "bedrock-2023-05-31",
+		MaxTokens:        c.config.MaxTokens,
+		Temperature:      c.RequestTemperature(ctx, *c.config.Temperature),
+		System:           systemPrompt,
+		Messages:         buildMessages(turns, userQuery),
+	}
+
+	// Extended thinking doesn't support prefilling the response
+	if !c.config.PrefillJSON || c.config.ThinkingBudgetTokens > 0 {
+		return request, ""
+	}
+
+	request.StopSequences = []string{jsonStopSequence}
+	request.Messages = append(request.Messages, Message{
+		Role:    "assistant",
+		Content: []MessageContent{{Type: "text", Text: jsonPrefill}},
+	})
+	return request, jsonPrefill
+}
+
+// maxSuggestionTokens caps max_tokens when retrying a suggestion that was cut off
+const maxSuggestionTokens = 8192
+
 // GetCommandSuggestion asks the model for command suggestions
-func (c *BedrockClient) GetCommandSuggestion(ctx context.Context, userQuery, currentDir string, filesList []string, commandHistory string) (string, error) {
-	// Create system prompt with history if provided
-	var systemPrompt string
-	if commandHistory != "" {
-		systemPrompt = fmt.Sprintf(
-			"You are an AI assistant providing shell commands to execute tasks. Your job is to translate user requests into the exact commands needed.\n"+
-				"Current directory: %s\n"+
-				"Files in directory (limited to 1000): %v\n\n"+
-				"Recent command history (for context):\n%s\n\n"+
-				"Provide the exact command or commands to run in response to the user's request. "+
-				"Format your response as JSON with these fields:\n"+
-				"- 'safe': a boolean indicating if the command is safe to run automatically\n"+
-				"- 'command': the exact command(s) to run\n"+
-				"- 'reason': a brief explanation of what the command does\n"+
-				"- 'is_final': a boolean indicating if this is the final command to complete the user's request (true) or if more commands will be needed (false)\n"+
-				"- 'needs_output': a boolean indicating if you need to see the output of this command to determine the next step\n\n"+
-				"If you need more information, respond with JSON where 'needs_output' is true and the 'command' field contains the command needed to gather that information. "+
-				"The output of this command will be shown to you.\n\n"+
-				"IMPORTANT: Return ONLY the raw JSON data without any markdown formatting like ```json or ```. Just the plain JSON object.",
-			currentDir, filesList, commandHistory)
-	} else {
-		systemPrompt = fmt.Sprintf(
-			"You are an AI assistant providing shell commands to execute tasks. Your job is to translate user requests into the exact commands needed.\n"+
-				"Current directory: %s\n"+
-				"Files in directory (limited to 1000): %v\n\n"+
-				"Provide the exact command or commands to run in response to the user's request. "+
-				"Format your response as JSON with these fields:\n"+
-				"- 'safe': a boolean indicating if the command is safe to run automatically\n"+
-				"- 'command': the exact command(s) to run\n"+
-				"- 'reason': a brief explanation of what the command does\n"+
-				"- 'is_final': a boolean indicating if this is the final command to complete the user's request (true) or if more commands will be needed (false)\n"+
-				"- 'needs_output': a boolean indicating if you need to see the output of this command to determine the next step\n\n"+
-				"If you need more information, respond with JSON where 'needs_output' is true and the 'command' field contains the command needed to gather that information. "+
-				"The output of this command will be shown to you.\n\n"+
-				"IMPORTANT: Return ONLY the raw JSON data without any markdown formatting like ```json or ```. Just the plain JSON object.",
-			currentDir, filesList)
+// A response cut off by max_tokens is retried with a higher limit, up to maxSuggestionTokens (or max_tokens if higher)
+func (c *BedrockClient) GetCommandSuggestion(ctx context.Context, turns []session.Message, userQuery, currentDir string, filesList []string, commandHistory string) (string, error) {
+	request, prefill := c.suggestionRequest(ctx, turns, userQuery, currentDir, filesList, commandHistory)
+	tokenLimit := max(maxSuggestionTokens, request.MaxTokens)
+
+	// invokeModel adds the thinking budget to max_tokens, so the limit applies to the base value
+	for {
+		responseText, stopReason, err := c.invokeModel(ctx, request)
+		if err != nil {
+			return "", err
+		}
+
+		if stopReason != "max_tokens" {
+			return withPrefill(prefill, responseText), nil
+		}
+		if request.MaxTokens >= tokenLimit {
+			return "", fmt.Errorf("%w (max_tokens=%d)", command.ErrTruncated, request.MaxTokens+c.config.ThinkingBudgetTokens)
+		}
+
+		request.MaxTokens = min(request.MaxTokens*2, tokenLimit)
+		fmt.Fprintf(os.Stderr, "Response was cut off, retrying with max_tokens=%d…\n", request.MaxTokens+c.config.ThinkingBudgetTokens)
+	}
+}
+
+// StreamCommandSuggestion asks the model for command suggestions, calling onText with each chunk of text as it arrives
+// It returns the full response text once the stream is complete
+func (c *BedrockClient) StreamCommandSuggestion(ctx context.Context, turns []session.Message, userQuery, currentDir string, filesList []string, commandHistory string, onText func(text string)) (string, error) {
+	request, prefill := c.suggestionRequest(ctx, turns, userQuery, currentDir, filesList, commandHistory)
+
+	if prefill != "" && onText != nil {
+		onText(prefill)
+	}
+
+	// The streamed text has already been shown, so a cut off response is reported rather than retried
+	responseText, stopReason, err := c.invokeModelWithStream(ctx, request, onText)
+	if err != nil {
+		return "", err
+	}
+	if stopReason == "max_tokens" {
+		return "", fmt.Errorf("%w (max_tokens=%d)", command.ErrTruncated, request.MaxTokens+c.config.ThinkingBudgetTokens)
 	}
 
+	return withPrefill(prefill, responseText), nil
+}
+
+// Summarize asks the model for a concise summary of a command's output
+func (c *BedrockClient) Summarize(ctx context.Context, output string) (string, error) {
+	return c.complete(ctx, prompt.SummarizeSystemPrompt, output)
+}
+
+// ReviewOutput asks the model whether a running command should be stopped, given its latest output
+func (c *BedrockClient) ReviewOutput(ctx context.Context, request, cmd, output string) (string, error) {
+	return c.complete(ctx, prompt.ReviewOutputSystemPrompt, prompt.ReviewOutputMessage(request, cmd, output))
+}
+
+// ExplainCommand asks the model for a breakdown of what a shell command does
+func (c *BedrockClient) ExplainCommand(ctx context.Context, cmd string) (string, error) {
+	return c.complete(ctx, prompt.ExplainSystemPrompt, cmd)
+}
+
+// DiagnoseError asks the model why a command failed and how to fix it, given its output and exit code
+func (c *BedrockClient) DiagnoseError(ctx context.Context, cmd, output string, exitCode int) (string, error) {
+	return c.complete(ctx, prompt.DiagnoseSystemPrompt, prompt.DiagnoseMessage(cmd, output, exitCode))
+}
+
+// complete sends a single plain-text message with the given system prompt and returns the model's answer
+func (c *BedrockClient) complete(ctx context.Context, systemPrompt, text string) (string, error) {
 	request := SonnetRequest{
 		AnthropicVersion: "bedrock-2023-05-31",
-		MaxTokens:        2048,
-		Temperature:      0.5,
+		MaxTokens:        1024,
+		Temperature:      c.RequestTemperature(ctx, *c.config.Temperature),
 		System:           systemPrompt,
 		Messages: []Message{
 			{
 				Role: "user",
 				Content: []MessageContent{
-					{Type: "text", Text: userQuery},
+					{Type: "text", Text: text},
 				},
 			},
 		},
 	}
 
+	// A cut off summary or explanation is still useful, so the stop reason is ignored
+	responseText, _, err := c.invokeModel(ctx, request)
+	return responseText, err
+}
+
+// Ping checks that Bedrock is reachable and the credentials and model ID are accepted
+// There is no free call for this, so it sends a minimal request with a single output token
+func (c *BedrockClient) Ping(ctx context.Context) error {
+	if err := ratelimit.Wait(ctx, c.limiter); err != nil {
+		return err
+	}
+
+	requestBytes, err := json.Marshal(SonnetRequest{
+		AnthropicVersion: "bedrock-2023-05-31",
+		MaxTokens:        1,
+		Messages: []Message{
+			{Role: "user", Content: []MessageContent{{Type: "text", Text: "ping"}}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	err = c.withFreshCredentials(ctx, func(client *bedrockruntime.Client) error {
+		_, err := client.InvokeModel(ctx, &bedrockruntime.InvokeModelInput{
+			ModelId:     aws.String(c.config.ModelID),
+			ContentType: aws.String("application/json"),
+			Body:        requestBytes,
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to invoke model: %w", c.networkError(err))
+	}
+	return nil
+}
+
+// invokeModel sends the request to Bedrock and extracts the response text and stop reason
+func (c *BedrockClient) invokeModel(ctx context.Context, request SonnetRequest) (string, string, error) {
+	// Wait for the local rate limiter before sending
+	if err := ratelimit.Wait(ctx, c.limiter); err != nil {
+		return "", "", err
+	}
+
+	c.applyThinking(&request)
+
 	requestBytes, err := json.Marshal(request)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return "", "", fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	response, err := c.client.InvokeModel(ctx, &bedrockruntime.InvokeModelInput{
-		ModelId:     aws.String(c.config.ModelID),
-		ContentType: aws.String("application/json"),
-		Body:        requestBytes,
+	var response *bedrockruntime.InvokeModelOutput
+	err = c.withFreshCredentials(ctx, func(client *bedrockruntime.Client) error {
+		response, err = client.InvokeModel(ctx, &bedrockruntime.InvokeModelInput{
+			ModelId:     aws.String(c.config.ModelID),
+			ContentType: aws.String("application/json"),
+			Body:        requestBytes,
+		})
+		recordRequestID(ctx, response, err)
+		return err
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to invoke model: %w", err)
+		return "", "", fmt.Errorf("failed to invoke model: %w", c.networkError(err))
 	}
 
 	var sonnetResponse SonnetResponse
 	if err := json.Unmarshal(response.Body, &sonnetResponse); err != nil {
-		return "", fmt.Errorf("failed to parse model response: %w", err)
+		return "", "", fmt.Errorf("failed to parse model response: %w", err)
 	}
 
 	// Extract the text from the response
 	if len(sonnetResponse.Content) == 0 {
-		return "", errors.New("empty response from model")
+		return "", "", errors.New("empty response from model")
 	}
 
-	var responseText string
+	var responseText, thinking string
 	for _, content := range sonnetResponse.Content {
-		if content.Type == "text" {
+		switch content.Type {
+		case "text":
 			responseText += content.Text
+		case "thinking":
+			thinking += content.Thinking
 		}
 	}
 
-	return responseText, nil
+	// The stop sequence isn't part of the text, restore it so the response is complete
+	if sonnetResponse.StopReason == "stop_sequence" {
+		responseText += sonnetResponse.StopSequence
+	}
+
+	c.HandleThinking(thinking)
+	c.HandleServedModel(sonnetResponse.Model, "")
+
+	return responseText, sonnetResponse.StopReason, nil
+}
+
+// streamEvent is a chunk of a streamed response
+type streamEvent struct {
+	Type string `json:"type"`
+	// Message is set on the message_start event
+	Message struct {
+		Model string `json:"model"`
+	} `json:"message"`
+	Delta struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		Thinking string `json:"thinking"`
+		// StopReason and StopSequence are set on the message_delta event
+		StopReason   string `json:"stop_reason"`
+		StopSequence string `json:"stop_sequence"`
+	} `json:"delta"`
+}
+
+// invokeModelWithStream sends the request to Bedrock and reads the response stream
+// It returns the response text and stop reason
+func (c *BedrockClient) invokeModelWithStream(ctx context.Context, request SonnetRequest, onText func(text string)) (string, string, error) {
+	// Wait for the local rate limiter before sending
+	if err := ratelimit.Wait(ctx, c.limiter); err != nil {
+		return "", "", err
+	}
+
+	c.applyThinking(&request)
+
+	requestBytes, err := json.Marshal(request)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	var response *bedrockruntime.InvokeModelWithResponseStreamOutput
+	err = c.withFreshCredentials(ctx, func(client *bedrockruntime.Client) error {
+		response, err = client.InvokeModelWithResponseStream(ctx, &bedrockruntime.InvokeModelWithResponseStreamInput{
+			ModelId:     aws.String(c.config.ModelID),
+			ContentType: aws.String("application/json"),
+			Body:        requestBytes,
+		})
+		recordRequestID(ctx, response, err)
+		return err
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to invoke model: %w", c.networkError(err))
+	}
+
+	stream := response.GetStream()
+	defer stream.Close()
+
+	var responseText, thinking strings.Builder
+	var stopReason, servedModel string
+	for event := range stream.Events() {
+		chunk, ok := event.(*types.ResponseStreamMemberChunk)
+		if !ok {
+			continue
+		}
+
+		var streamed streamEvent
+		if err := json.Unmarshal(chunk.Value.Bytes, &streamed); err != nil {
+			return "", "", fmt.Errorf("failed to parse stream event: %w", err)
+		}
+		if streamed.Type == "message_start" {
+			servedModel = streamed.Message.Model
+			continue
+		}
+		if streamed.Type == "message_delta" {
+			stopReason = streamed.Delta.StopReason
+			// The stop sequence isn't part of the text, restore it so the response is complete
+			if stopReason == "stop_sequence" {
+				responseText.WriteString(streamed.Delta.StopSequence)
+				if onText != nil {
+					onText(streamed.Delta.StopSequence)
+				}
+			}
+			continue
+		}
+		if streamed.Type != "content_block_delta" {
+			continue
+		}
+
+		switch streamed.Delta.Type {
+		case "text_delta":
+			responseText.WriteString(streamed.Delta.Text)
+			if onText != nil {
+				onText(streamed.Delta.Text)
+			}
+		case "thinking_delta":
+			thinking.WriteString(streamed.Delta.Thinking)
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return "", "", fmt.Errorf("failed to read response stream: %w", err)
+	}
+
+	if responseText.Len() == 0 {
+		return "", "", errors.New("empty response from model")
+	}
+
+	c.HandleThinking(thinking.String())
+	c.HandleServedModel(servedModel, "")
+
+	return responseText.String(), stopReason, nil
+}
+
+// networkError names the endpoint when a request failed because Bedrock couldn't be reached
+func (c *BedrockClient) networkError(err error) error {
+	if c.config.Endpoint != "" {
+		return network.Wrap(err, c.config.Endpoint, "check endpoint in ~/.ai/model.cfg or AI_ENDPOINT")
+	}
+	return network.Wrap(err, "Bedrock in region "+c.runtime().Options().Region, "check your network and the region")
+}
+
+// recordRequestID records Bedrock's request ID on the context so it can be logged next to ours
+// The ID is taken from the response metadata, or from the error if the request failed
+func recordRequestID(ctx context.Context, response interface{}, err error) {
+	var responseErr *awshttp.ResponseError
+	if errors.As(err, &responseErr) {
+		requestid.SetProvider(ctx, responseErr.ServiceRequestID())
+		return
+	}
+	// A request that failed before reaching Bedrock, e.g. on the credentials, has neither an ID nor a response
+	if err != nil {
+		return
+	}
+
+	var metadata middleware.Metadata
+	switch r := response.(type) {
+	case *bedrockruntime.InvokeModelOutput:
+		if r == nil {
+			return
+		}
+		metadata = r.ResultMetadata
+	case *bedrockruntime.InvokeModelWithResponseStreamOutput:
+		if r == nil {
+			return
+		}
+		metadata = r.ResultMetadata
+	default:
+		return
+	}
+	if id, ok := awsmiddleware.GetRequestIDMetadata(metadata); ok {
+		requestid.SetProvider(ctx, id)
+	}
 }
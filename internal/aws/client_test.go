@@ -0,0 +1,186 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/nir/ai.go/internal/command"
+)
+
+// newTestClient returns a client sending its requests to a test server running handler, with static credentials
+func newTestClient(t *testing.T, modelConfig *ModelConfig, handler http.HandlerFunc) *BedrockClient {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	if modelConfig.ModelID == "" {
+		modelConfig.ModelID = ModelID
+	}
+	if modelConfig.Temperature == nil {
+		temperature := 0.5
+		modelConfig.Temperature = &temperature
+	}
+	if modelConfig.MaxTokens == 0 {
+		modelConfig.MaxTokens = 2048
+	}
+	modelConfig.Endpoint = server.URL
+
+	client := &BedrockClient{config: modelConfig}
+	setCredentials(client, credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""))
+	return client
+}
+
+// setCredentials replaces the Bedrock client of c with one signing its requests with the credentials from provider
+func setCredentials(c *BedrockClient, provider aws.CredentialsProvider) {
+	c.client = bedrockruntime.New(bedrockruntime.Options{
+		Region:      "us-east-1",
+		Credentials: provider,
+	}, clientOptions(c.config)...)
+}
+
+// respond writes a Bedrock Claude response with the given text
+func respond(t *testing.T, w http.ResponseWriter, text, stopReason string) {
+	t.Helper()
+	w.Header().Set("Content-Type", "application/json")
+	response := map[string]any{
+		"model":       ModelID,
+		"content":     []map[string]string{{"type": "text", "text": text}},
+		"stop_reason": stopReason,
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetCommandSuggestionProse(t *testing.T) {
+	tests := []struct {
+		name        string
+		prefillJSON bool
+		response    string
+	}{
+		{
+			name:     "prose before the object",
+			response: "Sure, here's the command you need:\n\n" + `{"safe": true, "command": "ls -la", "reason": "Lists the files", "is_final": true, "needs_output": false}`,
+		},
+		{
+			name:        "prose after the prefilled object",
+			prefillJSON: true,
+			response:    `"safe": true, "command": "ls -la", "reason": "Lists the files", "is_final": true, "needs_output": false}` + "\n\nLet me know if you need anything else!",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, &ModelConfig{PrefillJSON: tt.prefillJSON}, func(w http.ResponseWriter, r *http.Request) {
+				var request SonnetRequest
+				if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+					t.Errorf("failed to decode request: %v", err)
+				}
+				if prefilled := request.Messages[len(request.Messages)-1].Role == "assistant"; prefilled != tt.prefillJSON {
+					t.Errorf("prefilled = %v, want %v", prefilled, tt.prefillJSON)
+				}
+				respond(t, w, tt.response, "end_turn")
+			})
+
+			response, err := client.GetCommandSuggestion(context.Background(), nil, "list the files", "/tmp", nil, "")
+			if err != nil {
+				t.Fatalf("GetCommandSuggestion failed: %v", err)
+			}
+			cmd, err := command.ParseCommandResponse(response)
+			if err != nil {
+				t.Fatalf("ParseCommandResponse(%q) failed: %v", response, err)
+			}
+			if cmd.Command != "ls -la" || !cmd.IsFinal {
+				t.Errorf("got %+v", cmd)
+			}
+		})
+	}
+}
+
+func TestClientOptionsEndpoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+	}{
+		{name: "default"},
+		{name: "configured", endpoint: "https://bedrock.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var options bedrockruntime.Options
+			for _, apply := range clientOptions(&ModelConfig{Endpoint: tt.endpoint}) {
+				apply(&options)
+			}
+
+			if tt.endpoint == "" {
+				if options.BaseEndpoint != nil {
+					t.Errorf("BaseEndpoint = %q, want it unset", *options.BaseEndpoint)
+				}
+				return
+			}
+			if options.BaseEndpoint == nil || *options.BaseEndpoint != tt.endpoint {
+				t.Errorf("BaseEndpoint = %v, want %q", options.BaseEndpoint, tt.endpoint)
+			}
+		})
+	}
+}
+
+// TestCredentialsError checks that a request failing before it reaches Bedrock, on the credentials or a cancelled
+// context, is reported as an error rather than crashing on the missing response
+func TestCredentialsError(t *testing.T) {
+	client := newTestClient(t, &ModelConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the request was sent without credentials")
+	})
+	setCredentials(client, aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{}, errors.New("no credentials configured")
+	}))
+
+	if _, err := client.GetCommandSuggestion(context.Background(), nil, "list the files", "/tmp", nil, ""); err == nil {
+		t.Error("GetCommandSuggestion succeeded without credentials")
+	}
+	if _, err := client.StreamCommandSuggestion(context.Background(), nil, "list the files", "/tmp", nil, "", nil); err == nil {
+		t.Error("StreamCommandSuggestion succeeded without credentials")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.GetCommandSuggestion(ctx, nil, "list the files", "/tmp", nil, ""); err == nil {
+		t.Error("GetCommandSuggestion succeeded with a cancelled context")
+	}
+}
+
+func TestValidateModel(t *testing.T) {
+	valid := []string{
+		ModelID,
+		"us.anthropic.claude-3-7-sonnet-20250219-v1:0",
+		"us-gov.anthropic.claude-3-7-sonnet-20250219-v1:0",
+		"apac.anthropic.claude-3-7-sonnet-20250219-v1:0",
+		"global.anthropic.claude-sonnet-4-20250514-v1:0",
+		"arn:aws:bedrock:us-east-1:123456789012:inference-profile/us.anthropic.claude-3-7-sonnet-20250219-v1:0",
+	}
+	for _, modelID := range valid {
+		if err := ValidateModel(modelID); err != nil {
+			t.Errorf("ValidateModel(%q) = %v", modelID, err)
+		}
+	}
+
+	invalid := []string{
+		"anthropic.claude-3-7-sonnet-20250219",
+		"claude-3-7-sonnet-20250219-v1:0",
+		"globals.anthropic.claude-sonnet-4-20250514-v1:0",
+		"amazon.titan-text-express-v1",
+	}
+	for _, modelID := range invalid {
+		if err := ValidateModel(modelID); err == nil {
+			t.Errorf("ValidateModel(%q) accepted an invalid model ID", modelID)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/smithy-go"
+)
+
+// expiredTokenCodes are the error codes AWS responds with when the credentials a request was signed with have expired
+var expiredTokenCodes = map[string]bool{
+	"ExpiredToken":          true,
+	"ExpiredTokenException": true,
+}
+
+// runtime returns the current Bedrock client
+func (c *BedrockClient) runtime() *bedrockruntime.Client {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.client
+}
+
+// withFreshCredentials runs invoke with the Bedrock client, and if it failed because the credentials expired,
+// reloads the AWS config once and runs it again. Reloading picks up a renewed SSO token or credentials file,
+// e.g. after aws sso login in another terminal, so a long session survives the credentials expiring.
+func (c *BedrockClient) withFreshCredentials(ctx context.Context, invoke func(client *bedrockruntime.Client) error) error {
+	err := invoke(c.runtime())
+	if !credentialsExpired(err) {
+		return err
+	}
+
+	fmt.Fprintln(os.Stderr, "The AWS credentials have expired, reloading them and retrying...")
+	if refreshErr := c.refreshCredentials(ctx); refreshErr != nil {
+		return errors.Join(c.reauthError(err), refreshErr)
+	}
+	err = invoke(c.runtime())
+	if credentialsExpired(err) {
+		return c.reauthError(err)
+	}
+	return err
+}
+
+// refreshCredentials reloads the AWS config with the options the client was created with and replaces the Bedrock client
+func (c *BedrockClient) refreshCredentials(ctx context.Context) error {
+	client, err := newRuntimeClient(ctx, c.config, c.loadOptions)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	c.client = client
+	c.mutex.Unlock()
+	return nil
+}
+
+// reauthError explains that the credentials are still expired after reloading them and how to renew them
+func (c *BedrockClient) reauthError(err error) error {
+	var tokenErr *ssocreds.InvalidTokenError
+	if errors.As(err, &tokenErr) || c.config.CredentialsSource == "sso" {
+		login := "aws sso login"
+		if c.config.Profile != "" {
+			login += " --profile " + c.config.Profile
+		}
+		return fmt.Errorf("the AWS SSO session has expired, run '%s' and try again: %w", login, err)
+	}
+	return fmt.Errorf("the AWS credentials have expired, renew them and try again: %w", err)
+}
+
+// credentialsExpired reports whether a request failed because the SSO session or the credentials expired
+func credentialsExpired(err error) bool {
+	if err == nil {
+		return false
+	}
+	var tokenErr *ssocreds.InvalidTokenError
+	if errors.As(err, &tokenErr) {
+		return true
+	}
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && expiredTokenCodes[apiErr.ErrorCode()]
+}
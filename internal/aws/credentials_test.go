@@ -0,0 +1,76 @@
+package aws
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+)
+
+// expiringProvider fails with an expired SSO token the first expired times it is asked for credentials, then succeeds
+type expiringProvider struct {
+	expired   int32
+	retrieved atomic.Int32
+}
+
+func (p *expiringProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	if p.retrieved.Add(1) <= p.expired {
+		return aws.Credentials{}, &ssocreds.InvalidTokenError{}
+	}
+	return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET", Source: "test"}, nil
+}
+
+// newExpiringClient returns a client whose credentials come from provider, also when they are reloaded
+func newExpiringClient(t *testing.T, provider aws.CredentialsProvider, served *atomic.Int32) *BedrockClient {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	client := newTestClient(t, &ModelConfig{Profile: "dev"}, func(w http.ResponseWriter, r *http.Request) {
+		served.Add(1)
+		respond(t, w, `{"safe": true, "command": "ls", "reason": "Lists the files", "is_final": true, "needs_output": false}`, "end_turn")
+	})
+	setCredentials(client, provider)
+	client.loadOptions = []func(*config.LoadOptions) error{
+		config.WithRegion("us-east-1"),
+		config.WithCredentialsProvider(provider),
+	}
+	return client
+}
+
+func TestExpiredCredentialsReloaded(t *testing.T) {
+	provider := &expiringProvider{expired: 1}
+	var served atomic.Int32
+	client := newExpiringClient(t, provider, &served)
+
+	response, err := client.GetCommandSuggestion(context.Background(), nil, "list the files", "/tmp", nil, "")
+	if err != nil {
+		t.Fatalf("GetCommandSuggestion failed after reloading the credentials: %v", err)
+	}
+	if !strings.Contains(response, `"command": "ls"`) {
+		t.Errorf("unexpected response %q", response)
+	}
+	if served.Load() != 1 {
+		t.Errorf("the server got %d requests, want 1", served.Load())
+	}
+}
+
+func TestExpiredCredentialsReauth(t *testing.T) {
+	provider := &expiringProvider{expired: 1000}
+	var served atomic.Int32
+	client := newExpiringClient(t, provider, &served)
+
+	_, err := client.GetCommandSuggestion(context.Background(), nil, "list the files", "/tmp", nil, "")
+	if err == nil {
+		t.Fatal("GetCommandSuggestion succeeded with expired credentials")
+	}
+	if !strings.Contains(err.Error(), "aws sso login --profile dev") {
+		t.Errorf("the error doesn't tell how to log in again: %v", err)
+	}
+	if served.Load() != 0 {
+		t.Errorf("the server got %d requests without credentials", served.Load())
+	}
+}
@@ -0,0 +1,128 @@
+package clientopts
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/nir/ai.go/internal/prompt"
+)
+
+// Options are the settings every client supports besides its config file, they may change while requests are in flight
+type Options struct {
+	// PromptAdditions are extra instructions appended to the command suggestion system prompt
+	PromptAdditions []string
+	// GitContext is the git repository summary included in the command suggestion system prompt
+	GitContext string
+	// SchemaVersion is the command response schema version requested in the system prompt
+	SchemaVersion string
+	// FilesTruncated tells whether the file list passed with suggestion requests is incomplete
+	FilesTruncated bool
+	// FileTree is a directory tree shown in the command suggestion system prompt instead of the file list
+	FileTree string
+	// CustomSystemPrompt is used instead of the built-in system prompt for command suggestions
+	// Its placeholders are substituted, see prompt.RenderCustomSystemPrompt
+	CustomSystemPrompt string
+	// Language is the language of the reasons and other text in command suggestions, empty for English
+	Language string
+	// Temperature overrides the configured sampling temperature of all requests (optional)
+	Temperature *float64
+	// ThinkingHandler receives the model's reasoning, from extended thinking or <think> tags (optional)
+	ThinkingHandler func(thinking string)
+	// ServedModelHandler receives the model version the provider reports for each response (optional)
+	ServedModelHandler func(model, fingerprint string)
+}
+
+// Holder keeps a client's options and builds the parts of requests that depend on them
+// The clients embed it, it is safe for concurrent use.
+type Holder struct {
+	mutex   sync.RWMutex
+	options Options
+}
+
+// Configure changes the options with update, requests in flight see either all of the changes or none
+func (h *Holder) Configure(update func(options *Options)) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	update(&h.options)
+	// The caller may reuse its slice, so the options get their own
+	h.options.PromptAdditions = slices.Clone(h.options.PromptAdditions)
+}
+
+// Options returns the current options
+func (h *Holder) Options() Options {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.options
+}
+
+// alternativeKey is the context key of the alternative a request asks for, see WithAlternative
+type alternativeKey struct{}
+
+// alternative is the index of a request among count requests asking for alternatives
+type alternative struct {
+	index, count int
+}
+
+// maxAlternativeTemperature is the temperature of the last alternative, unless the request temperature is higher
+// It is the highest temperature all providers accept.
+const maxAlternativeTemperature = 1.0
+
+// WithAlternative marks the requests made with ctx as asking for alternative index (from 0) of count
+// Their temperatures are spread from the request temperature up to maxAlternativeTemperature,
+// so the alternatives differ even at temperature 0.
+func WithAlternative(ctx context.Context, index, count int) context.Context {
+	return context.WithValue(ctx, alternativeKey{}, alternative{index: index, count: count})
+}
+
+// RequestTemperature returns the temperature of a request made with ctx: the temperature overriding
+// the configured one, or configured if there is none, raised for an alternative, see WithAlternative
+func (h *Holder) RequestTemperature(ctx context.Context, configured float64) float64 {
+	temperature := configured
+	if override := h.Options().Temperature; override != nil {
+		temperature = *override
+	}
+
+	alt, ok := ctx.Value(alternativeKey{}).(alternative)
+	if !ok || alt.count < 2 || temperature >= maxAlternativeTemperature {
+		return temperature
+	}
+	return temperature + (maxAlternativeTemperature-temperature)*float64(alt.index)/float64(alt.count-1)
+}
+
+// PromptFiles describes the files passed with a suggestion request
+func (h *Holder) PromptFiles(filesList []string) prompt.Files {
+	options := h.Options()
+	return prompt.Files{List: filesList, Truncated: options.FilesTruncated, Tree: options.FileTree}
+}
+
+// BuildSystemPrompt builds the system prompt used for command suggestions with the given files
+func (h *Holder) BuildSystemPrompt(currentDir string, files prompt.Files, commandHistory string) string {
+	options := h.Options()
+
+	var systemPrompt string
+	if options.CustomSystemPrompt != "" {
+		systemPrompt = prompt.RenderCustomSystemPrompt(options.CustomSystemPrompt, currentDir, files, commandHistory)
+	} else {
+		systemPrompt = prompt.BuildSystemPrompt(currentDir, files, commandHistory, options.SchemaVersion)
+	}
+	systemPrompt = prompt.AppendGitContext(systemPrompt, options.GitContext)
+	systemPrompt = prompt.AppendLanguage(systemPrompt, options.Language)
+	return prompt.AppendInstructions(systemPrompt, options.PromptAdditions)
+}
+
+// HandleThinking hands the model's reasoning to the thinking handler, it is never part of the returned text
+func (h *Holder) HandleThinking(thinking string) {
+	if handler := h.Options().ThinkingHandler; handler != nil && strings.TrimSpace(thinking) != "" {
+		handler(thinking)
+	}
+}
+
+// HandleServedModel hands the model version reported by a response to the served model handler
+// The fingerprint is only reported by some providers
+func (h *Holder) HandleServedModel(model, fingerprint string) {
+	if handler := h.Options().ServedModelHandler; handler != nil && (model != "" || fingerprint != "") {
+		handler(model, fingerprint)
+	}
+}
@@ -0,0 +1,56 @@
+package clientopts
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestConfigure(t *testing.T) {
+	var h Holder
+	additions := []string{"Prefer ripgrep over grep."}
+	h.Configure(func(options *Options) {
+		options.PromptAdditions = additions
+		options.Language = "French"
+	})
+	h.Configure(func(options *Options) {
+		options.GitContext = "Branch: main"
+	})
+
+	// Later updates keep the earlier options, and the caller's slice isn't shared
+	additions[0] = "Prefer grep over ripgrep."
+	systemPrompt := h.BuildSystemPrompt("/tmp", h.PromptFiles([]string{"a.txt"}), "")
+	for _, want := range []string{"Prefer ripgrep over grep.", "French", "Branch: main"} {
+		if !strings.Contains(systemPrompt, want) {
+			t.Errorf("system prompt doesn't contain %q", want)
+		}
+	}
+	if strings.Contains(systemPrompt, "Prefer grep over ripgrep.") {
+		t.Error("changing the caller's slice changed the prompt additions")
+	}
+}
+
+func TestRequestTemperature(t *testing.T) {
+	var h Holder
+	ctx := context.Background()
+	if got := h.RequestTemperature(ctx, 0.5); got != 0.5 {
+		t.Errorf("RequestTemperature = %g, want the configured 0.5", got)
+	}
+	temperature := 0.0
+	h.Configure(func(options *Options) { options.Temperature = &temperature })
+	if got := h.RequestTemperature(ctx, 0.5); got != 0 {
+		t.Errorf("RequestTemperature = %g, want the override 0", got)
+	}
+
+	// Alternatives are spread from the request temperature up to 1
+	for i, want := range []float64{0, 0.25, 0.5, 0.75, 1} {
+		if got := h.RequestTemperature(WithAlternative(ctx, i, 5), 0.5); got != want {
+			t.Errorf("RequestTemperature of alternative %d = %g, want %g", i, got, want)
+		}
+	}
+	high := 1.2
+	h.Configure(func(options *Options) { options.Temperature = &high })
+	if got := h.RequestTemperature(WithAlternative(ctx, 2, 3), 0.5); got != 1.2 {
+		t.Errorf("RequestTemperature of an alternative above 1 = %g, want 1.2", got)
+	}
+}
@@ -0,0 +1,243 @@
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// Schema versions of the command response format
+const (
+	// SchemaV1 is the original format with five fields
+	SchemaV1 = "v1"
+	// SchemaV2 adds side_effects, clarification, plan and confidence
+	SchemaV2 = "v2"
+)
+
+// schemaVersions lists the schema versions from oldest to newest
+var schemaVersions = []string{SchemaV1, SchemaV2}
+
+// ValidateSchemaVersion checks that version is a known schema version
+func ValidateSchemaVersion(version string) error {
+	if schemaIndex(version) < 0 {
+		return fmt.Errorf("unknown schema version %q, expected one of %s", version, strings.Join(schemaVersions, ", "))
+	}
+	return nil
+}
+
+// schemaIndex returns the position of version in schemaVersions, or -1 if it is unknown
+func schemaIndex(version string) int {
+	for i, v := range schemaVersions {
+		if v == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// Command represents the parsed command response from the model
+// Fields tagged with a schema version are only part of that version and later ones
+// The parser accepts every version, fields missing from the response are left empty
+type Command struct {
+	Safe        bool   `json:"safe" description:"Whether the command is safe to run automatically"`
+	Command     string `json:"command" description:"The exact command(s) to run"`
+	Reason      string `json:"reason" description:"A brief explanation of what the command does"`
+	IsFinal     bool   `json:"is_final" description:"Whether this is the final command to complete the request"`
+	NeedsOutput bool   `json:"needs_output" description:"Whether the model needs to see the output of this command to determine the next step"`
+
+	SideEffects   []string `json:"side_effects,omitempty" schema:"v2" description:"Side effects of running the command, such as files changed or deleted, services restarted or network access"`
+	Clarification string   `json:"clarification,omitempty" schema:"v2" description:"A question for the user when the request is too ambiguous to answer, with command left empty"`
+	Plan          []string `json:"plan,omitempty" schema:"v2" description:"In the first response to a request that takes several commands, a short list of the intended steps"`
+	Confidence    *float64 `json:"confidence,omitempty" schema:"v2" description:"How confident the model is that the command does what was asked, from 0 to 1"`
+}
+
+// NormalizeFlags resolves a contradictory combination of is_final and needs_output, so the flags mean one of:
+//   - is_final alone: the request is complete once the command ran
+//   - needs_output alone: the model is sent the command's output to suggest the next command
+//   - neither: more commands follow that don't depend on the output, the model is only told how the command exited
+//
+// Both together are contradictory, a model that needs to see the output isn't done, so it is treated as needs_output alone.
+// It returns a description of the change for the log, or "" if the flags were consistent.
+func (c *Command) NormalizeFlags() string {
+	if c.IsFinal && c.NeedsOutput {
+		c.IsFinal = false
+		return "The response set both is_final and needs_output, treating the command as not final as the model needs its output"
+	}
+	return ""
+}
+
+// ParseCommandResponse parses the model's response into a command structure
+func ParseCommandResponse(responseText string) (*Command, error) {
+	// Check if the response is wrapped in markdown code block
+	jsonText := responseText
+
+	// Strip markdown code block formatting if present
+	markdownStart := "```json"
+	markdownEnd := "```"
+	if strings.Contains(jsonText, markdownStart) {
+		startIndex := strings.Index(jsonText, markdownStart) + len(markdownStart)
+		endIndex := strings.LastIndex(jsonText, markdownEnd)
+		if endIndex > startIndex {
+			jsonText = jsonText[startIndex:endIndex]
+		}
+	}
+
+	// Trim any leading/trailing whitespace
+	jsonText = strings.TrimSpace(jsonText)
+
+	cmd, err := decodeCommand(jsonText)
+	if err != nil {
+		// The model may wrap the JSON in prose or an unlabeled code block despite the instructions
+		if object := extractCommandObject(responseText); object != "" && object != jsonText {
+			if cmd, objectErr := decodeCommand(object); objectErr == nil {
+				return cmd, nil
+			} else if !isSyntaxError(objectErr) {
+				err = objectErr
+			}
+		}
+		return nil, fmt.Errorf("failed to parse command response: %w", err)
+	}
+	return cmd, nil
+}
+
+// decodeCommand unmarshals a JSON object into a command and validates its fields
+// A plain unmarshal would turn a missing command into an empty one, so the required fields are checked explicitly.
+func decodeCommand(jsonText string) (*Command, error) {
+	var cmd Command
+	if err := json.Unmarshal([]byte(jsonText), &cmd); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			if typeErr.Field == "" {
+				return nil, fmt.Errorf("expected a JSON object, got %s", typeErr.Value)
+			}
+			return nil, fmt.Errorf("field %q must be %s, got %s", typeErr.Field, jsonType(typeErr.Type), typeErr.Value)
+		}
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(jsonText), &fields); err != nil {
+		return nil, err
+	}
+	present := func(name string) bool {
+		value, ok := fields[name]
+		return ok && string(value) != "null"
+	}
+
+	// A clarification question comes without a command, anything else needs one with its reason
+	if strings.TrimSpace(cmd.Command) == "" {
+		if strings.TrimSpace(cmd.Clarification) != "" {
+			return &cmd, nil
+		}
+		if !present("command") {
+			return nil, errors.New(`missing required field "command"`)
+		}
+		return nil, errors.New(`field "command" is empty and there is no clarification`)
+	}
+	if !present("reason") {
+		return nil, errors.New(`missing required field "reason"`)
+	}
+	return &cmd, nil
+}
+
+// jsonType describes the JSON type expected for a Go type, for error messages
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.String:
+		return "a string"
+	case reflect.Float32, reflect.Float64, reflect.Int, reflect.Int64:
+		return "a number"
+	case reflect.Slice:
+		return "an array of " + strings.TrimPrefix(strings.TrimPrefix(jsonType(t.Elem()), "a "), "an ") + "s"
+	case reflect.Pointer:
+		return jsonType(t.Elem())
+	default:
+		return t.String()
+	}
+}
+
+// isSyntaxError reports whether err is malformed JSON, rather than a valid object with invalid fields
+func isSyntaxError(err error) bool {
+	var syntaxErr *json.SyntaxError
+	return errors.As(err, &syntaxErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// extractCommandObject returns the first JSON object in text with a command field, or "" if there is none
+// Other objects are skipped, such as the {} of a find -exec mentioned in the prose
+func extractCommandObject(text string) string {
+	for text != "" {
+		object := ExtractJSONObject(text)
+		var fields map[string]json.RawMessage
+		if json.Unmarshal([]byte(object), &fields) != nil {
+			return ""
+		}
+		if fields["command"] != nil {
+			return object
+		}
+		text = text[strings.Index(text, object)+len(object):]
+	}
+	return ""
+}
+
+// ExtractJSONObject returns the first complete JSON object in text, skipping any prose or markdown around it
+// ParseCommandResponse falls back to it, it can also be applied upfront for models that rarely follow the format.
+// The text is returned unchanged if it doesn't contain a complete object.
+func ExtractJSONObject(text string) string {
+	for start := strings.Index(text, "{"); start >= 0; {
+		depth := 0
+		inString, escaped := false, false
+		for i := start; i < len(text); i++ {
+			c := text[i]
+			switch {
+			case escaped:
+				escaped = false
+			case inString && c == '\\':
+				escaped = true
+			case c == '"':
+				inString = !inString
+			case inString:
+			case c == '{':
+				depth++
+			case c == '}':
+				depth--
+				if depth == 0 && json.Valid([]byte(text[start:i+1])) {
+					return text[start : i+1]
+				}
+			}
+			if depth == 0 {
+				break
+			}
+		}
+
+		// Not a valid object, try the next opening brace
+		next := strings.Index(text[start+1:], "{")
+		if next < 0 {
+			break
+		}
+		start += next + 1
+	}
+	return text
+}
+
+// Feedback is the model's verdict on the output of a command that is still running
+type Feedback struct {
+	Abort  bool   `json:"abort"`
+	Reason string `json:"reason"`
+}
+
+// ParseFeedback parses the model's response to an output review
+func ParseFeedback(responseText string) (*Feedback, error) {
+	var feedback Feedback
+	if err := json.Unmarshal([]byte(ExtractJSONObject(responseText)), &feedback); err != nil {
+		return nil, fmt.Errorf("failed to parse output review: %w", err)
+	}
+	return &feedback, nil
+}
+
+// ErrTruncated is returned when the model's response was cut off by the max_tokens limit
+var ErrTruncated = errors.New("the model's response was cut off by the max_tokens limit")
@@ -0,0 +1,91 @@
+package command
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files with the current results")
+
+// TestParseCommandResponseGolden parses each response in testdata/*.txt and compares the result,
+// the command as indented JSON or the error, with the matching .golden file
+func TestParseCommandResponseGolden(t *testing.T) {
+	responses, err := filepath.Glob(filepath.Join("testdata", "*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) == 0 {
+		t.Fatal("no responses in testdata")
+	}
+
+	for _, response := range responses {
+		name := strings.TrimSuffix(filepath.Base(response), ".txt")
+		t.Run(name, func(t *testing.T) {
+			text, err := os.ReadFile(response)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got string
+			cmd, err := ParseCommandResponse(string(text))
+			if err != nil {
+				got = "error: " + err.Error() + "\n"
+			} else {
+				encoded, err := json.MarshalIndent(cmd, "", "  ")
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = string(encoded) + "\n"
+			}
+
+			golden := strings.TrimSuffix(response, ".txt") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read golden file, run with -update to create it: %v", err)
+			}
+			if got != string(want) {
+				t.Errorf("ParseCommandResponse(%s) mismatch\ngot:\n%s\nwant:\n%s", response, got, want)
+			}
+		})
+	}
+}
+
+func TestNormalizeFlags(t *testing.T) {
+	tests := []struct {
+		name            string
+		isFinal         bool
+		needsOutput     bool
+		wantIsFinal     bool
+		wantNeedsOutput bool
+		wantNote        bool
+	}{
+		{name: "final", isFinal: true, wantIsFinal: true},
+		{name: "needs output", needsOutput: true, wantNeedsOutput: true},
+		{name: "neither", isFinal: false, needsOutput: false},
+		{name: "both", isFinal: true, needsOutput: true, wantNeedsOutput: true, wantNote: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := &Command{Command: "ls", Reason: "Lists files", IsFinal: tt.isFinal, NeedsOutput: tt.needsOutput}
+			note := cmd.NormalizeFlags()
+			if cmd.IsFinal != tt.wantIsFinal || cmd.NeedsOutput != tt.wantNeedsOutput {
+				t.Errorf("is_final=%v needs_output=%v, want is_final=%v needs_output=%v",
+					cmd.IsFinal, cmd.NeedsOutput, tt.wantIsFinal, tt.wantNeedsOutput)
+			}
+			if (note != "") != tt.wantNote {
+				t.Errorf("note = %q, want a note: %v", note, tt.wantNote)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package command
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// injectionPrograms lists programs a command smuggled in through a crafted file name would typically run,
+// with the word prefixes a reason explaining them would use (besides the program name itself)
+var injectionPrograms = map[string][]string{
+	"rm":     {"remov", "delet", "clean", "purg", "uninstall"},
+	"shred":  {"delet", "wip", "eras"},
+	"dd":     {"disk", "image", "byte", "block"},
+	"mkfs":   {"format", "filesystem"},
+	"chmod":  {"permission", "executable", "mode"},
+	"chown":  {"owner"},
+	"curl":   {"download", "upload", "fetch", "request", "http", "url", "api", "endpoint"},
+	"wget":   {"download", "fetch", "http", "url"},
+	"nc":     {"netcat", "port", "connect", "listen", "socket"},
+	"ncat":   {"netcat", "port", "connect", "listen", "socket"},
+	"ssh":    {"remote", "server", "host", "connect"},
+	"scp":    {"remote", "server", "host", "copy", "upload", "download"},
+	"sh":     {"shell", "script"},
+	"bash":   {"shell", "script"},
+	"zsh":    {"shell", "script"},
+	"eval":   {"evaluat"},
+	"base64": {"encod", "decod"},
+}
+
+// injectionWrappers lists programs that run the command given in their arguments
+var injectionWrappers = map[string]bool{
+	"sudo": true, "doas": true, "env": true, "nohup": true, "exec": true,
+	"command": true, "nice": true, "time": true, "xargs": true,
+}
+
+// injectionSeparators splits a command line into the commands it runs, including substitutions
+var injectionSeparators = strings.NewReplacer(
+	"&&", "\n", "||", "\n", "$(", "\n", "<(", "\n", ">(", "\n", "`", "\n", "|", "\n", ";", "\n", "&", "\n",
+)
+
+// LooksInjected reports whether a command looks like it contains text injected from outside the model's intent,
+// such as a file named "; rm -rf ~" echoed into the command line. It flags control and invisible formatting
+// characters, and commands chained or substituted after the first one that run a destructive or network
+// program the reason doesn't mention. This is a best-effort check that does not handle quoting.
+func LooksInjected(cmd, reason string) bool {
+	for _, r := range cmd {
+		if r != '\t' && r != '\n' && (unicode.IsControl(r) || unicode.Is(unicode.Cf, r)) {
+			return true
+		}
+	}
+
+	reasonWords := strings.FieldsFunc(strings.ToLower(reason), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	segments := strings.Split(injectionSeparators.Replace(cmd), "\n")
+	for _, segment := range segments[1:] {
+		program := segmentProgram(strings.Fields(segment))
+		keywords, dangerous := injectionPrograms[program]
+		if dangerous && !mentions(reasonWords, program, keywords) {
+			return true
+		}
+	}
+	return false
+}
+
+// segmentProgram returns the program a command runs, looking through environment variables and wrappers such as sudo
+func segmentProgram(words []string) string {
+	for _, word := range words {
+		word = strings.TrimLeft(word, "({!")
+		switch {
+		case word == "", strings.HasPrefix(word, "-"), strings.Contains(word, "="), injectionWrappers[word]:
+			continue
+		}
+		return filepath.Base(strings.Trim(word, `'"`))
+	}
+	return ""
+}
+
+// mentions reports whether one of words is program or starts with one of keywords
+func mentions(words []string, program string, keywords []string) bool {
+	for _, word := range words {
+		if word == program {
+			return true
+		}
+		for _, keyword := range keywords {
+			if strings.HasPrefix(word, keyword) {
+				return true
+			}
+		}
+	}
+	return false
+}
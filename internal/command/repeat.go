@@ -0,0 +1,40 @@
+package command
+
+import "strings"
+
+// Normalize reduces a command line to a form in which near-identical suggestions compare equal
+// Whitespace is collapsed and trailing semicolons are dropped, so "ls  -la;" and "ls -la" are the same command
+func Normalize(cmd string) string {
+	cmd = strings.Join(strings.Fields(cmd), " ")
+	return strings.TrimSpace(strings.TrimRight(cmd, "; "))
+}
+
+// Failures remembers the commands that failed in a task, to notice when the model suggests one of them again
+type Failures struct {
+	// statuses maps normalized commands to how they failed, e.g. "exited with code 1"
+	statuses map[string]string
+	// repeats counts the suggestions of commands that had already failed
+	repeats int
+}
+
+// Record remembers that a command failed and how
+func (f *Failures) Record(cmd, status string) {
+	if f.statuses == nil {
+		f.statuses = make(map[string]string)
+	}
+	f.statuses[Normalize(cmd)] = status
+}
+
+// Repeated reports whether cmd already failed, returning how it failed and counting the repeat
+func (f *Failures) Repeated(cmd string) (status string, repeated bool) {
+	status, repeated = f.statuses[Normalize(cmd)]
+	if repeated {
+		f.repeats++
+	}
+	return status, repeated
+}
+
+// Repeats returns the number of times a command that had already failed was suggested again
+func (f *Failures) Repeats() int {
+	return f.repeats
+}
@@ -0,0 +1,99 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// JSONSchema returns the JSON Schema of the given version of the Command response format
+// The schema is generated from the struct's json, description and schema tags, so it always matches what the parser expects
+func JSONSchema(version string) ([]byte, error) {
+	if err := ValidateSchemaVersion(version); err != nil {
+		return nil, err
+	}
+
+	schema, err := typeSchema(reflect.TypeOf(Command{}), version)
+	if err != nil {
+		return nil, err
+	}
+
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "Command"
+	schema["version"] = version
+
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+// typeSchema builds the schema of a single Go type
+func typeSchema(t reflect.Type, version string) (map[string]interface{}, error) {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem(), version)
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}, nil
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}, nil
+	case reflect.Slice, reflect.Array:
+		items, err := typeSchema(t.Elem(), version)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "array", "items": items}, nil
+	case reflect.Struct:
+		return structSchema(t, version)
+	}
+	return nil, fmt.Errorf("unsupported type in schema: %s", t)
+}
+
+// structSchema builds an object schema from the exported fields of a struct that are part of the schema version
+func structSchema(t reflect.Type, version string) (map[string]interface{}, error) {
+	properties := map[string]interface{}{}
+	required := []string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		// Skip fields added in a later version
+		if fieldVersion := field.Tag.Get("schema"); fieldVersion != "" && schemaIndex(fieldVersion) > schemaIndex(version) {
+			continue
+		}
+
+		fieldSchema, err := typeSchema(field.Type, version)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field.Name, err)
+		}
+		if description := field.Tag.Get("description"); description != "" {
+			fieldSchema["description"] = description
+		}
+		properties[name] = fieldSchema
+
+		// Fields without omitempty are always expected in the response
+		if !strings.Contains(options, "omitempty") && field.Type.Kind() != reflect.Ptr {
+			required = append(required, name)
+		}
+	}
+
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}, nil
+}
@@ -0,0 +1,194 @@
+package command
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+// FieldStreamer incrementally parses a streamed command response
+// It reports each top-level field of the JSON object as soon as its value is complete
+type FieldStreamer struct {
+	buf     strings.Builder
+	emitted map[string]bool
+	failed  bool
+	onField func(name string, value interface{})
+	// onPartial receives the text of a string field while it is still being generated, see SetPartialHandler
+	onPartial func(name, text string)
+}
+
+// NewFieldStreamer creates a streamer that calls onField once for every completed field
+func NewFieldStreamer(onField func(name string, value interface{})) *FieldStreamer {
+	return &FieldStreamer{
+		emitted: make(map[string]bool),
+		onField: onField,
+	}
+}
+
+// SetPartialHandler sets a handler receiving the text generated so far of a string field that isn't complete yet,
+// after each chunk that adds to it. onField is still called once the field is complete.
+func (f *FieldStreamer) SetPartialHandler(handler func(name, text string)) {
+	f.onPartial = handler
+}
+
+// Write adds a chunk of the streamed response and reports any newly completed fields
+func (f *FieldStreamer) Write(chunk string) {
+	if f.failed {
+		return
+	}
+	f.buf.WriteString(chunk)
+	f.scan()
+}
+
+// Failed reports whether the response couldn't be parsed incrementally
+// Callers should fall back to parsing the whole response with ParseCommandResponse
+func (f *FieldStreamer) Failed() bool {
+	return f.failed
+}
+
+// scan walks the buffered text and emits the fields that are complete so far
+func (f *FieldStreamer) scan() {
+	s := f.buf.String()
+
+	// Skip anything before the object, such as a markdown code fence
+	start := strings.IndexByte(s, '{')
+	if start < 0 {
+		return
+	}
+
+	pos := start + 1
+	for {
+		pos = skipSeparators(s, pos)
+		if pos >= len(s) || s[pos] == '}' {
+			return
+		}
+		if s[pos] != '"' {
+			f.failed = true
+			return
+		}
+
+		keyEnd, complete := scanString(s, pos)
+		if !complete {
+			return
+		}
+		var key string
+		if err := json.Unmarshal([]byte(s[pos:keyEnd]), &key); err != nil {
+			f.failed = true
+			return
+		}
+
+		pos = skipSpace(s, keyEnd)
+		if pos >= len(s) {
+			return
+		}
+		if s[pos] != ':' {
+			f.failed = true
+			return
+		}
+		pos = skipSpace(s, pos+1)
+		if pos >= len(s) {
+			return
+		}
+
+		valueEnd, complete := scanValue(s, pos)
+		if !complete {
+			if s[pos] == '"' && f.onPartial != nil && !f.emitted[key] {
+				if text, ok := partialString(s[pos:]); ok {
+					f.onPartial(key, text)
+				}
+			}
+			return
+		}
+
+		if !f.emitted[key] {
+			var value interface{}
+			if err := json.Unmarshal([]byte(s[pos:valueEnd]), &value); err != nil {
+				f.failed = true
+				return
+			}
+			f.emitted[key] = true
+			f.onField(key, value)
+		}
+		pos = valueEnd
+	}
+}
+
+// partialString decodes the start of a JSON string whose closing quote hasn't been generated yet
+// An escape sequence or UTF-8 character cut off at the end is left out until it is complete
+func partialString(raw string) (string, bool) {
+	for i := 0; i <= len(`\uXXXX`) && len(raw) > 0; i++ {
+		if utf8.ValidString(raw) {
+			var text string
+			if err := json.Unmarshal([]byte(raw+`"`), &text); err == nil {
+				return text, true
+			}
+		}
+		raw = raw[:len(raw)-1]
+	}
+	return "", false
+}
+
+// skipSpace returns the position of the next non-whitespace character
+func skipSpace(s string, pos int) int {
+	for pos < len(s) && strings.IndexByte(" \t\r\n", s[pos]) >= 0 {
+		pos++
+	}
+	return pos
+}
+
+// skipSeparators skips whitespace and the commas between fields
+func skipSeparators(s string, pos int) int {
+	for pos < len(s) && strings.IndexByte(" \t\r\n,", s[pos]) >= 0 {
+		pos++
+	}
+	return pos
+}
+
+// scanString returns the end of the JSON string starting at pos and whether it is complete
+func scanString(s string, pos int) (int, bool) {
+	for i := pos + 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1, true
+		}
+	}
+	return len(s), false
+}
+
+// scanValue returns the end of the JSON value starting at pos and whether it is complete
+func scanValue(s string, pos int) (int, bool) {
+	switch s[pos] {
+	case '"':
+		return scanString(s, pos)
+	case '{', '[':
+		depth := 0
+		for i := pos; i < len(s); i++ {
+			switch s[i] {
+			case '"':
+				end, complete := scanString(s, i)
+				if !complete {
+					return len(s), false
+				}
+				i = end - 1
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return i + 1, true
+				}
+			}
+		}
+		return len(s), false
+	default:
+		// Literals (true, false, null, numbers) end at the next delimiter
+		for i := pos; i < len(s); i++ {
+			if strings.IndexByte(",}] \t\r\n", s[i]) >= 0 {
+				return i, true
+			}
+		}
+		return len(s), false
+	}
+}
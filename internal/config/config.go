@@ -0,0 +1,169 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Config holds the general (provider independent) settings from ~/.ai/ai.cfg
+type Config struct {
+	Exclude []string `json:"exclude,omitempty"`
+	// SchemaVersion selects the command response format, "v1" (default) or "v2"
+	SchemaVersion string `json:"schema_version,omitempty"`
+	// AlwaysAllow lists command prefixes that never ask for confirmation, e.g. "git status"
+	AlwaysAllow []string `json:"always_allow,omitempty"`
+	// ConfirmAll asks for confirmation before every command, not only unsafe ones
+	ConfirmAll bool `json:"confirm_all,omitempty"`
+	// MaxFiles limits the number of files listed in the prompt, 0 uses the default
+	MaxFiles int `json:"max_files,omitempty"`
+	// HistoryFilter selects the log entries sent as history, "commands", "commands+output" (default) or "all"
+	HistoryFilter string `json:"history_filter,omitempty"`
+	// Theme selects the console colors, "default", "mono" or "high-contrast"
+	Theme string `json:"theme,omitempty"`
+	// Language is the language of the reasons and other text in command suggestions, English if empty
+	Language string `json:"language,omitempty"`
+	// TemperatureProfile selects a named temperature for all requests, see TemperatureProfiles, the provider config's temperature applies if empty
+	TemperatureProfile string `json:"temperature_profile,omitempty"`
+	// TemperatureProfiles adds named temperatures or changes the built-in ones, e.g. {"exact": 0.1}
+	TemperatureProfiles map[string]float64 `json:"temperature_profiles,omitempty"`
+	// SingleStep runs only the first suggested command, treating every response as final
+	SingleStep bool `json:"single_step,omitempty"`
+	// TimestampOutput prefixes each line of command output in the log file with the time it was printed
+	TimestampOutput bool `json:"timestamp_output,omitempty"`
+	// ForbiddenDirs lists directories, e.g. "~/.ssh", in which no command is run, only suggested in ask mode
+	ForbiddenDirs []string `json:"forbidden_dirs,omitempty"`
+	// AuditWebhook is a URL every executed command is posted to as a JSON event
+	AuditWebhook string `json:"audit_webhook,omitempty"`
+	// DefaultInstructions is an instruction added to the system prompt of every request, before those from --append-prompt
+	DefaultInstructions string `json:"default_instructions,omitempty"`
+}
+
+// dir resolves the configuration directory once, the result doesn't change while ai runs
+var dir = sync.OnceValues(resolveDir)
+
+// Dir returns the ~/.ai directory, creating it if needed
+// When there is no usable home directory it falls back to $AI_CONFIG_DIR, then to a temporary directory
+func Dir() (string, error) {
+	return dir()
+}
+
+// resolveDir returns the first candidate configuration directory that can be created and written to
+func resolveDir() (string, error) {
+	var candidates []string
+	homeDir, homeErr := os.UserHomeDir()
+	if homeErr == nil {
+		candidates = append(candidates, filepath.Join(homeDir, ".ai"))
+	}
+	if envDir := os.Getenv("AI_CONFIG_DIR"); envDir != "" {
+		candidates = append(candidates, envDir)
+	}
+	tempDir := filepath.Join(os.TempDir(), fmt.Sprintf("ai-%d", os.Getuid()))
+	candidates = append(candidates, tempDir)
+
+	var errs []error
+	for _, candidate := range candidates {
+		check := ensureWritable
+		if candidate == tempDir {
+			check = ensurePrivate
+		}
+		if err := check(candidate); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if candidate == tempDir {
+			warnTemporary(tempDir)
+		}
+		return candidate, nil
+	}
+
+	// The fixed temporary directory may be taken by another user, a directory with a random name can't be
+	randomDir, err := os.MkdirTemp("", fmt.Sprintf("ai-%d-*", os.Getuid()))
+	if err == nil {
+		warnTemporary(randomDir)
+		return randomDir, nil
+	}
+	errs = append(errs, fmt.Errorf("failed to create a temporary directory: %w", err))
+
+	if homeErr != nil {
+		errs = append([]error{fmt.Errorf("failed to get user home directory: %w", homeErr)}, errs...)
+	}
+	return "", fmt.Errorf("failed to find a writable .ai directory: %w", errors.Join(errs...))
+}
+
+// warnTemporary warns that the configuration is kept in the temporary directory dir, so it won't persist
+func warnTemporary(dir string) {
+	fmt.Fprintf(os.Stderr, "Warning: no usable home directory, using %s. Settings and history will not persist; set AI_CONFIG_DIR to keep them.\n", dir)
+}
+
+// ensurePrivate creates dir if needed and checks that it is a directory only the current user can access
+// The temporary directory is shared, so another user could have created dir, or a symlink named like it,
+// to read the log or plant a configuration.
+func ensurePrivate(dir string) error {
+	if err := os.Mkdir(dir, 0700); err != nil && !errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory, it may be a symlink", dir)
+	}
+	if !ownedByCurrentUser(info) {
+		return fmt.Errorf("%s is owned by another user", dir)
+	}
+	// Earlier versions created the directory readable by everyone
+	if info.Mode().Perm()&0077 != 0 {
+		if err := os.Chmod(dir, 0700); err != nil {
+			return fmt.Errorf("failed to make %s private: %w", dir, err)
+		}
+	}
+	return ensureWritable(dir)
+}
+
+// ensureWritable creates dir if needed and checks that files can be created in it
+func ensureWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	probe, err := os.CreateTemp(dir, ".write-test-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", dir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return nil
+}
+
+// Load loads the general configuration from ~/.ai/ai.cfg
+// A missing config file is not an error, an empty configuration is returned instead
+func Load() (*Config, error) {
+	aiDir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+
+	configPath := filepath.Join(aiDir, "ai.cfg")
+
+	configData, err := os.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(configData, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	return &config, nil
+}
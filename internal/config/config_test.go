@@ -0,0 +1,84 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnsurePrivate(t *testing.T) {
+	t.Run("created", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "ai")
+		if err := ensurePrivate(dir); err != nil {
+			t.Fatalf("ensurePrivate failed: %v", err)
+		}
+		info, err := os.Stat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0700 {
+			t.Errorf("the directory was created with mode %v, want 0700", perm)
+		}
+	})
+
+	t.Run("readable by everyone", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "ai")
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ensurePrivate(dir); err != nil {
+			t.Fatalf("ensurePrivate failed: %v", err)
+		}
+		info, err := os.Stat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0700 {
+			t.Errorf("the directory has mode %v, want 0700", perm)
+		}
+	})
+
+	t.Run("symlink", func(t *testing.T) {
+		parent := t.TempDir()
+		target := filepath.Join(parent, "target")
+		if err := os.Mkdir(target, 0700); err != nil {
+			t.Fatal(err)
+		}
+		dir := filepath.Join(parent, "ai")
+		if err := os.Symlink(target, dir); err != nil {
+			t.Skipf("can't create symlinks: %v", err)
+		}
+		if err := ensurePrivate(dir); err == nil {
+			t.Error("ensurePrivate accepted a symlink")
+		}
+	})
+
+	t.Run("owned by another user", func(t *testing.T) {
+		if os.Getuid() != 0 {
+			t.Skip("only root can give a directory away")
+		}
+		dir := filepath.Join(t.TempDir(), "ai")
+		if err := os.Mkdir(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chown(dir, 65534, 65534); err != nil {
+			t.Skipf("can't change the owner: %v", err)
+		}
+		if err := ensurePrivate(dir); err == nil {
+			t.Error("ensurePrivate accepted a directory owned by another user")
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "ai")
+		if err := os.WriteFile(dir, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := ensurePrivate(dir); err == nil {
+			t.Error("ensurePrivate accepted a file")
+		}
+	})
+}
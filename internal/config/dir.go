@@ -0,0 +1,69 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// DirConfigFile is the per-directory configuration file, read from the current directory
+const DirConfigFile = ".ai.json"
+
+// DirConfig holds the settings of a single directory from its .ai.json
+type DirConfig struct {
+	// Model is the model ID used in this directory, unless --model or AI_MODEL_ID is given
+	Model string `json:"model,omitempty"`
+}
+
+// LoadDirConfig loads the configuration of dir
+// A missing config file is not an error, an empty configuration is returned instead
+func LoadDirConfig(dir string) (*DirConfig, error) {
+	configPath := filepath.Join(dir, DirConfigFile)
+
+	configData, err := os.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		return &DirConfig{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", configPath, err)
+	}
+
+	var config DirConfig
+	if err := json.Unmarshal(configData, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", configPath, err)
+	}
+
+	return &config, nil
+}
+
+// RememberModel stores model as the model of dir in its .ai.json, keeping the other settings
+func RememberModel(dir, model string) error {
+	configPath := filepath.Join(dir, DirConfigFile)
+
+	settings := map[string]json.RawMessage{}
+	configData, err := os.ReadFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", configPath, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(configData, &settings); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", configPath, err)
+		}
+	}
+
+	settings["model"], err = json.Marshal(model)
+	if err != nil {
+		return fmt.Errorf("failed to encode model: %w", err)
+	}
+
+	configData, err = json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", configPath, err)
+	}
+
+	if err := os.WriteFile(configPath, append(configData, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", configPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Defaults of the model settings that can be set in the provider config files
+const (
+	// DefaultTemperature is the sampling temperature of command suggestions
+	DefaultTemperature = 0.5
+	// DefaultMaxTokens is the initial max_tokens of command suggestions
+	DefaultMaxTokens = 2048
+)
+
+// ModelOverrides holds model settings from environment variables, which take precedence over the provider config files
+// Empty fields aren't set in the environment
+type ModelOverrides struct {
+	ModelID       string   // --model, AI_MODEL_ID or .ai.json, see SetModel
+	Region        string   // AI_REGION
+	Profile       string   // AI_PROFILE
+	Endpoint      string   // AI_ENDPOINT
+	Temperature   *float64 // AI_TEMPERATURE
+	MaxTokens     int      // AI_MAX_TOKENS
+	ContextTokens int      // AI_CONTEXT_TOKENS
+}
+
+// flagModel and dirModel are the models set with SetModel
+var flagModel, dirModel string
+
+// SetModel sets the model given with --model, which takes precedence over AI_MODEL_ID,
+// and the model remembered for the current directory, which AI_MODEL_ID takes precedence over
+func SetModel(flag, dir string) {
+	flagModel, dirModel = flag, dir
+}
+
+// LoadModelOverrides reads the model settings from the AI_* environment variables
+func LoadModelOverrides() (ModelOverrides, error) {
+	overrides := ModelOverrides{
+		ModelID:  firstNonEmpty(flagModel, os.Getenv("AI_MODEL_ID"), dirModel),
+		Region:   os.Getenv("AI_REGION"),
+		Profile:  os.Getenv("AI_PROFILE"),
+		Endpoint: os.Getenv("AI_ENDPOINT"),
+	}
+
+	if value := os.Getenv("AI_TEMPERATURE"); value != "" {
+		temperature, err := strconv.ParseFloat(value, 64)
+		if err != nil || temperature < 0 {
+			return overrides, fmt.Errorf("invalid AI_TEMPERATURE %q, expected a number of at least 0", value)
+		}
+		overrides.Temperature = &temperature
+	}
+
+	if value := os.Getenv("AI_MAX_TOKENS"); value != "" {
+		maxTokens, err := strconv.Atoi(value)
+		if err != nil || maxTokens <= 0 {
+			return overrides, fmt.Errorf("invalid AI_MAX_TOKENS %q, expected a positive number", value)
+		}
+		overrides.MaxTokens = maxTokens
+	}
+
+	if value := os.Getenv("AI_CONTEXT_TOKENS"); value != "" {
+		contextTokens, err := strconv.Atoi(value)
+		if err != nil || contextTokens <= 0 {
+			return overrides, fmt.Errorf("invalid AI_CONTEXT_TOKENS %q, expected a positive number", value)
+		}
+		overrides.ContextTokens = contextTokens
+	}
+
+	return overrides, nil
+}
+
+// firstNonEmpty returns the first of values that isn't empty
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
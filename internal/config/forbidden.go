@@ -0,0 +1,57 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ForbiddenDir returns the entry of forbidden_dirs in ai.cfg that dir is in, or "" if it is in none of them
+// Symlinks are resolved on both sides, so a link into a forbidden directory doesn't get around it
+func (c *Config) ForbiddenDir(dir string) (string, error) {
+	if len(c.ForbiddenDirs) == 0 {
+		return "", nil
+	}
+
+	resolvedDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", dir, err)
+	}
+
+	for _, entry := range c.ForbiddenDirs {
+		forbidden, err := expandHome(entry)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(forbidden) {
+			return "", fmt.Errorf("forbidden_dirs entry %q must be an absolute path or start with ~/", entry)
+		}
+		// A directory that doesn't exist can't contain dir, but its path is still compared in case it is created later
+		if resolved, err := filepath.EvalSymlinks(forbidden); err == nil {
+			forbidden = resolved
+		}
+		if isWithin(resolvedDir, forbidden) {
+			return entry, nil
+		}
+	}
+	return "", nil
+}
+
+// isWithin reports whether path is dir or one of its subdirectories, both must be clean absolute paths
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// expandHome replaces a leading ~/ with the user's home directory
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return filepath.Clean(path), nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	return filepath.Join(homeDir, path[1:]), nil
+}
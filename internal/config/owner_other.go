@@ -0,0 +1,11 @@
+//go:build !unix
+
+package config
+
+import "os"
+
+// ownedByCurrentUser reports true, file owners are only checked on Unix
+// Elsewhere the temporary directory is private to the user already
+func ownedByCurrentUser(info os.FileInfo) bool {
+	return true
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TemperatureProfiles are the built-in named temperatures, temperature_profiles in ai.cfg can add more or change them
+var TemperatureProfiles = map[string]float64{
+	"precise":  0,
+	"balanced": 0.5,
+	"creative": 0.9,
+}
+
+// ProfileTemperature returns the temperature of a named profile, from temperature_profiles in ai.cfg or the built-in ones
+func (c *Config) ProfileTemperature(name string) (float64, error) {
+	name = strings.TrimSpace(name)
+	temperature, ok := c.TemperatureProfiles[name]
+	if !ok {
+		temperature, ok = TemperatureProfiles[name]
+	}
+	if !ok {
+		return 0, fmt.Errorf("unknown temperature profile %q, expected one of %s", name, strings.Join(c.ProfileNames(), ", "))
+	}
+	if temperature < 0 || temperature > 2 {
+		return 0, fmt.Errorf("invalid temperature %g of profile %q, expected a number between 0 and 2", temperature, name)
+	}
+	return temperature, nil
+}
+
+// ProfileNames returns the names of the built-in and configured temperature profiles, sorted
+func (c *Config) ProfileNames() []string {
+	var names []string
+	for name := range TemperatureProfiles {
+		names = append(names, name)
+	}
+	for name := range c.TemperatureProfiles {
+		if _, ok := TemperatureProfiles[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
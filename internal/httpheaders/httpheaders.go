@@ -0,0 +1,59 @@
+package httpheaders
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// sensitiveWords mark header names whose values are secrets, such as Authorization or X-Gateway-Token
+var sensitiveWords = []string{"auth", "key", "token", "secret", "password", "cookie", "session", "signature", "credential"}
+
+// Validate checks that the custom headers don't replace one of the protected headers a client sets itself,
+// such as its API key or Content-Type, unless override is set
+func Validate(headers map[string]string, override bool, protected ...string) error {
+	if override {
+		return nil
+	}
+	for name := range headers {
+		for _, p := range protected {
+			if http.CanonicalHeaderKey(name) == http.CanonicalHeaderKey(p) {
+				return fmt.Errorf("header %s is set by ai itself, set override_headers to replace it", name)
+			}
+		}
+	}
+	return nil
+}
+
+// Apply sets the custom headers on a request's headers
+func Apply(header http.Header, headers map[string]string) {
+	for name, value := range headers {
+		header.Set(name, value)
+	}
+}
+
+// Redacted lists the custom headers as "Name: value" sorted by name, for display
+// The values of headers that look like they hold secrets are masked
+func Redacted(headers map[string]string) []string {
+	var lines []string
+	for name, value := range headers {
+		if IsSensitive(name) {
+			value = "****"
+		}
+		lines = append(lines, http.CanonicalHeaderKey(name)+": "+value)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// IsSensitive reports whether a header's value is likely a secret
+func IsSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range sensitiveWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package logger
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// historyBytes is the amount of recent log content kept in memory, larger requests are read from the log file
+const historyBytes = 64 * 1024
+
+// HistoryFilter selects the log entries included in the history
+type HistoryFilter string
+
+const (
+	// HistoryCommands includes the commands only
+	HistoryCommands HistoryFilter = "commands"
+	// HistoryCommandsAndOutput includes the commands, their reasons and their output
+	HistoryCommandsAndOutput HistoryFilter = "commands+output"
+	// HistoryAll includes everything in the log, such as info and error messages
+	HistoryAll HistoryFilter = "all"
+)
+
+// ParseHistoryFilter validates a history filter name, an empty name selects HistoryCommandsAndOutput
+func ParseHistoryFilter(name string) (HistoryFilter, error) {
+	switch filter := HistoryFilter(name); filter {
+	case "":
+		return HistoryCommandsAndOutput, nil
+	case HistoryCommands, HistoryCommandsAndOutput, HistoryAll:
+		return filter, nil
+	default:
+		return "", fmt.Errorf("unknown history filter %q, expected %s, %s or %s", name, HistoryCommands, HistoryCommandsAndOutput, HistoryAll)
+	}
+}
+
+// entryPattern matches the timestamp and kind prefix of a log entry, e.g. "[2006-01-02 15:04:05] Command: "
+var entryPattern = regexp.MustCompile(`^\[\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\] (\w+): `)
+
+// historyRing is a ring buffer holding the most recent writes to the log file
+type historyRing struct {
+	buf    []byte
+	start  int  // Index of the oldest byte
+	length int  // Number of bytes in use
+	cut    bool // Whether older content was dropped
+}
+
+// newHistoryRing creates a ring seeded with the tail of the log file
+// cut tells whether the seed starts in the middle of the file
+func newHistoryRing(seed string, cut bool) *historyRing {
+	r := &historyRing{buf: make([]byte, historyBytes), cut: cut}
+	r.Write([]byte(seed))
+	return r
+}
+
+// Write appends p, overwriting the oldest content when the ring is full
+func (r *historyRing) Write(p []byte) (int, error) {
+	n := len(p)
+	if n >= len(r.buf) {
+		if n > len(r.buf) || r.length > 0 {
+			r.cut = true
+		}
+		copy(r.buf, p[n-len(r.buf):])
+		r.start, r.length = 0, len(r.buf)
+		return n, nil
+	}
+
+	end := (r.start + r.length) % len(r.buf)
+	copied := copy(r.buf[end:], p)
+	copy(r.buf, p[copied:])
+
+	r.length += n
+	if r.length > len(r.buf) {
+		r.start = (r.start + r.length - len(r.buf)) % len(r.buf)
+		r.length = len(r.buf)
+		r.cut = true
+	}
+	return n, nil
+}
+
+// tail returns the content of the ring and whether older content was dropped
+func (r *historyRing) tail() (string, bool) {
+	if r.start+r.length <= len(r.buf) {
+		return string(r.buf[r.start : r.start+r.length]), r.cut
+	}
+	return string(r.buf[r.start:]) + string(r.buf[:r.start+r.length-len(r.buf)]), r.cut
+}
+
+// filterHistory keeps the lines of the log entries selected by filter
+// Lines without a prefix are the output of the previous command, or the continuation of a multi-line message
+func filterHistory(content string, filter HistoryFilter) string {
+	if filter == HistoryAll || content == "" {
+		return content
+	}
+
+	trailingNewline := strings.HasSuffix(content, "\n")
+	var kept []string
+	// entry is the kind of the last prefixed line, unknown at the start of a partial history
+	entry := ""
+	for _, line := range strings.Split(strings.TrimSuffix(content, "\n"), "\n") {
+		kind := entry
+		if match := entryPattern.FindStringSubmatch(line); match != nil {
+			kind, entry = match[1], match[1]
+		} else if entry == "Command" || entry == "Reason" {
+			kind = "Output"
+		}
+
+		if kind == "Command" || (filter == HistoryCommandsAndOutput && (kind == "Reason" || kind == "Output")) {
+			kept = append(kept, line)
+		}
+	}
+
+	if len(kept) == 0 {
+		return ""
+	}
+	filtered := strings.Join(kept, "\n")
+	if trailingNewline {
+		filtered += "\n"
+	}
+	return filtered
+}
+
+// trimHistory filters content and limits it to the last maxBytes bytes and maxLines lines
+// cut tells whether content already starts in the middle of the history
+func trimHistory(content string, cut bool, filter HistoryFilter, maxLines, maxBytes int) string {
+	// If we started reading in the middle of a line, remove the partial line
+	if cut {
+		content = dropPartialLine(content)
+	}
+	content = untangle(content)
+
+	// Filter before limiting, so the limits only apply to what is sent
+	content = filterHistory(content, filter)
+	if len(content) > maxBytes {
+		content = dropPartialLine(content[len(content)-maxBytes:])
+	}
+
+	// Limit the number of lines
+	lines := strings.Split(content, "\n")
+	if len(lines) > maxLines {
+		lines = lines[len(lines)-maxLines:]
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// dropPartialLine removes the content up to the first newline, if there is one
+func dropPartialLine(content string) string {
+	firstNewlineIndex := strings.Index(content, "\n")
+	if firstNewlineIndex >= 0 {
+		return content[firstNewlineIndex+1:]
+	}
+	return content
+}
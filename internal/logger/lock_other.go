@@ -0,0 +1,16 @@
+//go:build !unix
+
+package logger
+
+import "os"
+
+// lockFile does nothing, advisory locks are only supported on Unix
+// The line tags still keep concurrent processes' entries apart when the log is read
+func lockFile(file *os.File, exclusive bool) error {
+	return nil
+}
+
+// unlockFile does nothing, see lockFile
+func unlockFile(file *os.File) error {
+	return nil
+}
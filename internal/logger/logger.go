@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -8,44 +9,57 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/nir/ai.go/internal/config"
+	"github.com/nir/ai.go/internal/theme"
 )
 
-// ANSI color codes
 const (
-	colorRed    = "\033[31m"
-	colorGreen  = "\033[32m"
-	colorYellow = "\033[33m"
-	colorBlue   = "\033[34m"
-	colorPurple = "\033[35m"
-	colorReset  = "\033[0m"
-
-	// Maximum history length in bytes to return (approximately 5KB)
-	maxHistoryBytes = 5 * 1024
-	// Maximum number of lines to return
-	maxHistoryLines = 50
+	// DefaultHistoryBytes is the default maximum history length in bytes to return (approximately 5KB)
+	DefaultHistoryBytes = 5 * 1024
+	// DefaultHistoryLines is the default maximum number of lines to return
+	DefaultHistoryLines = 50
+)
+
+// Level is the minimum severity of messages shown on the console
+type Level int
+
+const (
+	// LevelInfo shows info and error messages
+	LevelInfo Level = iota
+	// LevelError shows error messages only
+	LevelError
 )
 
 // Logger handles logging operations
 type Logger struct {
-	logFile    *os.File
-	fileWriter io.Writer
-	console    io.Writer
+	logFile *os.File
+	// fileWriter writes to the log file through tagger, which also feeds history once it has been read
+	fileWriter   io.Writer
+	tagger       *tagWriter
+	console      io.Writer
+	consoleLevel Level
+	// palette colors the console messages
+	palette    theme.Palette
 	logHistory bool
-	mutex      sync.Mutex // Protect concurrent writes
-	logPath    string     // Path to the log file
+	// historyFilter selects the entries returned by GetRecentHistoryN
+	historyFilter HistoryFilter
+	mutex         sync.Mutex // Protect concurrent writes
+	logPath       string     // Path to the log file
+	// history holds the most recent log writes once the history has been read from the file
+	history *historyRing
+	// timestampOutput prefixes each line of streamed output in the file with the time it was logged
+	timestampOutput bool
+	// outputLineStart tells whether the next streamed output starts a new line, as output may arrive in partial lines
+	outputLineStart bool
 }
 
 // New creates a new logger
 func New() (*Logger, error) {
 	// Ensure the log directory exists
-	homeDir, err := os.UserHomeDir()
+	logDir, err := config.Dir()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user home directory: %w", err)
-	}
-
-	logDir := filepath.Join(homeDir, ".ai")
-	if err := os.MkdirAll(logDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create log directory: %w", err)
+		return nil, err
 	}
 
 	// Set the log file path
@@ -61,16 +75,61 @@ func New() (*Logger, error) {
 		return nil, fmt.Errorf("failed to open log file: %w", err)
 	}
 
+	// The default theme, unless NO_COLOR is set, until SetPalette is called
+	palette, _ := theme.Select("")
+
+	// Several ai processes may write to the log at the same time, so each write is locked and each line tagged with the process
+	tagger := &tagWriter{w: lockedWriter{logFile}, tag: newTag()}
+
 	return &Logger{
-		logFile:    logFile,
-		fileWriter: logFile,
-		console:    os.Stdout,
-		logHistory: true,
-		mutex:      sync.Mutex{},
-		logPath:    logPath,
+		logFile:         logFile,
+		fileWriter:      tagger,
+		tagger:          tagger,
+		console:         os.Stdout,
+		consoleLevel:    LevelInfo,
+		palette:         palette,
+		logHistory:      true,
+		historyFilter:   HistoryCommandsAndOutput,
+		mutex:           sync.Mutex{},
+		logPath:         logPath,
+		outputLineStart: true,
 	}, nil
 }
 
+// SetConsoleLevel sets the minimum level of messages shown on the console
+// Everything is still written to the log file
+func (l *Logger) SetConsoleLevel(level Level) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.consoleLevel = level
+}
+
+// SetPalette sets the colors of the console messages
+func (l *Logger) SetPalette(palette theme.Palette) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.palette = palette
+}
+
+// SetHistoryFilter selects the log entries included in the history
+func (l *Logger) SetHistoryFilter(filter HistoryFilter) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.historyFilter = filter
+}
+
+// SetTimestampOutput sets whether each line of streamed output is prefixed with [HH:MM:SS] in the log file
+// It is off by default, so the output is logged as it was printed. The console is never affected.
+func (l *Logger) SetTimestampOutput(enabled bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.timestampOutput = enabled
+}
+
 // LogCommand logs a command with a timestamp
 func (l *Logger) LogCommand(cmd string) {
 	l.mutex.Lock()
@@ -80,9 +139,28 @@ func (l *Logger) LogCommand(cmd string) {
 
 	// Log to file without colors
 	fmt.Fprintf(l.fileWriter, "\n[%s] Command: %s\n", timestamp, cmd)
+	// The command's output starts on a new line, even if the previous output ended without one
+	l.outputLineStart = true
 
 	// Log to console with colors
-	//fmt.Fprintf(l.console, "\n[%s] Command: %s%s%s\n", timestamp, colorRed, cmd, colorReset)
+	//fmt.Fprintf(l.console, "\n[%s] Command: %s%s%s\n", timestamp, l.palette.Command, cmd, l.palette.Reset)
+}
+
+// LogCommandWithReason logs a command with a timestamp and the reason it was run
+func (l *Logger) LogCommandWithReason(cmd, reason string) {
+	if reason == "" {
+		l.LogCommand(cmd)
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	timestamp := time.Now().Format("2006-01-02 15:04:05")
+
+	// Log to file only, the reason is already shown on the console with the suggestion
+	fmt.Fprintf(l.fileWriter, "\n[%s] Command: %s\n[%s] Reason: %s\n", timestamp, cmd, timestamp, reason)
+	l.outputLineStart = true
 }
 
 // LogOutput logs command output
@@ -103,10 +181,35 @@ func (l *Logger) LogStreamOutput(line string) {
 
 	// Write directly to the log file only to avoid duplicate output on console
 	if l.logHistory && l.logFile != nil {
+		if l.timestampOutput {
+			line = l.timestampLines(line)
+		}
 		fmt.Fprint(l.fileWriter, line)
 	}
 }
 
+// timestampLines prefixes each line starting in output with the current time
+// A line continued from the previous output isn't prefixed again
+func (l *Logger) timestampLines(output string) string {
+	if output == "" {
+		return output
+	}
+	prefix := time.Now().Format("[15:04:05] ")
+
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(output, "\n") {
+		if line == "" {
+			continue
+		}
+		if l.outputLineStart {
+			b.WriteString(prefix)
+		}
+		b.WriteString(line)
+		l.outputLineStart = strings.HasSuffix(line, "\n")
+	}
+	return b.String()
+}
+
 // LogInfo logs information messages
 func (l *Logger) LogInfo(message string) {
 	l.mutex.Lock()
@@ -117,8 +220,23 @@ func (l *Logger) LogInfo(message string) {
 	// Log to file without colors
 	fmt.Fprintf(l.fileWriter, "[%s] Info: %s\n", timestamp, message)
 
+	if l.consoleLevel > LevelInfo {
+		return
+	}
+
 	// Log to console with colors
-	fmt.Fprintf(l.console, "[%s] Info: %s%s%s\n", timestamp, colorBlue, message, colorReset)
+	fmt.Fprintf(l.console, "[%s] Info: %s%s%s\n", timestamp, l.palette.Info, message, l.palette.Reset)
+}
+
+// LogThinking logs the model's reasoning to the log file only
+func (l *Logger) LogThinking(thinking string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	timestamp := time.Now().Format("2006-01-02 15:04:05")
+
+	// Log to file only, the reasoning is too verbose for the console
+	fmt.Fprintf(l.fileWriter, "[%s] Thinking: %s\n", timestamp, strings.TrimSpace(thinking))
 }
 
 // LogError logs error messages
@@ -132,32 +250,73 @@ func (l *Logger) LogError(err error) {
 	fmt.Fprintf(l.fileWriter, "[%s] Error: %s\n", timestamp, err)
 
 	// Log to console with colors
-	fmt.Fprintf(l.console, "[%s] Error: %s%s%s\n", timestamp, colorYellow, err, colorReset)
+	fmt.Fprintf(l.console, "[%s] Error: %s%s%s\n", timestamp, l.palette.Warning, err, l.palette.Reset)
 }
 
-// GetRecentHistory retrieves recent command history from the log file
+// GetRecentHistory retrieves recent command history
 // Returns the history as a string with the most recent commands and their outputs
 func (l *Logger) GetRecentHistory() (string, error) {
+	return l.GetRecentHistoryN(DefaultHistoryLines, DefaultHistoryBytes)
+}
+
+// GetRecentHistoryN retrieves at most maxLines lines and maxBytes bytes of recent history, selected by the history filter
+// The first call reads the log file, later ones are served from memory when maxBytes fits in it
+func (l *Logger) GetRecentHistoryN(maxLines, maxBytes int) (string, error) {
 	// We need to read the file, so make sure we're not writing to it at the same time
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
+	if l.history != nil && maxBytes <= historyBytes {
+		content, cut := l.history.tail()
+		return trimHistory(content, cut, l.historyFilter, maxLines, maxBytes), nil
+	}
+
+	readBytes := maxBytes
+	if readBytes < historyBytes {
+		readBytes = historyBytes
+	}
+	content, cut, err := l.readTail(readBytes)
+	if err != nil {
+		return "", err
+	}
+
+	// Keep further writes in memory so the next calls don't have to read the file again
+	if l.history == nil {
+		seed := content
+		if len(seed) > historyBytes {
+			seed, cut = seed[len(seed)-historyBytes:], true
+		}
+		l.history = newHistoryRing(seed, cut)
+		l.tagger.w = io.MultiWriter(lockedWriter{l.logFile}, l.history)
+	}
+
+	return trimHistory(content, cut, l.historyFilter, maxLines, maxBytes), nil
+}
+
+// readTail reads at most maxBytes bytes from the end of the log file
+// It also returns whether the content starts in the middle of the file
+func (l *Logger) readTail(maxBytes int) (string, bool, error) {
 	// Open the log file for reading (separate from the writing file handle)
 	file, err := os.Open(l.logPath)
 	if err != nil {
-		return "", fmt.Errorf("failed to open log file for reading: %w", err)
+		return "", false, fmt.Errorf("failed to open log file for reading: %w", err)
 	}
 	defer file.Close()
 
+	// Wait for writes of other processes to finish, so the tail doesn't end in the middle of one
+	if err := lockFile(file, false); err == nil {
+		defer unlockFile(file)
+	}
+
 	// Get the file size
 	fileInfo, err := file.Stat()
 	if err != nil {
-		return "", fmt.Errorf("failed to get log file info: %w", err)
+		return "", false, fmt.Errorf("failed to get log file info: %w", err)
 	}
 
 	// Determine how many bytes to read from the end
 	fileSize := fileInfo.Size()
-	readSize := maxHistoryBytes
+	readSize := maxBytes
 	if fileSize < int64(readSize) {
 		readSize = int(fileSize)
 	}
@@ -169,34 +328,17 @@ func (l *Logger) GetRecentHistory() (string, error) {
 	}
 	_, err = file.Seek(startPos, 0)
 	if err != nil {
-		return "", fmt.Errorf("failed to seek in log file: %w", err)
+		return "", false, fmt.Errorf("failed to seek in log file: %w", err)
 	}
 
 	// Read the last chunk of the file
 	buffer := make([]byte, readSize)
 	_, err = file.Read(buffer)
 	if err != nil && err != io.EOF {
-		return "", fmt.Errorf("failed to read log file: %w", err)
-	}
-
-	// Convert to string
-	content := string(buffer)
-
-	// If we started reading in the middle of a line, remove the partial line
-	if startPos > 0 {
-		firstNewlineIndex := strings.Index(content, "\n")
-		if firstNewlineIndex >= 0 {
-			content = content[firstNewlineIndex+1:]
-		}
-	}
-
-	// Limit the number of lines
-	lines := strings.Split(content, "\n")
-	if len(lines) > maxHistoryLines {
-		lines = lines[len(lines)-maxHistoryLines:]
+		return "", false, fmt.Errorf("failed to read log file: %w", err)
 	}
 
-	return strings.Join(lines, "\n"), nil
+	return string(buffer), startPos > 0, nil
 }
 
 // Close closes the logger
@@ -204,7 +346,7 @@ func (l *Logger) Close() error {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 	if l.logFile != nil {
-		return l.logFile.Close()
+		return errors.Join(l.tagger.Flush(), l.logFile.Close())
 	}
 	return nil
 }
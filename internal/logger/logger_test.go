@@ -0,0 +1,94 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestConcurrentWriters writes to the log from several loggers at once, each with its own file handle and tag
+// like separate processes, and checks that every line in the file is whole, tagged, and in its writer's order
+func TestConcurrentWriters(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	const writers = 8
+	const lines = 200
+	payload := strings.Repeat("x", 300)
+
+	loggers := make([]*Logger, writers)
+	for i := range loggers {
+		l, err := New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		loggers[i] = l
+	}
+
+	var wg sync.WaitGroup
+	for w, l := range loggers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range lines {
+				// Output arrives in partial lines, the line must still reach the file whole
+				l.LogStreamOutput(fmt.Sprintf("writer %d line %d ", w, n))
+				l.LogStreamOutput(payload + "\n")
+			}
+			l.LogOutput(fmt.Sprintf("writer %d done", w))
+			if err := l.Close(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	content, err := os.ReadFile(filepath.Join(home, ".ai", "action.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	linePattern := regexp.MustCompile(`^(\{[0-9a-f]{8}\} )writer (\d+) (?:line (\d+) ` + payload + `|done)$`)
+	tags := make(map[string]string)
+	next := make(map[string]int)
+	for _, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+		match := linePattern.FindStringSubmatch(line)
+		if match == nil {
+			t.Fatalf("torn or interleaved line: %.120q", line)
+		}
+		tag, writer := match[1], match[2]
+		if tags[writer] == "" {
+			tags[writer] = tag
+		} else if tags[writer] != tag {
+			t.Errorf("writer %s wrote with tags %s and %s", writer, tags[writer], tag)
+		}
+		if match[3] == "" {
+			if next[writer] != lines {
+				t.Errorf("writer %s is done after %d lines, want %d", writer, next[writer], lines)
+			}
+			continue
+		}
+		if n := match[3]; n != fmt.Sprint(next[writer]) {
+			t.Errorf("writer %s wrote line %s, want line %d", writer, n, next[writer])
+		}
+		next[writer]++
+	}
+	if len(tags) != writers {
+		t.Errorf("found %d writers in the log, want %d", len(tags), writers)
+	}
+
+	// Reading the log back groups each writer's lines together
+	untangled := strings.Split(strings.TrimSuffix(untangle(string(content)), "\n"), "\n")
+	for i := 0; i < len(untangled); i += lines + 1 {
+		writer := strings.Fields(untangled[i])[1]
+		for _, line := range untangled[i : i+lines+1] {
+			if !strings.HasPrefix(line, "writer "+writer+" ") {
+				t.Fatalf("untangled log mixes writer %s with %.40q", writer, line)
+			}
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nir/ai.go/internal/command"
+	"github.com/nir/ai.go/internal/session"
+)
+
+// recoverBytes is the amount of the log's tail searched for the last task
+const recoverBytes = 1024 * 1024
+
+// RecoveredTask is the last task found in the log, see RecoverTask
+type RecoveredTask struct {
+	// Query is the request the task was started with
+	Query string
+	// Messages are the queries and suggestions exchanged before the task stopped
+	Messages []session.Message
+	// Next is the query that continues the task where it stopped
+	Next string
+}
+
+// logEntry is a log entry with the lines following it that don't start an entry, such as command output
+type logEntry struct {
+	kind  string
+	text  string
+	lines []string
+}
+
+// exchange is a query and the suggestion it got
+type exchange struct {
+	query      string
+	suggestion *command.Command
+}
+
+// RecoverTask reconstructs the conversation of the last task in the log, so it can be continued after ai was
+// interrupted. It is best effort: suggestions are rebuilt from their logged fields and the queries following a
+// command from its logged output, which is cut to its last maxOutputBytes bytes (<= 0 means no limit).
+func (l *Logger) RecoverTask(maxOutputBytes int) (*RecoveredTask, error) {
+	content, cut, err := l.readTail(recoverBytes)
+	if err != nil {
+		return nil, err
+	}
+	if cut {
+		content = dropPartialLine(content)
+	}
+	content = untangle(content)
+
+	// The task starts at the last query, everything before belongs to earlier runs
+	entries := parseEntries(content)
+	start := -1
+	for i, entry := range entries {
+		if entry.kind == "Info" && (strings.HasPrefix(entry.text, "User Query: ") || strings.HasPrefix(entry.text, "Ask Mode: ")) {
+			start = i
+		}
+	}
+	if start < 0 {
+		return nil, errors.New("no task found in the log")
+	}
+
+	_, query, _ := strings.Cut(entries[start].text, ": ")
+	task := &RecoveredTask{Query: strings.TrimSpace(strings.Join(append([]string{query}, entries[start].lines...), "\n"))}
+	var exchanges []exchange
+	var current *command.Command
+	// pending is the query waiting for a suggestion, ran and output describe the command run after the last suggestion
+	pending := task.Query
+	ran := false
+	var output []string
+
+	for _, entry := range entries[start+1:] {
+		switch {
+		case entry.kind == "Info" && strings.HasPrefix(entry.text, "Suggested Command: "):
+			if pending == "" {
+				pending = followUp(current.Command, ran, output, task.Query, maxOutputBytes)
+			}
+			current = &command.Command{Command: strings.TrimPrefix(entry.text, "Suggested Command: ")}
+			exchanges = append(exchanges, exchange{query: pending, suggestion: current})
+			pending, ran, output = "", false, nil
+		case entry.kind == "Info" && current != nil && !ran:
+			// The fields logged right after the suggestion
+			name, value, _ := strings.Cut(entry.text, ": ")
+			switch name {
+			case "Reason":
+				current.Reason = value
+			case "Safe":
+				current.Safe, _ = strconv.ParseBool(value)
+			case "Is Final":
+				current.IsFinal, _ = strconv.ParseBool(value)
+			case "Needs Output":
+				current.NeedsOutput, _ = strconv.ParseBool(value)
+			case "Clarification":
+				current.Clarification = value
+			case "Clarification answer":
+				pending = fmt.Sprintf("You asked: %s\nMy answer: %s\nPlease continue with my original request: %s",
+					current.Clarification, value, task.Query)
+			case "Revision requested":
+				pending = fmt.Sprintf("I didn't run the command '%s'. My feedback: %s\nPlease suggest a revised command for my original request: %s",
+					current.Command, value, task.Query)
+			}
+		case entry.kind == "Command" && current != nil:
+			ran = true
+			output = entry.lines
+		case ran && entry.kind != "Error":
+			// Errors repeat the output of failed commands
+			output = append(output, entry.lines...)
+		}
+	}
+
+	for _, exchange := range exchanges {
+		response, err := json.Marshal(exchange.suggestion)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode suggestion: %w", err)
+		}
+		task.Messages = append(task.Messages,
+			session.Message{Role: "user", Content: exchange.query},
+			session.Message{Role: "assistant", Content: string(response)})
+	}
+
+	switch {
+	case pending != "":
+		task.Next = pending
+	case ran:
+		task.Next = "ai was interrupted while the last command ran, so check whether it finished. " + followUp(current.Command, ran, output, task.Query, maxOutputBytes)
+	default:
+		task.Next = fmt.Sprintf("ai was interrupted before running the command '%s'. Please provide the next command to continue with my original request: %s",
+			current.Command, task.Query)
+	}
+	return task, nil
+}
+
+// followUp rebuilds the query sent after a suggestion, with the output the command logged if it was run
+func followUp(cmd string, ran bool, output []string, query string, maxOutputBytes int) string {
+	if !ran {
+		return fmt.Sprintf("I didn't run the command '%s'. Please suggest another command for my original request: %s", cmd, query)
+	}
+
+	text := strings.TrimSpace(strings.Join(output, "\n"))
+	if text == "" {
+		return fmt.Sprintf("I ran '%s'. What's the next command to continue with my original request: %s", cmd, query)
+	}
+	if maxOutputBytes > 0 && len(text) > maxOutputBytes {
+		text = dropPartialLine(text[len(text)-maxOutputBytes:])
+	}
+	return fmt.Sprintf("I ran the command '%s' and got the output:\n%s\nPlease provide the next command to continue with my original request: %s",
+		cmd, text, query)
+}
+
+// parseEntries splits log content into entries, lines before the first entry are dropped
+func parseEntries(content string) []logEntry {
+	var entries []logEntry
+	for _, line := range strings.Split(content, "\n") {
+		if match := entryPattern.FindStringSubmatch(line); match != nil {
+			entries = append(entries, logEntry{kind: match[1], text: line[len(match[0]):]})
+			continue
+		}
+		if len(entries) > 0 {
+			last := &entries[len(entries)-1]
+			last.lines = append(last.lines, line)
+		}
+	}
+	return entries
+}
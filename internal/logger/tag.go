@@ -0,0 +1,126 @@
+package logger
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// tagPattern matches the tag at the start of each line of the log file, e.g. "{1a2b3c4d} "
+var tagPattern = regexp.MustCompile(`^\{[0-9a-f]{8}\} `)
+
+// newTag returns a random tag identifying the lines this process writes to the log file
+func newTag() string {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		// The process ID is unique among the processes running at the same time, which is all that matters
+		return fmt.Sprintf("{%08x} ", uint32(os.Getpid()))
+	}
+	return "{" + hex.EncodeToString(buf) + "} "
+}
+
+// lockedWriter writes to the log file holding an exclusive lock, so the writes of concurrent processes don't mix
+type lockedWriter struct {
+	file *os.File
+}
+
+// Write writes p to the file in a single write while holding the lock
+func (w lockedWriter) Write(p []byte) (int, error) {
+	if err := lockFile(w.file, true); err != nil {
+		// Some file systems don't support locks, the write still goes through
+		return w.file.Write(p)
+	}
+	defer unlockFile(w.file)
+	return w.file.Write(p)
+}
+
+// maxPartialLine caps the start of a line tagWriter holds back, a longer line is broken up
+const maxPartialLine = 64 * 1024
+
+// tagWriter prefixes each line written to w with a tag, so the lines of processes writing to the log at the same time can be told apart
+// Only whole lines are written, so another process's line can't end up in the middle of one.
+type tagWriter struct {
+	w   io.Writer
+	tag string
+	// partial is the start of a line that hasn't ended yet, as output may arrive in partial lines
+	partial []byte
+}
+
+// Write writes the lines p completes with a tag at the start of each, in a single write to w
+// The rest of p is held back until its line ends, or breaks into a line of its own at maxPartialLine bytes.
+func (t *tagWriter) Write(p []byte) (int, error) {
+	t.partial = append(t.partial, p...)
+
+	var b bytes.Buffer
+	for {
+		end := bytes.IndexByte(t.partial, '\n')
+		if end < 0 {
+			break
+		}
+		b.WriteString(t.tag)
+		b.Write(t.partial[:end+1])
+		t.partial = t.partial[end+1:]
+	}
+	if len(t.partial) >= maxPartialLine {
+		b.WriteString(t.tag)
+		b.Write(t.partial)
+		b.WriteString("\n")
+		t.partial = t.partial[:0]
+	}
+	t.partial = append([]byte(nil), t.partial...)
+
+	if b.Len() == 0 {
+		return len(p), nil
+	}
+	if _, err := t.w.Write(b.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes the line held back by Write, ending it so the next process's line doesn't run into it
+func (t *tagWriter) Flush() error {
+	if len(t.partial) == 0 {
+		return nil
+	}
+	_, err := t.w.Write([]byte(t.tag + string(t.partial) + "\n"))
+	t.partial = nil
+	return err
+}
+
+// untangle removes the tags from log content and groups its lines by tag, in the order the tags first appear,
+// so the entries of processes that wrote to the log at the same time follow each other instead of being interleaved.
+// Untagged lines, such as those written by older versions, form a group of their own.
+func untangle(content string) string {
+	var order []string
+	groups := make(map[string]*strings.Builder)
+	for _, line := range strings.SplitAfter(content, "\n") {
+		if line == "" {
+			continue
+		}
+		tag := tagPattern.FindString(line)
+		group, ok := groups[tag]
+		if !ok {
+			group = &strings.Builder{}
+			groups[tag] = group
+			order = append(order, tag)
+		}
+		group.WriteString(line[len(tag):])
+	}
+
+	var b strings.Builder
+	for i, tag := range order {
+		text := groups[tag].String()
+		b.WriteString(text)
+		// A process may be in the middle of a line, which must not run into the next group
+		if i < len(order)-1 && !strings.HasSuffix(text, "\n") {
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
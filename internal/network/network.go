@@ -0,0 +1,72 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// ErrNetwork is matched by errors.Is when a provider's endpoint couldn't be reached,
+// because its host name didn't resolve or the connection failed
+var ErrNetwork = errors.New("the endpoint couldn't be reached")
+
+// Error is a failed connection to a provider's endpoint
+type Error struct {
+	// Endpoint names what couldn't be reached, e.g. the configured base URL
+	Endpoint string
+	// Hint says what to check, e.g. which setting the endpoint comes from
+	Hint string
+	Err  error
+}
+
+// Error names the endpoint and the cause, followed by the hint
+func (e *Error) Error() string {
+	message := fmt.Sprintf("can't connect to %s: %s", e.Endpoint, cause(e.Err))
+	if e.Hint != "" {
+		message += ", " + e.Hint
+	}
+	return message
+}
+
+// Unwrap returns ErrNetwork and the underlying error
+func (e *Error) Unwrap() []error {
+	return []error{ErrNetwork, e.Err}
+}
+
+// Wrap returns an *Error for err if it is a DNS or dial failure, or err itself otherwise
+func Wrap(err error, endpoint, hint string) error {
+	if !isConnectionError(err) {
+		return err
+	}
+	return &Error{Endpoint: endpoint, Hint: hint, Err: err}
+}
+
+// isConnectionError reports whether err comes from resolving the host name or connecting to it
+// Failures once connected, such as a reset connection or a timeout waiting for the response, aren't
+func isConnectionError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// cause describes a connection error without the request, e.g. "connection refused" rather than
+// `Post "http://localhost:8080/v1/chat/completions": dial tcp 127.0.0.1:8080: connect: connection refused`
+func cause(err error) string {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.Error()
+	}
+	var syscallErr *os.SyscallError
+	if errors.As(err, &syscallErr) {
+		return syscallErr.Err.Error()
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return opErr.Err.Error()
+	}
+	return err.Error()
+}
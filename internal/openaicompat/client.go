@@ -0,0 +1,521 @@
+package openaicompat
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nir/ai.go/internal/clientopts"
+	"github.com/nir/ai.go/internal/command"
+	"github.com/nir/ai.go/internal/config"
+	"github.com/nir/ai.go/internal/httpheaders"
+	"github.com/nir/ai.go/internal/network"
+	"github.com/nir/ai.go/internal/prompt"
+	"github.com/nir/ai.go/internal/recorder"
+	"github.com/nir/ai.go/internal/session"
+)
+
+// Config files of OpenAI-compatible servers in ~/.ai, neither is created automatically
+const (
+	// ConfigFile configures any OpenAI-compatible provider, selected with AI_PROVIDER=openai-compatible
+	ConfigFile = "openaicompat.cfg"
+	// LocalConfigFile configures a server on the local machine, used whenever it exists
+	LocalConfigFile = "local.cfg"
+)
+
+// ClientConfig holds the configuration of an OpenAI-compatible server
+type ClientConfig struct {
+	// BaseURL is the server address, e.g. https://api.openai.com/v1 or http://localhost:8080 for llama-server
+	BaseURL string `json:"base_url"`
+	// ModelID selects the model, the server's default is used if empty
+	ModelID string `json:"model_id,omitempty"`
+	// APIKeyEnv names the environment variable holding the API key, e.g. OPENAI_API_KEY (optional for local servers)
+	APIKeyEnv string `json:"api_key_env,omitempty"`
+	// Temperature is the sampling temperature of requests (defaults to 0.5)
+	Temperature *float64 `json:"temperature,omitempty"`
+	// MaxTokens is the initial max_tokens of command suggestions (defaults to 2048)
+	MaxTokens int `json:"max_tokens,omitempty"`
+	// ContextTokens is the model's context window, suggestion requests that wouldn't fit are trimmed
+	// (defaults to the window of known models, trimming is disabled for unknown ones)
+	ContextTokens int `json:"context_tokens,omitempty"`
+	// Headers are added to every request, e.g. for a gateway in front of the API
+	Headers map[string]string `json:"headers,omitempty"`
+	// OverrideHeaders lets Headers replace the headers the client sets, such as Authorization
+	OverrideHeaders bool `json:"override_headers,omitempty"`
+
+	// apiKey is read from APIKeyEnv when the config is loaded
+	apiKey string
+}
+
+// protectedHeaders are set by the client itself and can only be replaced with OverrideHeaders
+var protectedHeaders = []string{"Content-Type", "Authorization"}
+
+// OpenAICompatClient handles interactions with an OpenAI-compatible chat completions API,
+// such as OpenAI, Groq, Together, OpenRouter, or local servers like llama-server, LM Studio, vLLM and LocalAI
+type OpenAICompatClient struct {
+	config *ClientConfig
+	// configFile is the name of the config file in ~/.ai, for error messages
+	configFile string
+
+	clientopts.Holder
+}
+
+// Message represents a chat message
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ChatRequest represents a chat completions request
+type ChatRequest struct {
+	Model       string    `json:"model,omitempty"`
+	Messages    []Message `json:"messages"`
+	MaxTokens   int       `json:"max_tokens"`
+	Temperature float64   `json:"temperature"`
+	Stream      bool      `json:"stream,omitempty"`
+}
+
+// ChatResponse represents a chat completions response, or a chunk of a streamed one
+type ChatResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	// Model and SystemFingerprint identify the model version that served the response, servers may leave them out
+	Model             string `json:"model"`
+	SystemFingerprint string `json:"system_fingerprint"`
+}
+
+// configPath returns the path of a config file in ~/.ai
+func configPath(name string) (string, error) {
+	aiDir, err := config.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(aiDir, name), nil
+}
+
+// Configured reports whether the config file exists in ~/.ai
+func Configured(name string) bool {
+	path, err := configPath(name)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// loadClientConfig loads the client configuration from a config file in ~/.ai
+func loadClientConfig(name string) (*ClientConfig, error) {
+	path, err := configPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	configData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var config ClientConfig
+	if err := json.Unmarshal(configData, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	if config.BaseURL == "" {
+		return nil, fmt.Errorf("base_url is missing from %s", name)
+	}
+
+	// The key itself is never stored in the config file
+	if config.APIKeyEnv != "" {
+		config.apiKey = os.Getenv(config.APIKeyEnv)
+		if config.apiKey == "" {
+			return nil, fmt.Errorf("environment variable %s named by api_key_env in %s is not set", config.APIKeyEnv, name)
+		}
+	}
+
+	if err := applyModelOverrides(&config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// applyModelOverrides applies the AI_* environment variables, which take precedence over the file, and the defaults
+func applyModelOverrides(clientConfig *ClientConfig) error {
+	overrides, err := config.LoadModelOverrides()
+	if err != nil {
+		return err
+	}
+
+	if overrides.ModelID != "" {
+		clientConfig.ModelID = overrides.ModelID
+	}
+	if overrides.Temperature != nil {
+		clientConfig.Temperature = overrides.Temperature
+	}
+	if overrides.MaxTokens > 0 {
+		clientConfig.MaxTokens = overrides.MaxTokens
+	}
+	if overrides.ContextTokens > 0 {
+		clientConfig.ContextTokens = overrides.ContextTokens
+	}
+
+	if clientConfig.Temperature == nil {
+		temperature := config.DefaultTemperature
+		clientConfig.Temperature = &temperature
+	}
+	if clientConfig.MaxTokens == 0 {
+		clientConfig.MaxTokens = config.DefaultMaxTokens
+	}
+	if clientConfig.ContextTokens == 0 {
+		clientConfig.ContextTokens = prompt.ContextTokens(clientConfig.ModelID)
+	}
+	return nil
+}
+
+// NewOpenAICompatClient creates a new client for the server configured in the given config file in ~/.ai
+func NewOpenAICompatClient(configFile string) (*OpenAICompatClient, error) {
+	clientConfig, err := loadClientConfig(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client config: %w", err)
+	}
+
+	if err := httpheaders.Validate(clientConfig.Headers, clientConfig.OverrideHeaders, protectedHeaders...); err != nil {
+		return nil, err
+	}
+
+	return &OpenAICompatClient{config: clientConfig, configFile: configFile}, nil
+}
+
+// Model returns the configured model ID, or the server address if the server picks the model
+func (c *OpenAICompatClient) Model() string {
+	if c.config.ModelID != "" {
+		return c.config.ModelID
+	}
+	return "default model at " + c.config.BaseURL
+}
+
+// Headers returns the custom headers added to every request
+func (c *OpenAICompatClient) Headers() map[string]string {
+	return c.config.Headers
+}
+
+// SystemPrompt builds the system prompt of a command suggestion request and the earlier turns sent with it,
+// trimmed to fit the model's context window as the request is
+func (c *OpenAICompatClient) SystemPrompt(turns []session.Message, userQuery, currentDir string, filesList []string, commandHistory string) (string, []session.Message) {
+	systemPrompt, turns, _ := c.fitToContext(turns, userQuery, currentDir, filesList, commandHistory, c.config.MaxTokens)
+	return systemPrompt, turns
+}
+
+// buildMessages converts the system prompt, previous conversation turns and the new query into request messages
+func buildMessages(systemPrompt string, turns []session.Message, userQuery string) []Message {
+	messages := make([]Message, 0, len(turns)+2)
+	messages = append(messages, Message{Role: "system", Content: systemPrompt})
+	for _, turn := range turns {
+		messages = append(messages, Message{Role: turn.Role, Content: turn.Content})
+	}
+
+	return append(messages, Message{Role: "user", Content: userQuery})
+}
+
+// fitToContext builds the system prompt of a suggestion request, trimming it and the earlier turns
+// to fit the model's context window with room for a response of reservedTokens
+func (c *OpenAICompatClient) fitToContext(turns []session.Message, userQuery, currentDir string, filesList []string, commandHistory string, reservedTokens int) (string, []session.Message, prompt.Trimmed) {
+	files := c.PromptFiles(filesList)
+	var trimmed prompt.Trimmed
+	if c.config.ContextTokens > 0 {
+		var parts prompt.Parts
+		parts, trimmed = prompt.FitToContext(prompt.Parts{
+			Fixed:   c.BuildSystemPrompt(currentDir, prompt.Files{}, "") + "\n" + userQuery,
+			Files:   files,
+			History: commandHistory,
+			Turns:   turns,
+		}, c.config.ContextTokens-reservedTokens)
+		files, commandHistory, turns = parts.Files, parts.History, parts.Turns
+	}
+	return c.BuildSystemPrompt(currentDir, files, commandHistory), turns, trimmed
+}
+
+// suggestionRequest builds the request for a command suggestion
+func (c *OpenAICompatClient) suggestionRequest(ctx context.Context, turns []session.Message, userQuery, currentDir string, filesList []string, commandHistory string) ChatRequest {
+	systemPrompt, turns, trimmed := c.fitToContext(turns, userQuery, currentDir, filesList, commandHistory, c.config.MaxTokens)
+	if trimmed.Significant() {
+		fmt.Fprintf(os.Stderr, "The request exceeds the model's context window of %d tokens, %s…\n", c.config.ContextTokens, trimmed)
+	}
+	return ChatRequest{
+		Model:       c.config.ModelID,
+		Messages:    buildMessages(systemPrompt, turns, userQuery),
+		MaxTokens:   c.config.MaxTokens,
+		Temperature: c.RequestTemperature(ctx, *c.config.Temperature),
+	}
+}
+
+// maxSuggestionTokens caps max_tokens when retrying a suggestion that was cut off
+const maxSuggestionTokens = 8192
+
+// GetCommandSuggestion asks the model for command suggestions
+// Smaller models often wrap the JSON object in prose, so only the first JSON object of the response is returned
+// A response cut off by max_tokens is retried with a higher limit, up to maxSuggestionTokens (or max_tokens if higher)
+func (c *OpenAICompatClient) GetCommandSuggestion(ctx context.Context, turns []session.Message, userQuery, currentDir string, filesList []string, commandHistory string) (string, error) {
+	request := c.suggestionRequest(ctx, turns, userQuery, currentDir, filesList, commandHistory)
+	tokenLimit := max(maxSuggestionTokens, request.MaxTokens)
+
+	for {
+		responseText, finishReason, err := c.sendRequest(ctx, request)
+		if err != nil {
+			return "", err
+		}
+
+		if finishReason != "length" {
+			return command.ExtractJSONObject(responseText), nil
+		}
+		if request.MaxTokens >= tokenLimit {
+			return "", fmt.Errorf("%w (max_tokens=%d)", command.ErrTruncated, request.MaxTokens)
+		}
+
+		request.MaxTokens = min(request.MaxTokens*2, tokenLimit)
+		fmt.Fprintf(os.Stderr, "Response was cut off, retrying with max_tokens=%d…\n", request.MaxTokens)
+	}
+}
+
+// StreamCommandSuggestion asks the model for command suggestions, calling onText with each chunk of text as it arrives
+// It returns the first JSON object of the response once the stream is complete
+func (c *OpenAICompatClient) StreamCommandSuggestion(ctx context.Context, turns []session.Message, userQuery, currentDir string, filesList []string, commandHistory string, onText func(text string)) (string, error) {
+	request := c.suggestionRequest(ctx, turns, userQuery, currentDir, filesList, commandHistory)
+	request.Stream = true
+
+	// The streamed text has already been shown, so a cut off response is reported rather than retried
+	responseText, finishReason, err := c.sendStreamingRequest(ctx, request, onText)
+	if err != nil {
+		return "", err
+	}
+	if finishReason == "length" {
+		return "", fmt.Errorf("%w (max_tokens=%d)", command.ErrTruncated, request.MaxTokens)
+	}
+
+	return command.ExtractJSONObject(responseText), nil
+}
+
+// Summarize asks the model for a concise summary of a command's output
+func (c *OpenAICompatClient) Summarize(ctx context.Context, output string) (string, error) {
+	return c.complete(ctx, prompt.SummarizeSystemPrompt, output)
+}
+
+// ReviewOutput asks the model whether a running command should be stopped, given its latest output
+func (c *OpenAICompatClient) ReviewOutput(ctx context.Context, request, cmd, output string) (string, error) {
+	return c.complete(ctx, prompt.ReviewOutputSystemPrompt, prompt.ReviewOutputMessage(request, cmd, output))
+}
+
+// ExplainCommand asks the model for a breakdown of what a shell command does
+func (c *OpenAICompatClient) ExplainCommand(ctx context.Context, cmd string) (string, error) {
+	return c.complete(ctx, prompt.ExplainSystemPrompt, cmd)
+}
+
+// DiagnoseError asks the model why a command failed and how to fix it, given its output and exit code
+func (c *OpenAICompatClient) DiagnoseError(ctx context.Context, cmd, output string, exitCode int) (string, error) {
+	return c.complete(ctx, prompt.DiagnoseSystemPrompt, prompt.DiagnoseMessage(cmd, output, exitCode))
+}
+
+// complete sends a single plain-text message with the given system prompt and returns the model's answer
+func (c *OpenAICompatClient) complete(ctx context.Context, systemPrompt, text string) (string, error) {
+	request := ChatRequest{
+		Model:       c.config.ModelID,
+		Messages:    buildMessages(systemPrompt, nil, text),
+		MaxTokens:   1024,
+		Temperature: c.RequestTemperature(ctx, *c.config.Temperature),
+	}
+
+	// A cut off summary or explanation is still useful, so the finish reason is ignored
+	responseText, _, err := c.sendRequest(ctx, request)
+	return responseText, err
+}
+
+// sendRequest sends the request to the server and returns the response text and finish reason
+func (c *OpenAICompatClient) sendRequest(ctx context.Context, request ChatRequest) (string, string, error) {
+	resp, err := c.post(ctx, request)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	// Read response body
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	// Check status code
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	// Parse response
+	var response ChatResponse
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return "", "", fmt.Errorf("failed to parse API response: %w", err)
+	}
+	if len(response.Choices) == 0 || response.Choices[0].Message.Content == "" {
+		return "", "", errors.New("empty response from model")
+	}
+
+	responseText := c.splitThinking(response.Choices[0].Message.Content)
+	c.HandleServedModel(response.Model, response.SystemFingerprint)
+	return responseText, response.Choices[0].FinishReason, nil
+}
+
+// sendStreamingRequest sends a streaming request to the server and reads the server-sent events
+// It returns the response text and finish reason
+func (c *OpenAICompatClient) sendStreamingRequest(ctx context.Context, request ChatRequest, onText func(text string)) (string, string, error) {
+	resp, err := c.post(ctx, request)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read response body: %w", err)
+		}
+		return "", "", fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var responseText strings.Builder
+	var finishReason, servedModel, fingerprint string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+
+		var chunk ChatResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return "", "", fmt.Errorf("failed to parse stream event: %w", err)
+		}
+		// Every chunk carries the model, the fingerprint may be left out of some
+		if chunk.Model != "" {
+			servedModel = chunk.Model
+		}
+		if chunk.SystemFingerprint != "" {
+			fingerprint = chunk.SystemFingerprint
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+
+		if text := chunk.Choices[0].Delta.Content; text != "" {
+			responseText.WriteString(text)
+			if onText != nil {
+				onText(text)
+			}
+		}
+		if chunk.Choices[0].FinishReason != "" {
+			finishReason = chunk.Choices[0].FinishReason
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", fmt.Errorf("failed to read response stream: %w", err)
+	}
+
+	if responseText.Len() == 0 {
+		return "", "", errors.New("empty response from model")
+	}
+
+	text := c.splitThinking(responseText.String())
+	c.HandleServedModel(servedModel, fingerprint)
+	return text, finishReason, nil
+}
+
+// endpoint returns the URL of an API path, base_url may be given with or without the /v1 suffix
+func (c *OpenAICompatClient) endpoint(path string) string {
+	return strings.TrimSuffix(strings.TrimRight(c.config.BaseURL, "/"), "/v1") + "/v1" + path
+}
+
+// post sends a request to the chat completions endpoint
+func (c *OpenAICompatClient) post(ctx context.Context, request ChatRequest) (*http.Response, error) {
+	requestBytes, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	return c.do(ctx, "POST", c.endpoint("/chat/completions"), strings.NewReader(string(requestBytes)))
+}
+
+// Ping checks that the server is reachable by listing its models, without generating anything
+func (c *OpenAICompatClient) Ping(ctx context.Context) error {
+	resp, err := c.do(ctx, "GET", c.endpoint("/models"), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
+
+// do sends an authenticated request to the server
+func (c *OpenAICompatClient) do(ctx context.Context, method, requestURL string, body io.Reader) (*http.Response, error) {
+	// Requests may be recorded or replayed, see AI_RECORD and AI_REPLAY
+	transport, err := recorder.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	// Local models can be slow, especially on CPU
+	httpClient := &http.Client{
+		Timeout:   time.Minute * 5,
+		Transport: transport,
+	}
+
+	// Create request
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.config.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.config.apiKey)
+	}
+	httpheaders.Apply(req.Header, c.config.Headers)
+
+	// Send request
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		hint := fmt.Sprintf("check that the server is running and base_url in ~/.ai/%s is right", c.configFile)
+		return nil, fmt.Errorf("failed to send request: %w", network.Wrap(err, c.config.BaseURL, hint))
+	}
+	return resp, nil
+}
+
+// splitThinking hands the reasoning of models that think out loud in <think> tags to the thinking handler
+// and returns the rest of the text
+func (c *OpenAICompatClient) splitThinking(text string) string {
+	start := strings.Index(text, "<think>")
+	end := strings.Index(text, "</think>")
+	if start < 0 || end < start {
+		return text
+	}
+
+	thinking := text[start+len("<think>") : end]
+	c.HandleThinking(thinking)
+
+	return text[:start] + text[end+len("</think>"):]
+}
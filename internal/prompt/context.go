@@ -0,0 +1,200 @@
+package prompt
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nir/ai.go/internal/session"
+)
+
+// contextWindows maps parts of model IDs to their context window in tokens
+// The first match wins, so more specific entries come first. Model IDs are matched anywhere,
+// as Bedrock and OpenAI-compatible servers add prefixes and suffixes, e.g. us.anthropic.claude-3-7-sonnet-20250219-v1:0
+var contextWindows = []struct {
+	model  string
+	tokens int
+}{
+	{"claude", 200000},
+	{"gpt-4.1", 1047576},
+	{"gpt-4o", 128000},
+	{"gpt-4-turbo", 128000},
+	{"gpt-4-32k", 32768},
+	{"gpt-4", 8192},
+	{"gpt-3.5-turbo", 16385},
+	{"llama3.1", 131072},
+	{"llama-3.1", 131072},
+	{"llama3.2", 131072},
+	{"llama-3.2", 131072},
+	{"llama3.3", 131072},
+	{"llama-3.3", 131072},
+	{"llama3", 8192},
+	{"llama-3", 8192},
+	{"llama2", 4096},
+	{"llama-2", 4096},
+	{"mixtral", 32768},
+	{"mistral", 32768},
+	{"qwen2.5", 32768},
+	{"deepseek", 65536},
+	{"gemma2", 8192},
+	{"phi3", 4096},
+}
+
+// ContextTokens returns the context window of a model in tokens, or 0 if it is unknown
+func ContextTokens(model string) int {
+	model = strings.ToLower(model)
+	for _, window := range contextWindows {
+		if strings.Contains(model, window.model) {
+			return window.tokens
+		}
+	}
+	return 0
+}
+
+// charsPerToken is the number of characters assumed per token
+// Tokenizers average about 4 for English prose, paths and command output take fewer, so this errs on the side of trimming
+const charsPerToken = 3
+
+// messageTokens is the overhead of a message's role and delimiters
+const messageTokens = 4
+
+// EstimateTokens estimates the number of tokens text takes up, without a model specific tokenizer
+func EstimateTokens(text string) int {
+	return (len(text) + charsPerToken - 1) / charsPerToken
+}
+
+// Parts are the parts of a command suggestion request, see FitToContext
+type Parts struct {
+	// Fixed is the text that is sent whatever is trimmed, i.e. the system prompt without files and history, and the query
+	Fixed string
+	Files Files
+	// History is the recent command history, one command per line, oldest first
+	History string
+	// Turns are the earlier conversation turns, oldest first
+	Turns []session.Message
+}
+
+// Trimmed tells what FitToContext left out
+type Trimmed struct {
+	// Tree tells that the directory tree was replaced by the file list
+	Tree         bool
+	Files        int
+	HistoryLines int
+	Turns        int
+}
+
+// Significant reports whether the model loses more than old command history, which is worth warning about
+func (t Trimmed) Significant() bool {
+	return t.Tree || t.Files > 0 || t.Turns > 0
+}
+
+// String describes what was left out, e.g. "dropped 4 earlier messages and 120 files"
+func (t Trimmed) String() string {
+	var dropped []string
+	if t.Turns > 0 {
+		dropped = append(dropped, fmt.Sprintf("%d earlier messages", t.Turns))
+	}
+	if t.Tree {
+		dropped = append(dropped, "the directory tree")
+	}
+	if t.Files > 0 {
+		dropped = append(dropped, fmt.Sprintf("%d files", t.Files))
+	}
+	if t.HistoryLines > 0 {
+		dropped = append(dropped, fmt.Sprintf("%d lines of command history", t.HistoryLines))
+	}
+	if len(dropped) == 0 {
+		return "nothing dropped"
+	}
+	if len(dropped) == 1 {
+		return "dropped " + dropped[0]
+	}
+	return "dropped " + strings.Join(dropped[:len(dropped)-1], ", ") + " and " + dropped[len(dropped)-1]
+}
+
+// FitToContext trims the parts of a request so its estimated size stays within maxTokens, 0 means no limit
+// Parts are dropped from the least relevant on: the oldest command history, the oldest conversation turns
+// except the last exchange, the directory tree (falling back to the file list), the files at the end of the list
+// and finally the last exchange. If the fixed text alone doesn't fit, everything is dropped and the request is
+// left for the API to reject.
+func FitToContext(parts Parts, maxTokens int) (Parts, Trimmed) {
+	var trimmed Trimmed
+	if maxTokens <= 0 {
+		return parts, trimmed
+	}
+
+	history := strings.Split(strings.TrimSuffix(parts.History, "\n"), "\n")
+	if parts.History == "" {
+		history = nil
+	}
+	turns := parts.Turns
+	files := parts.Files
+
+	// The size of each part is kept up to date as it is trimmed, rather than estimating everything again
+	fixedTokens := EstimateTokens(parts.Fixed) + messageTokens
+	historyTokens := 0
+	for _, line := range history {
+		historyTokens += EstimateTokens(line) + 1
+	}
+	turnsTokens := 0
+	for _, turn := range turns {
+		turnsTokens += EstimateTokens(turn.Content) + messageTokens
+	}
+	treeTokens := EstimateTokens(files.Tree)
+	listTokens := 0
+	for _, file := range files.List {
+		listTokens += EstimateTokens(file) + 1
+	}
+	filesTokens := func() int {
+		if files.Tree != "" {
+			return treeTokens
+		}
+		return listTokens
+	}
+	over := func() bool {
+		return fixedTokens+historyTokens+turnsTokens+filesTokens() > maxTokens
+	}
+
+	for len(history) > 0 && over() {
+		historyTokens -= EstimateTokens(history[0]) + 1
+		history = history[1:]
+		trimmed.HistoryLines++
+	}
+
+	// Turns are dropped in user and assistant pairs, so the conversation keeps alternating
+	dropExchange := func() {
+		for i := 0; i < 2 && len(turns) > 0; i++ {
+			turnsTokens -= EstimateTokens(turns[0].Content) + messageTokens
+			turns = turns[1:]
+			trimmed.Turns++
+		}
+	}
+	for len(turns) > 2 && over() {
+		dropExchange()
+	}
+
+	if files.Tree != "" && over() {
+		files.Tree = ""
+		trimmed.Tree = true
+	}
+	for len(files.List) > 0 && over() {
+		last := files.List[len(files.List)-1]
+		listTokens -= EstimateTokens(last) + 1
+		files.List = files.List[:len(files.List)-1]
+		files.Truncated = true
+		trimmed.Files++
+	}
+
+	for len(turns) > 0 && over() {
+		dropExchange()
+	}
+
+	if trimmed.HistoryLines > 0 {
+		parts.History = ""
+		if len(history) > 0 {
+			parts.History = strings.Join(history, "\n") + "\n"
+		}
+	}
+	parts.Turns = turns
+	parts.Files = files
+	return parts, trimmed
+}
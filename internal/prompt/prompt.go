@@ -0,0 +1,189 @@
+package prompt
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nir/ai.go/internal/command"
+)
+
+// SummarizeSystemPrompt is the system prompt used when summarizing command output
+const SummarizeSystemPrompt = "You are an AI assistant summarizing the output of a shell command. " +
+	"Provide a concise plain-text summary of the output, highlighting errors, warnings and key results. " +
+	"Do not suggest commands and do not use markdown formatting."
+
+// ExplainSystemPrompt is the system prompt used when explaining a command
+const ExplainSystemPrompt = "You are an AI assistant explaining shell commands. " +
+	"Given a command, start with a one-sentence summary of what it does, then break it down part by part " +
+	"(program, subcommands, flags and arguments), one per line in the form '<part>: <explanation>'. " +
+	"Finish with any side effects or risks worth knowing before running it. Do not use markdown formatting."
+
+// DiagnoseSystemPrompt is the system prompt used when diagnosing a failed command
+const DiagnoseSystemPrompt = "You are an AI assistant diagnosing a shell command that failed. " +
+	"Given the command, its exit code and its output, explain in a few sentences why it most likely failed " +
+	"and how to fix it, naming the specific change to make. Do not use markdown formatting."
+
+// ReviewOutputSystemPrompt is the system prompt used when reviewing the output of a command while it runs
+const ReviewOutputSystemPrompt = "You are an AI assistant watching the output of a shell command while it runs. " +
+	"Given the user's request, the command and its latest output, decide whether the command should be stopped now, " +
+	"because it is failing, doing something unintended or has already produced what is needed. Let it run when in doubt. " +
+	"Respond with only a JSON object with the fields 'abort' (a boolean) and 'reason' (a brief explanation)."
+
+// SingleStepInstruction asks for the whole request in one command, as with --single-step only the first command runs
+const SingleStepInstruction = "Only one command will be run for this request and its output won't be sent back to you, " +
+	"so suggest a single command, or a chain of commands, that completes the request on its own, and set is_final to true."
+
+// ScriptInstruction tells the model that its commands are collected into a script with --script, so it won't see their output
+const ScriptInstruction = "The commands you suggest won't be run now but collected into a bash script with set -e that the user runs later, " +
+	"so you won't see their output. Suggest commands that don't depend on seeing the output of earlier ones where possible, " +
+	"and set is_final to true on the last command of the script."
+
+// StdinInstruction tells the model that the commands it suggests get the user's input on stdin, starting with preview
+func StdinInstruction(size int, preview string) string {
+	return fmt.Sprintf("The user provided %d bytes of input, which is passed on the standard input of every command you suggest. "+
+		"Suggest commands that read it from stdin (e.g. jq . or grep without a file name) rather than looking for it in a file. "+
+		"The input starts with:\n%s", size, preview)
+}
+
+// DiagnoseMessage formats the user message of a failure diagnosis
+func DiagnoseMessage(cmd, output string, exitCode int) string {
+	status := fmt.Sprintf("exited with code %d", exitCode)
+	if exitCode < 0 {
+		status = "did not exit normally"
+	}
+	return fmt.Sprintf("Command: %s\nIt %s, its output was:\n%s", cmd, status, output)
+}
+
+// ReviewOutputMessage formats the user message of an output review
+func ReviewOutputMessage(request, cmd, output string) string {
+	return fmt.Sprintf("Request: %s\nCommand: %s\nLatest output:\n%s", request, cmd, output)
+}
+
+// Files describes the files in the current directory shown to the model
+type Files struct {
+	// List holds the relative paths of the files
+	List []string
+	// Truncated tells that List doesn't contain every file
+	Truncated bool
+	// Tree is an indented directory tree shown instead of List when set
+	Tree string
+}
+
+// BuildSystemPrompt creates the system prompt for command suggestions in the given response schema version
+// The files section is omitted when there are no files, and history is only included if provided
+func BuildSystemPrompt(currentDir string, files Files, commandHistory string, schemaVersion string) string {
+	var b strings.Builder
+
+	b.WriteString("You are an AI assistant providing shell commands to execute tasks. Your job is to translate user requests into the exact commands needed.\n")
+	fmt.Fprintf(&b, "Current directory: %s\n", currentDir)
+	switch {
+	case files.Tree != "":
+		fmt.Fprintf(&b, "Directory tree:\n%s", files.Tree)
+	case len(files.List) > 0 && files.Truncated:
+		fmt.Fprintf(&b, "Files in directory (truncated to the first %d, more files exist): %v\n", len(files.List), files.List)
+	case len(files.List) > 0:
+		fmt.Fprintf(&b, "Files in directory: %v\n", files.List)
+	}
+	b.WriteString("\n")
+
+	if commandHistory != "" {
+		fmt.Fprintf(&b, "Recent command history (for context):\n%s\n\n", commandHistory)
+	}
+
+	b.WriteString("Provide the exact command or commands to run in response to the user's request. " +
+		"Format your response as JSON with these fields:\n" +
+		"- 'safe': a boolean indicating if the command is safe to run automatically\n" +
+		"- 'command': the exact command(s) to run\n" +
+		"- 'reason': a brief explanation of what the command does\n" +
+		"- 'is_final': a boolean indicating if this is the final command to complete the user's request (true) or if more commands will be needed (false)\n" +
+		"- 'needs_output': a boolean indicating if you need to see the output of this command to determine the next step\n")
+	if schemaVersion == command.SchemaV2 {
+		b.WriteString("- 'side_effects': a list of the side effects of running the command, such as files changed or deleted, services restarted or network access (an empty list if there are none)\n" +
+			"- 'clarification': only if the request is too ambiguous to answer, a question to ask the user, with 'command' left empty\n" +
+			"- 'plan': only in your first response to a request that takes several commands, a short list of the steps you intend to take, so the user can approve the approach\n" +
+			"- 'confidence': a number from 0 to 1 indicating how confident you are that the command does what the user asked\n")
+	}
+	b.WriteString("\n" +
+		"If you need more information, respond with JSON where 'needs_output' is true and the 'command' field contains the command needed to gather that information. " +
+		"The output of this command will be shown to you.\n\n" +
+		"IMPORTANT: Return ONLY the raw JSON data without any markdown formatting like ```json or ```. Just the plain JSON object.")
+
+	return b.String()
+}
+
+// Placeholders substituted in a custom system prompt
+const (
+	DirPlaceholder     = "{{dir}}"
+	FilesPlaceholder   = "{{files}}"
+	HistoryPlaceholder = "{{history}}"
+)
+
+// responseFields are the JSON fields the command parser relies on
+var responseFields = []string{"safe", "command", "reason", "is_final", "needs_output"}
+
+// RenderCustomSystemPrompt substitutes the placeholders of a custom system prompt, the rest is used verbatim
+func RenderCustomSystemPrompt(customPrompt, currentDir string, files Files, commandHistory string) string {
+	var filesText string
+	switch {
+	case files.Tree != "":
+		filesText = strings.TrimSuffix(files.Tree, "\n")
+	case files.Truncated:
+		filesText = strings.Join(files.List, "\n") + fmt.Sprintf("\n(truncated to the first %d, more files exist)", len(files.List))
+	default:
+		filesText = strings.Join(files.List, "\n")
+	}
+
+	return strings.NewReplacer(
+		DirPlaceholder, currentDir,
+		FilesPlaceholder, filesText,
+		HistoryPlaceholder, commandHistory,
+	).Replace(customPrompt)
+}
+
+// MissingResponseFields returns the JSON response fields a custom system prompt doesn't mention
+// Responses to a prompt that doesn't ask for them likely fail to parse
+func MissingResponseFields(customPrompt string) []string {
+	var missing []string
+	for _, field := range responseFields {
+		if !strings.Contains(customPrompt, field) {
+			missing = append(missing, field)
+		}
+	}
+	return missing
+}
+
+// AppendGitContext appends a summary of the git repository state to a system prompt
+func AppendGitContext(systemPrompt, gitContext string) string {
+	if gitContext == "" {
+		return systemPrompt
+	}
+
+	return systemPrompt + "\n\nGit repository state:\n" + gitContext
+}
+
+// AppendLanguage asks for the text of command suggestions in the given language, English needs no instruction
+// Commands, and the JSON field names the parser relies on, stay as they are
+func AppendLanguage(systemPrompt, language string) string {
+	if language == "" || strings.EqualFold(language, "english") || strings.EqualFold(language, "en") {
+		return systemPrompt
+	}
+
+	return systemPrompt + fmt.Sprintf("\n\nWrite the 'reason' field, and the 'clarification', 'plan' and 'side_effects' fields when present, in %s. "+
+		"Keep the 'command' field exactly as it must be run, with its program names, options, paths and quoted strings unchanged, "+
+		"and keep the JSON field names and boolean values in English.", language)
+}
+
+// AppendInstructions appends additional user instructions to a system prompt
+func AppendInstructions(systemPrompt string, instructions []string) string {
+	var additions []string
+	for _, instruction := range instructions {
+		if instruction = strings.TrimSpace(instruction); instruction != "" {
+			additions = append(additions, "- "+instruction)
+		}
+	}
+	if len(additions) == 0 {
+		return systemPrompt
+	}
+
+	return systemPrompt + "\n\nAdditional instructions from the user:\n" + strings.Join(additions, "\n")
+}
@@ -0,0 +1,45 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// New creates a limiter allowing requestsPerMinute requests, or nil if the limit is disabled (<= 0)
+func New(requestsPerMinute int) *rate.Limiter {
+	if requestsPerMinute <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Every(time.Minute/time.Duration(requestsPerMinute)), 1)
+}
+
+// Wait blocks until the limiter allows a request, printing a message if it has to wait
+// A nil limiter never blocks
+func Wait(ctx context.Context, limiter *rate.Limiter) error {
+	if limiter == nil {
+		return nil
+	}
+
+	reservation := limiter.Reserve()
+	delay := reservation.Delay()
+	if delay == 0 {
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Rate limited locally, waiting %s…\n", delay.Round(time.Second))
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		reservation.Cancel()
+		return ctx.Err()
+	}
+}
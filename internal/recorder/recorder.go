@@ -0,0 +1,335 @@
+package recorder
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/nir/ai.go/internal/httpheaders"
+)
+
+// Interaction is a recorded API request and the response it got
+// Request headers aren't recorded, as they hold the API key or the AWS signature
+type Interaction struct {
+	// Hash identifies the request, see requestKey
+	Hash    string `json:"hash"`
+	Method  string `json:"method"`
+	URL     string `json:"url"`
+	Request string `json:"request,omitempty"`
+	Status  int    `json:"status"`
+	// Header holds the response headers, except those that look like secrets such as Set-Cookie
+	Header http.Header `json:"header,omitempty"`
+	// Response is the response body, or ResponseBase64 if it isn't text, like Bedrock's binary event streams
+	Response       string `json:"response,omitempty"`
+	ResponseBase64 []byte `json:"response_base64,omitempty"`
+}
+
+// body returns the recorded response body
+func (i Interaction) body() []byte {
+	if i.ResponseBase64 != nil {
+		return i.ResponseBase64
+	}
+	return []byte(i.Response)
+}
+
+// HTTPClient sends HTTP requests, like the AWS SDK's HTTP client
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Transport is an http.RoundTripper that records API interactions to a file, or replays them from it
+type Transport struct {
+	path   string
+	replay bool
+
+	// mutex protects the fields below, requests may be sent concurrently, e.g. with --count
+	mutex        sync.Mutex
+	interactions []Interaction
+	// replayed counts the responses replayed per request key, matching requests get the recorded responses in order
+	replayed map[string]int
+}
+
+// transport is created once from the environment, all clients share it
+var transport = sync.OnceValues(newFromEnv)
+
+// FromEnv returns the transport for API requests: one recording them to the file named by AI_RECORD,
+// one replaying them from the file named by AI_REPLAY, or nil for the default transport if neither is set
+func FromEnv() (http.RoundTripper, error) {
+	t, err := transport()
+	if t == nil || err != nil {
+		// A nil *Transport in the interface wouldn't compare equal to nil
+		return nil, err
+	}
+	return t, nil
+}
+
+// WrapClient returns an HTTP client recording or replaying the requests sent with client like FromEnv's transport,
+// or client itself if neither AI_RECORD nor AI_REPLAY is set. It is meant for SDKs that bring their own HTTP client.
+func WrapClient(client HTTPClient) (HTTPClient, error) {
+	t, err := transport()
+	if t == nil || err != nil {
+		return client, err
+	}
+	return wrappedClient{transport: t, client: client}, nil
+}
+
+// wrappedClient records or replays the requests of an HTTP client
+type wrappedClient struct {
+	transport *Transport
+	client    HTTPClient
+}
+
+// Do records or replays a request
+func (c wrappedClient) Do(req *http.Request) (*http.Response, error) {
+	return c.transport.roundTrip(req, c.client.Do)
+}
+
+// newFromEnv creates the transport selected by AI_RECORD or AI_REPLAY
+func newFromEnv() (*Transport, error) {
+	recordPath, replayPath := os.Getenv("AI_RECORD"), os.Getenv("AI_REPLAY")
+	switch {
+	case recordPath != "" && replayPath != "":
+		return nil, errors.New("AI_RECORD and AI_REPLAY can't be used together")
+	case recordPath != "":
+		return NewRecorder(recordPath)
+	case replayPath != "":
+		return NewReplayer(replayPath)
+	}
+	return nil, nil
+}
+
+// NewRecorder returns a transport sending requests with http.DefaultTransport and recording them to path
+// Interactions are appended to those already in the file, so a demo can be recorded over several runs
+func NewRecorder(path string) (*Transport, error) {
+	interactions, err := load(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return &Transport{path: path, interactions: interactions}, nil
+}
+
+// NewReplayer returns a transport answering requests with the responses recorded in path, without sending them
+func NewReplayer(path string) (*Transport, error) {
+	interactions, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+	// The keys are computed again, files recorded by earlier versions hold hashes of the whole request
+	for i, interaction := range interactions {
+		interactions[i].Hash = requestKey(interaction.Method, interaction.URL, []byte(interaction.Request))
+	}
+	return &Transport{path: path, replay: true, interactions: interactions, replayed: make(map[string]int)}, nil
+}
+
+// load reads the interactions recorded in path
+func load(path string) ([]Interaction, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var interactions []Interaction
+	if err := json.Unmarshal(data, &interactions); err != nil {
+		return nil, fmt.Errorf("failed to parse recorded interactions in %s: %w", path, err)
+	}
+	return interactions, nil
+}
+
+// RoundTrip records or replays a request
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.roundTrip(req, http.DefaultTransport.RoundTrip)
+}
+
+// roundTrip records a request sent with send, or replays it
+func (t *Transport) roundTrip(req *http.Request, send func(req *http.Request) (*http.Response, error)) (*http.Response, error) {
+	var requestBody []byte
+	if req.Body != nil {
+		var err error
+		requestBody, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		// The body was consumed, the request is sent with a copy of it rather than modified
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(requestBody))
+	}
+	hash := requestKey(req.Method, req.URL.String(), requestBody)
+
+	if t.replay {
+		return t.replayResponse(req, hash)
+	}
+
+	resp, err := send(req)
+	if err != nil {
+		return nil, err
+	}
+
+	interaction := Interaction{
+		Hash:    hash,
+		Method:  req.Method,
+		URL:     req.URL.String(),
+		Request: string(requestBody),
+		Status:  resp.StatusCode,
+		Header:  make(http.Header),
+	}
+	for name, values := range resp.Header {
+		if !httpheaders.IsSensitive(name) {
+			interaction.Header[name] = values
+		}
+	}
+	// The response is recorded as it is read, so streamed responses still arrive as they are generated
+	resp.Body = &recordingBody{ReadCloser: resp.Body, done: func(body []byte) {
+		if utf8.Valid(body) {
+			interaction.Response = string(body)
+		} else {
+			interaction.ResponseBase64 = body
+		}
+		t.record(interaction)
+	}}
+	return resp, nil
+}
+
+// replayResponse returns the next recorded response to a request
+func (t *Transport) replayResponse(req *http.Request, hash string) (*http.Response, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var matches []Interaction
+	for _, interaction := range t.interactions {
+		if interaction.Hash == hash {
+			matches = append(matches, interaction)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no recorded response to %s %s in %s (request %s), the URL and the last user message must match a recorded request", req.Method, req.URL, t.path, hash[:12])
+	}
+
+	// Once all recorded responses to a request were replayed, the last one is repeated
+	interaction := matches[min(t.replayed[hash], len(matches)-1)]
+	t.replayed[hash]++
+
+	header := interaction.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	body := interaction.body()
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.Status, http.StatusText(interaction.Status)),
+		StatusCode:    interaction.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// record adds an interaction and saves all of them, so an interrupted run keeps what was recorded so far
+func (t *Transport) record(interaction Interaction) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.interactions = append(t.interactions, interaction)
+	data, err := json.MarshalIndent(t.interactions, "", "  ")
+	if err == nil {
+		// The requests include the prompt, with the file list and command history, so the file is private
+		err = os.WriteFile(t.path, data, 0600)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record API interaction to %s: %v\n", t.path, err)
+	}
+}
+
+// requestKey identifies a request by its method, URL and the last user message of its body
+// The rest of a chat request changes between runs: the system prompt holds the command history, which includes
+// the recorded run itself, and the files. Bodies that aren't chat requests are matched as a whole.
+func requestKey(method, url string, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", method, url)
+	if message, ok := lastUserMessage(body); ok {
+		hash.Write([]byte(message))
+	} else {
+		hash.Write(body)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// lastUserMessage returns the text of the last user message of a chat request body
+// The content is a string in OpenAI-compatible requests, and a list of blocks in Anthropic and Bedrock ones.
+func lastUserMessage(body []byte) (string, bool) {
+	var request struct {
+		Messages []struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return "", false
+	}
+
+	for i := len(request.Messages) - 1; i >= 0; i-- {
+		message := request.Messages[i]
+		if message.Role != "user" {
+			continue
+		}
+		var text string
+		if err := json.Unmarshal(message.Content, &text); err == nil {
+			return text, true
+		}
+		var blocks []struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(message.Content, &blocks); err != nil {
+			return "", false
+		}
+		var b strings.Builder
+		for _, block := range blocks {
+			b.WriteString(block.Text)
+		}
+		return b.String(), true
+	}
+	return "", false
+}
+
+// recordingBody keeps a copy of a response body as it is read, and hands it to done at the end or when closed
+type recordingBody struct {
+	io.ReadCloser
+	body bytes.Buffer
+	done func(body []byte)
+	once sync.Once
+}
+
+// Read reads from the response body, keeping a copy
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.body.Write(p[:n])
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+// Close closes the response body, recording what was read of it
+func (b *recordingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.finish()
+	return err
+}
+
+// finish hands the body read so far to done, once
+func (b *recordingBody) finish() {
+	b.once.Do(func() {
+		b.done(b.body.Bytes())
+	})
+}
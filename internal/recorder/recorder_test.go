@@ -0,0 +1,126 @@
+package recorder
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// chatBody returns an OpenAI-compatible chat request body with the given system prompt and user message
+func chatBody(t *testing.T, systemPrompt, userMessage string) string {
+	t.Helper()
+	body, err := json.Marshal(map[string]any{
+		"model": "m",
+		"messages": []map[string]string{
+			{"role": "system", "content": systemPrompt},
+			{"role": "user", "content": userMessage},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// send posts body to url with transport and returns the response body
+func send(t *testing.T, transport http.RoundTripper, url, body string) (string, error) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data), nil
+}
+
+func TestReplayIgnoresSystemPrompt(t *testing.T) {
+	var responses atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, []string{"first", "second"}[responses.Add(1)-1])
+	}))
+	t.Cleanup(server.Close)
+	url := server.URL + "/v1/chat/completions"
+	path := filepath.Join(t.TempDir(), "demo.json")
+
+	recorder, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, history := range []string{"", "ls"} {
+		if _, err := send(t, recorder, url, chatBody(t, "History: "+history, "list the files")); err != nil {
+			t.Fatalf("recording failed: %v", err)
+		}
+	}
+
+	// The replayed run's history holds the recorded run, it still gets the recorded responses in order
+	replayer, err := NewReplayer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"first", "second", "second"} {
+		got, err := send(t, replayer, url, chatBody(t, "History: ls\nls\nls", "list the files"))
+		if err != nil {
+			t.Fatalf("replay failed: %v", err)
+		}
+		if got != want {
+			t.Errorf("replayed %q, want %q", got, want)
+		}
+	}
+
+	if _, err := send(t, replayer, url, chatBody(t, "History: ", "remove the files")); err == nil {
+		t.Error("a different user message was answered with a recorded response")
+	}
+	if responses.Load() != 2 {
+		t.Errorf("the server got %d requests, want only the 2 recorded ones", responses.Load())
+	}
+}
+
+func TestLastUserMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		message string
+		ok      bool
+	}{
+		{
+			name:    "OpenAI-compatible",
+			body:    `{"messages": [{"role": "system", "content": "files"}, {"role": "user", "content": "list the files"}]}`,
+			message: "list the files",
+			ok:      true,
+		},
+		{
+			name: "Anthropic with a prefilled response",
+			body: `{"system": "files", "messages": [{"role": "user", "content": [{"type": "text", "text": "first"}]},` +
+				`{"role": "assistant", "content": [{"type": "text", "text": "{}"}]},` +
+				`{"role": "user", "content": [{"type": "text", "text": "list the files"}]},` +
+				`{"role": "assistant", "content": [{"type": "text", "text": "{"}]}]}`,
+			message: "list the files",
+			ok:      true,
+		},
+		{name: "no messages", body: `{"model": "m"}`},
+		{name: "not JSON", body: "ping"},
+		{name: "empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, ok := lastUserMessage([]byte(tt.body))
+			if message != tt.message || ok != tt.ok {
+				t.Errorf("lastUserMessage = %q, %v, want %q, %v", message, ok, tt.message, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+// IDs correlates a request made by ai with the ID the provider assigned to it
+type IDs struct {
+	// Local is generated by ai for each request
+	Local string
+
+	mutex    sync.Mutex
+	provider string
+}
+
+// contextKey is the context key of the request's IDs
+type contextKey struct{}
+
+// New generates a local request ID and attaches it to the context, so clients can record the provider's ID
+func New(ctx context.Context) (context.Context, *IDs) {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		// The ID is only used for correlating logs, so a failure isn't worth aborting the request
+		buf = nil
+	}
+
+	ids := &IDs{Local: hex.EncodeToString(buf)}
+	return context.WithValue(ctx, contextKey{}, ids), ids
+}
+
+// SetProvider records the provider's request ID on the IDs attached to ctx, if any
+func SetProvider(ctx context.Context, providerID string) {
+	ids, ok := ctx.Value(contextKey{}).(*IDs)
+	if !ok || providerID == "" {
+		return
+	}
+
+	ids.mutex.Lock()
+	defer ids.mutex.Unlock()
+	ids.provider = providerID
+}
+
+// Provider returns the provider's request ID, or an empty string if it wasn't received
+func (r *IDs) Provider() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.provider
+}
+
+// String formats both IDs for log messages
+func (r *IDs) String() string {
+	provider := r.Provider()
+	if provider == "" {
+		return fmt.Sprintf("request %s", r.Local)
+	}
+	return fmt.Sprintf("request %s, provider request %s", r.Local, provider)
+}
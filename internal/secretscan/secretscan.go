@@ -0,0 +1,87 @@
+package secretscan
+
+import (
+	"regexp"
+	"sort"
+)
+
+// Finding is a possible secret found in a text
+type Finding struct {
+	// Kind describes what the secret looks like, e.g. "AWS access key ID"
+	Kind string
+	// Masked is the start of the secret, safe to show and log
+	Masked string
+}
+
+// pattern matches one kind of secret, the secret is the submatch named "secret" or else the whole match
+type pattern struct {
+	kind string
+	re   *regexp.Regexp
+}
+
+// Patterns are ordered from most to least specific, text matched by one pattern isn't matched again by a later one
+var patterns = []pattern{
+	{"private key", regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`)},
+	{"Anthropic API key", regexp.MustCompile(`\bsk-ant-[A-Za-z0-9_-]{20,}`)},
+	{"OpenAI API key", regexp.MustCompile(`\bsk-(?:proj-)?[A-Za-z0-9_-]{20,}`)},
+	{"AWS access key ID", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"GitHub token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{"Google API key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}`)},
+	{"JSON web token", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`)},
+	{"bearer token", regexp.MustCompile(`(?i)\bbearer\s+(?P<secret>[A-Za-z0-9._~+/-]{16,}=*)`)},
+	{"password in URL", regexp.MustCompile(`\b[a-z][a-z0-9+.-]*://[^/\s:@]+:(?P<secret>[^/\s@$]+)@`)},
+	// Values starting with $ are variable references, which keep the secret out of the command line
+	{"hardcoded credential", regexp.MustCompile(`(?i)\b[a-z0-9_-]*(?:password|passwd|secret|token|api_?key)[a-z0-9_-]*\s*[=:]\s*['"]?(?P<secret>[^\s'"$][^\s'"]{7,})`)},
+}
+
+// Scan returns what look like credentials in a command, such as API keys, tokens or passwords
+// It is a heuristic: secrets without a recognizable format are missed and random strings may match
+func Scan(cmd string) []Finding {
+	type span struct{ start, end int }
+	var matched []span
+	overlaps := func(start, end int) bool {
+		for _, s := range matched {
+			if start < s.end && s.start < end {
+				return true
+			}
+		}
+		return false
+	}
+
+	type located struct {
+		Finding
+		start int
+	}
+	var found []located
+	for _, p := range patterns {
+		secretIndex := p.re.SubexpIndex("secret")
+		for _, loc := range p.re.FindAllStringSubmatchIndex(cmd, -1) {
+			start, end := loc[0], loc[1]
+			if secretIndex >= 0 && loc[2*secretIndex] >= 0 {
+				start, end = loc[2*secretIndex], loc[2*secretIndex+1]
+			}
+			if overlaps(start, end) {
+				continue
+			}
+			matched = append(matched, span{start, end})
+			found = append(found, located{Finding{Kind: p.kind, Masked: mask(cmd[start:end])}, start})
+		}
+	}
+
+	// Report findings in the order they appear in the command
+	sort.Slice(found, func(i, j int) bool { return found[i].start < found[j].start })
+	findings := make([]Finding, 0, len(found))
+	for _, f := range found {
+		findings = append(findings, f.Finding)
+	}
+	return findings
+}
+
+// mask keeps the first characters of a secret so the user can recognize it without it being shown
+func mask(secret string) string {
+	if len(secret) <= 8 {
+		return "****"
+	}
+	return secret[:4] + "****"
+}
@@ -0,0 +1,132 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/nir/ai.go/internal/config"
+)
+
+// Message is a single conversation turn
+type Message struct {
+	Role    string `json:"role"` // "user" or "assistant"
+	Content string `json:"content"`
+}
+
+// Install records software installed by a command run in the session, so it can be cleaned up later
+type Install struct {
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+	Manager string    `json:"manager"`
+	// Packages is empty when the command installed a project's dependencies or from a file
+	Packages []string `json:"packages,omitempty"`
+}
+
+// Session holds the conversation of a resumable session
+type Session struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Messages  []Message `json:"messages"`
+	// Installs are recorded with --record-installs
+	Installs []Install `json:"installs,omitempty"`
+}
+
+// validID restricts session IDs to characters that are safe in file names
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// New creates an empty session with a random ID
+func New() (*Session, error) {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("failed to generate session ID: %w", err)
+	}
+
+	now := time.Now()
+	return &Session{
+		ID:        hex.EncodeToString(buf),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}, nil
+}
+
+// Load reads the session with the given ID from ~/.ai/sessions
+// A session that doesn't exist yet is created empty, so users can pick their own IDs
+func Load(id string) (*Session, error) {
+	sessionPath, err := path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(sessionPath)
+	if os.IsNotExist(err) {
+		now := time.Now()
+		return &Session{ID: id, CreatedAt: now, UpdatedAt: now}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session file: %w", err)
+	}
+
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse session file: %w", err)
+	}
+	s.ID = id
+
+	return &s, nil
+}
+
+// Append adds a turn to the conversation
+func (s *Session) Append(role, content string) {
+	s.Messages = append(s.Messages, Message{Role: role, Content: content})
+	s.UpdatedAt = time.Now()
+}
+
+// RecordInstall adds software installed by a command
+func (s *Session) RecordInstall(command, manager string, packages []string) {
+	s.Installs = append(s.Installs, Install{Time: time.Now(), Command: command, Manager: manager, Packages: packages})
+	s.UpdatedAt = time.Now()
+}
+
+// Save writes the session to ~/.ai/sessions/<id>.json
+func (s *Session) Save() error {
+	sessionPath, err := path(s.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(sessionPath), 0700); err != nil {
+		return fmt.Errorf("failed to create sessions directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal session: %w", err)
+	}
+
+	// Sessions contain command output, so keep them private to the user
+	if err := os.WriteFile(sessionPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write session file: %w", err)
+	}
+	return nil
+}
+
+// path returns the file path of the session with the given ID
+func path(id string) (string, error) {
+	if !validID.MatchString(id) {
+		return "", fmt.Errorf("invalid session ID %q: only letters, digits, '-' and '_' are allowed", id)
+	}
+
+	aiDir, err := config.Dir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(aiDir, "sessions", id+".json"), nil
+}
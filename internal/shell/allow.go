@@ -0,0 +1,57 @@
+package shell
+
+import "strings"
+
+// unsafeForAllowList lists shell syntax that can hide other commands or write files behind an allowed prefix
+var unsafeForAllowList = []string{"$(", "`", ">", "<(", "&"}
+
+// IsAlwaysAllowed reports whether every command in a command line starts with one of the allowed prefixes
+// Prefixes match whole words, so "ls" matches "ls -la" but not "lsof". Command lines using substitution,
+// redirection or background jobs never match, as they could run or overwrite something the prefix doesn't cover
+func IsAlwaysAllowed(cmd string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return false
+	}
+
+	// A lone & runs a job in the background, && has already been split off by splitSegments
+	for _, syntax := range unsafeForAllowList {
+		if strings.Contains(strings.ReplaceAll(cmd, "&&", ""), syntax) {
+			return false
+		}
+	}
+
+	matched := false
+	for _, segment := range splitSegments(cmd) {
+		words := strings.Fields(segment)
+		if len(words) == 0 {
+			continue
+		}
+		if !matchesAnyPrefix(words, prefixes) {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// matchesAnyPrefix reports whether the words of a command start with the words of one of the prefixes
+func matchesAnyPrefix(words []string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		prefixWords := strings.Fields(prefix)
+		if len(prefixWords) == 0 || len(prefixWords) > len(words) {
+			continue
+		}
+
+		match := true
+		for i, word := range prefixWords {
+			if words[i] != word {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,344 @@
+package shell
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// EditTargets returns the existing regular files a command line writes to, relative paths resolved against dir
+// It looks for output redirections and programs that write to files named in their arguments, such as tee, sed -i,
+// perl -i, truncate, dd of=, sort -o, gofmt -w and the destination of cp, mv and install.
+// This is a best-effort check that does not handle quoting, variables or files written by scripts the command runs.
+func EditTargets(cmd, dir string) []string {
+	var candidates []string
+	for _, segment := range splitSegments(cmd) {
+		candidates = append(candidates, redirectTargets(strings.Fields(segment))...)
+	}
+	for _, words := range splitCommands(cmd) {
+		words = unwrap(words)
+		if len(words) > 0 {
+			candidates = append(candidates, writtenFiles(words[0], words[1:], dir)...)
+		}
+	}
+
+	var targets []string
+	seen := make(map[string]bool)
+	for _, candidate := range candidates {
+		candidate = strings.Trim(candidate, `"'`)
+		if candidate == "" || strings.ContainsAny(candidate, "$`*?") {
+			continue
+		}
+		path := candidate
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		path = filepath.Clean(path)
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			targets = append(targets, path)
+		}
+	}
+	return targets
+}
+
+// redirectTargets returns the files output is redirected to with >, >> or &>, written apart or attached
+func redirectTargets(words []string) []string {
+	var targets []string
+	for i, word := range words {
+		index := strings.IndexByte(word, '>')
+		if index < 0 {
+			continue
+		}
+		target := strings.TrimLeft(word[index:], ">|")
+		if target == "" && i+1 < len(words) {
+			target = words[i+1]
+		}
+		// Duplicated file descriptors (2>&1) and devices aren't files
+		if strings.HasPrefix(target, "&") || strings.HasPrefix(target, "/dev/") {
+			continue
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// writtenFiles returns the files a program writes to according to its arguments
+func writtenFiles(program string, args []string, dir string) []string {
+	switch program {
+	case "tee":
+		return operands(args, nil)
+	case "sed":
+		if !hasShortFlag(args, 'i') && !hasFlag(args, "--in-place") {
+			return nil
+		}
+		return scriptFiles(args, []string{"-e", "--expression", "-f", "--file"}, []string{"-l", "--line-length"})
+	case "perl":
+		if !hasShortFlag(args, 'i') {
+			return nil
+		}
+		return scriptFiles(args, []string{"-e", "-E"}, nil)
+	case "truncate":
+		return operands(args, []string{"-s", "--size", "-r", "--reference"})
+	case "dd":
+		for _, arg := range args {
+			if strings.HasPrefix(arg, "of=") {
+				return []string{strings.TrimPrefix(arg, "of=")}
+			}
+		}
+	case "sort":
+		for i, arg := range args {
+			if arg == "-o" && i+1 < len(args) {
+				return []string{args[i+1]}
+			}
+			if strings.HasPrefix(arg, "--output=") {
+				return []string{strings.TrimPrefix(arg, "--output=")}
+			}
+		}
+	case "gofmt", "goimports":
+		if hasFlag(args, "-w") {
+			return operands(args, nil)
+		}
+	case "cp", "mv", "install":
+		return copyDestinations(args, dir)
+	}
+	return nil
+}
+
+// copyDestinations returns the files cp, mv or install overwrite: the destination, or the sources' names in a destination directory
+func copyDestinations(args []string, dir string) []string {
+	files := operands(args, []string{"-t", "--target-directory", "-S", "--suffix", "-m", "--mode", "-o", "--owner", "-g", "--group"})
+	if len(files) < 2 {
+		return nil
+	}
+	sources, destination := files[:len(files)-1], files[len(files)-1]
+
+	path := destination
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		return []string{destination}
+	}
+	var targets []string
+	for _, source := range sources {
+		targets = append(targets, filepath.Join(destination, filepath.Base(source)))
+	}
+	return targets
+}
+
+// scriptFiles returns the file operands of sed or perl, leaving out the script given as the first operand
+// unless it was given with one of scriptFlags (sed -e, perl -e), valueFlags are other flags that take a value
+func scriptFiles(args []string, scriptFlags, valueFlags []string) []string {
+	files := operands(args, append(scriptFlags, valueFlags...))
+	for _, flag := range scriptFlags {
+		if hasFlag(args, flag) {
+			return files
+		}
+	}
+	if len(files) > 0 {
+		files = files[1:]
+	}
+	return files
+}
+
+// operands returns the arguments that aren't flags, skipping the values of valueFlags and any redirections
+func operands(args []string, valueFlags []string) []string {
+	var files []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case contains(valueFlags, arg):
+			i++
+		case strings.HasPrefix(arg, "-") || strings.ContainsAny(arg, "<>"):
+		case i > 0 && isRedirection(args[i-1]):
+			// The file of a redirection written apart, e.g. "> out.txt"
+		default:
+			files = append(files, arg)
+		}
+	}
+	return files
+}
+
+// isRedirection reports whether a word is a redirection operator on its own, such as >, 2>> or <
+func isRedirection(word string) bool {
+	return strings.ContainsAny(word, "<>") && strings.Trim(word, "0123456789&<>|") == ""
+}
+
+// hasFlag reports whether args contain flag, alone or with an attached =value
+func hasFlag(args []string, flag string) bool {
+	for _, arg := range args {
+		if arg == flag || strings.HasPrefix(arg, flag+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// hasShortFlag reports whether a cluster of short flags such as -pi or -i.bak contains letter
+// Only the letters before any value are considered, so in -i.bak the suffix isn't taken for flags
+func hasShortFlag(args []string, letter byte) bool {
+	for _, arg := range args {
+		if len(arg) < 2 || arg[0] != '-' || arg[1] == '-' {
+			continue
+		}
+		for i := 1; i < len(arg); i++ {
+			c := arg[i]
+			if c == letter {
+				return true
+			}
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+				break
+			}
+		}
+	}
+	return false
+}
+
+// Backup holds copies of files taken before a command runs, so its changes to them can be reverted
+type Backup struct {
+	// Paths are the files that were backed up, in the order given to NewBackup
+	Paths []string
+
+	dir string
+	// copies maps each backed up path to its copy in dir
+	copies map[string]string
+}
+
+// NewBackup copies the given files into a new temporary directory, Close removes it
+func NewBackup(paths []string) (*Backup, error) {
+	dir, err := os.MkdirTemp("", "ai-backup-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	backup := &Backup{dir: dir, copies: make(map[string]string)}
+
+	for i, path := range paths {
+		backupPath := filepath.Join(dir, fmt.Sprintf("%d-%s", i, filepath.Base(path)))
+		if err := copyFile(path, backupPath); err != nil {
+			backup.Close()
+			return nil, fmt.Errorf("failed to back up %s: %w", path, err)
+		}
+		backup.Paths = append(backup.Paths, path)
+		backup.copies[path] = backupPath
+	}
+	return backup, nil
+}
+
+// Changed returns the backed up files whose content or mode changed, or that were deleted
+func (b *Backup) Changed() ([]string, error) {
+	var changed []string
+	for _, path := range b.Paths {
+		same, err := sameFile(b.copies[path], path)
+		if err != nil {
+			return nil, err
+		}
+		if !same {
+			changed = append(changed, path)
+		}
+	}
+	return changed, nil
+}
+
+// Restore puts back the backed up copy of a file
+func (b *Backup) Restore(path string) error {
+	backupPath, ok := b.copies[path]
+	if !ok {
+		return fmt.Errorf("%s wasn't backed up", path)
+	}
+	// Replace rather than overwrite, the file may have become a symlink
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to restore %s: %w", path, err)
+	}
+	if err := copyFile(backupPath, path); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", path, err)
+	}
+	return nil
+}
+
+// Diff returns a unified diff of a file's changes, at most maxLines long, or "" if the diff program isn't available
+func (b *Backup) Diff(path string, maxLines int) string {
+	if _, err := exec.LookPath("diff"); err != nil {
+		return ""
+	}
+	target := path
+	if _, err := os.Stat(path); err != nil {
+		target = os.DevNull
+	}
+	// diff exits with 1 when the files differ, its output is all that matters
+	output, _ := exec.Command("diff", "-u", "--label", "before", "--label", "after", b.copies[path], target).Output()
+
+	lines := strings.SplitAfter(string(output), "\n")
+	if len(lines) > maxLines {
+		lines = append(lines[:maxLines], fmt.Sprintf("... (%d more lines)\n", len(lines)-maxLines))
+	}
+	return strings.Join(lines, "")
+}
+
+// Close removes the backup
+func (b *Backup) Close() error {
+	if err := os.RemoveAll(b.dir); err != nil {
+		return fmt.Errorf("failed to remove backup: %w", err)
+	}
+	return nil
+}
+
+// sameFile reports whether a file still has the content and mode of its backup, a missing file differs
+func sameFile(backupPath, path string) (bool, error) {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check %s: %w", path, err)
+	}
+	backupInfo, err := os.Stat(backupPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to check backup of %s: %w", path, err)
+	}
+	if !info.Mode().IsRegular() || info.Mode().Perm() != backupInfo.Mode().Perm() || info.Size() != backupInfo.Size() {
+		return false, nil
+	}
+
+	a, err := os.Open(backupPath)
+	if err != nil {
+		return false, err
+	}
+	defer a.Close()
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return sameContent(a, f)
+}
+
+// sameContent compares two readers chunk by chunk
+func sameContent(a, b io.Reader) (bool, error) {
+	bufA, bufB := make([]byte, 64*1024), make([]byte, 64*1024)
+	for {
+		n, errA := io.ReadFull(a, bufA)
+		m, errB := io.ReadFull(b, bufB)
+		if !bytes.Equal(bufA[:n], bufB[:m]) {
+			return false, nil
+		}
+		endA := errors.Is(errA, io.EOF) || errors.Is(errA, io.ErrUnexpectedEOF)
+		endB := errors.Is(errB, io.EOF) || errors.Is(errB, io.ErrUnexpectedEOF)
+		switch {
+		case errA != nil && !endA:
+			return false, errA
+		case errB != nil && !endB:
+			return false, errB
+		case endA || endB:
+			return endA == endB, nil
+		}
+	}
+}
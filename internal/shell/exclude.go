@@ -0,0 +1,76 @@
+package shell
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ExcludeMatcher matches relative paths against a set of glob patterns
+//
+// Patterns without a slash (e.g. "dist" or "*.min.js") match any single path
+// element, so they exclude matching files and directories at any depth.
+// Patterns containing a slash (e.g. "web/static/*") match the whole relative path.
+// A trailing slash (e.g. "vendor/") restricts the pattern to directories.
+type ExcludeMatcher struct {
+	patterns []excludePattern
+}
+
+type excludePattern struct {
+	glob     string
+	dirOnly  bool
+	anchored bool
+}
+
+// NewExcludeMatcher compiles the given glob patterns into a matcher
+func NewExcludeMatcher(patterns []string) (*ExcludeMatcher, error) {
+	m := &ExcludeMatcher{}
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		pattern := excludePattern{}
+		if strings.HasSuffix(p, "/") {
+			pattern.dirOnly = true
+			p = strings.TrimSuffix(p, "/")
+		}
+		p = strings.TrimPrefix(p, "./")
+		pattern.anchored = strings.Contains(p, "/")
+		pattern.glob = filepath.FromSlash(p)
+
+		// Validate the pattern syntax up front
+		if _, err := filepath.Match(pattern.glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %w", p, err)
+		}
+
+		m.patterns = append(m.patterns, pattern)
+	}
+	return m, nil
+}
+
+// Match reports whether the relative path should be excluded
+func (m *ExcludeMatcher) Match(relPath string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+
+	for _, p := range m.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+
+		if p.anchored {
+			if ok, _ := filepath.Match(p.glob, relPath); ok {
+				return true
+			}
+			continue
+		}
+
+		if ok, _ := filepath.Match(p.glob, filepath.Base(relPath)); ok {
+			return true
+		}
+	}
+	return false
+}
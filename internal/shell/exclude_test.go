@@ -0,0 +1,111 @@
+package shell
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// excludeTree is the tree the exclude patterns are tested against
+var excludeTree = []string{
+	"main.go",
+	"README.md",
+	"app.min.js",
+	"dist/bundle.js",
+	"vendor/lib/lib.go",
+	"web/static/logo.png",
+	"web/static/app.min.js",
+	"web/index.html",
+	"docs/vendor",
+	"build/out.log",
+	"src/build/gen.go",
+}
+
+func TestListFilesExclude(t *testing.T) {
+	dir := t.TempDir()
+	for _, file := range excludeTree {
+		path := filepath.Join(dir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		patterns []string
+		excluded []string
+	}{
+		{name: "none"},
+		{
+			name:     "directory at any depth",
+			patterns: []string{"build"},
+			excluded: []string{"build/out.log", "src/build/gen.go"},
+		},
+		{
+			name:     "extension at any depth",
+			patterns: []string{"*.min.js"},
+			excluded: []string{"app.min.js", "web/static/app.min.js"},
+		},
+		{
+			name:     "directories only",
+			patterns: []string{"vendor/"},
+			excluded: []string{"vendor/lib/lib.go"},
+		},
+		{
+			name:     "files and directories",
+			patterns: []string{"vendor"},
+			excluded: []string{"vendor/lib/lib.go", "docs/vendor"},
+		},
+		{
+			name:     "anchored path",
+			patterns: []string{"web/static/*"},
+			excluded: []string{"web/static/logo.png", "web/static/app.min.js"},
+		},
+		{
+			name:     "anchored from the root only",
+			patterns: []string{"./dist", "static/*"},
+			excluded: []string{"dist/bundle.js"},
+		},
+		{
+			name:     "several patterns",
+			patterns: []string{"dist/", "*.md", " ", "*.png"},
+			excluded: []string{"dist/bundle.js", "README.md", "web/static/logo.png"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matcher, err := NewExcludeMatcher(tt.patterns)
+			if err != nil {
+				t.Fatalf("NewExcludeMatcher(%q) failed: %v", tt.patterns, err)
+			}
+			sh := &Shell{Exclude: matcher}
+			files, truncated, err := sh.ListFilesIn(dir, 100)
+			if err != nil || truncated {
+				t.Fatalf("ListFilesIn = %v, %v", truncated, err)
+			}
+
+			var want []string
+			for _, file := range excludeTree {
+				if !slices.Contains(tt.excluded, file) {
+					want = append(want, filepath.FromSlash(file))
+				}
+			}
+			slices.Sort(files)
+			slices.Sort(want)
+			if !slices.Equal(files, want) {
+				t.Errorf("ListFilesIn with %q\ngot:  %q\nwant: %q", tt.patterns, files, want)
+			}
+		})
+	}
+}
+
+func TestNewExcludeMatcherInvalid(t *testing.T) {
+	if _, err := NewExcludeMatcher([]string{"[a-"}); err == nil {
+		t.Error("NewExcludeMatcher accepted an invalid pattern")
+	}
+}
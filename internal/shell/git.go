@@ -0,0 +1,126 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// MaxGitLog caps the number of recent commits GitContext lists, to keep the prompt small
+const MaxGitLog = 50
+
+// GitInfo summarizes the state of the git repository in the current directory
+type GitInfo struct {
+	// InRepo is false when the current directory isn't in a git work tree, all other fields are then empty
+	InRepo    bool
+	Branch    string // Empty for a detached HEAD
+	Staged    int
+	Modified  int
+	Untracked int
+	// RecentCommits holds the abbreviated hash and subject of the latest commits, newest first
+	RecentCommits []string
+}
+
+// GitContext returns the state of the git repository in the current directory, with up to recentCommits of the latest commits
+// It returns an empty GitInfo when not in a repository or when git isn't installed
+func GitContext(recentCommits int) (GitInfo, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return GitInfo{}, nil
+	}
+
+	// Fails outside a work tree, which isn't an error for our purposes
+	if err := exec.Command("git", "rev-parse", "--is-inside-work-tree").Run(); err != nil {
+		return GitInfo{}, nil
+	}
+
+	branch, err := exec.Command("git", "branch", "--show-current").Output()
+	if err != nil {
+		return GitInfo{}, fmt.Errorf("failed to get git branch: %w", gitError(err))
+	}
+
+	status, err := exec.Command("git", "status", "--porcelain").Output()
+	if err != nil {
+		return GitInfo{}, fmt.Errorf("failed to get git status: %w", gitError(err))
+	}
+
+	info := GitInfo{InRepo: true, Branch: strings.TrimSpace(string(branch))}
+	for _, line := range strings.Split(string(status), "\n") {
+		if len(line) < 2 {
+			continue
+		}
+		// Each line starts with the index status and the work tree status of a file
+		index, workTree := line[0], line[1]
+		if index == '?' {
+			info.Untracked++
+			continue
+		}
+		if index != ' ' {
+			info.Staged++
+		}
+		if workTree != ' ' {
+			info.Modified++
+		}
+	}
+
+	if recentCommits > 0 {
+		commits, err := gitLog(min(recentCommits, MaxGitLog))
+		if err != nil {
+			return GitInfo{}, err
+		}
+		info.RecentCommits = commits
+	}
+
+	return info, nil
+}
+
+// gitLog returns the abbreviated hash and subject of the latest n commits, none in a repository without commits
+func gitLog(n int) ([]string, error) {
+	// git log fails on an unborn branch, which isn't an error for our purposes
+	if err := exec.Command("git", "rev-parse", "--verify", "--quiet", "HEAD").Run(); err != nil {
+		return nil, nil
+	}
+
+	output, err := exec.Command("git", "log", "--oneline", "--no-decorate", "-n", strconv.Itoa(n)).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get git log: %w", gitError(err))
+	}
+
+	var commits []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			commits = append(commits, line)
+		}
+	}
+	return commits, nil
+}
+
+// Summary describes the repository state for the system prompt
+func (g GitInfo) Summary() string {
+	if !g.InRepo {
+		return ""
+	}
+
+	branch := g.Branch
+	if branch == "" {
+		branch = "(detached HEAD)"
+	}
+	summary := fmt.Sprintf("Branch: %s\nStaged files: %d, modified files: %d, untracked files: %d", branch, g.Staged, g.Modified, g.Untracked)
+	if g.Staged == 0 && g.Modified == 0 && g.Untracked == 0 {
+		summary = fmt.Sprintf("Branch: %s\nWorking tree clean", branch)
+	}
+	if len(g.RecentCommits) > 0 {
+		summary += "\nRecent commits, newest first:\n" + strings.Join(g.RecentCommits, "\n")
+	}
+	return summary
+}
+
+// gitError adds git's error output to a failed git command's error
+func gitError(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return err
+}
@@ -0,0 +1,141 @@
+package shell
+
+import "strings"
+
+// Install is a package installation found in a command line
+type Install struct {
+	// Manager is the package manager, e.g. apt-get, brew or pip
+	Manager string
+	// Packages are the packages named on the command line, empty when installing
+	// a project's dependencies (e.g. npm install) or from a file (e.g. pip install -r)
+	Packages []string
+}
+
+// installSubcommands maps package managers to their subcommands that install software
+var installSubcommands = map[string][]string{
+	"apt":      {"install", "reinstall"},
+	"apt-get":  {"install", "reinstall"},
+	"aptitude": {"install", "reinstall"},
+	"dnf":      {"install", "reinstall"},
+	"yum":      {"install", "reinstall"},
+	"zypper":   {"install", "in"},
+	"apk":      {"add"},
+	"brew":     {"install", "reinstall"},
+	"port":     {"install"},
+	"snap":     {"install"},
+	"flatpak":  {"install"},
+	"nix-env":  {"-i", "--install"},
+	"npm":      {"install", "i", "add"},
+	"pnpm":     {"install", "i", "add"},
+	"yarn":     {"add", "install"},
+	"bun":      {"add", "install", "i"},
+	"pip":      {"install"},
+	"pip3":     {"install"},
+	"pipx":     {"install"},
+	"uv":       {"add"},
+	"poetry":   {"add"},
+	"gem":      {"install"},
+	"cargo":    {"install", "add"},
+	"go":       {"install"},
+	"conda":    {"install"},
+	"mamba":    {"install"},
+	"choco":    {"install"},
+	"winget":   {"install"},
+	"scoop":    {"install"},
+}
+
+// FindInstalls returns the package installations in a command line, looking through wrappers such as sudo
+// This is a best-effort check that does not handle quoting.
+func FindInstalls(cmd string) []Install {
+	var installs []Install
+	for _, words := range splitCommands(cmd) {
+		words = unwrap(words)
+		if len(words) == 0 {
+			continue
+		}
+		manager := words[0]
+		args := words[1:]
+
+		// python -m pip install runs pip too
+		if (manager == "python" || manager == "python3") && len(args) >= 2 && args[0] == "-m" {
+			manager, args = args[1], args[2:]
+		}
+
+		if install, ok := findInstall(manager, args); ok {
+			installs = append(installs, install)
+		}
+	}
+	return installs
+}
+
+// IsInstallCommand reports whether a command line installs software with a package manager
+func IsInstallCommand(cmd string) bool {
+	return len(FindInstalls(cmd)) > 0
+}
+
+// findInstall checks whether a package manager's arguments install software
+func findInstall(manager string, args []string) (Install, bool) {
+	// pacman installs with -S and its refresh and upgrade variants (-Sy, -Syu), other -S flags search or query
+	if manager == "pacman" {
+		for i, arg := range args {
+			if strings.HasPrefix(arg, "-S") && strings.Trim(arg[2:], "yu") == "" {
+				return Install{Manager: manager, Packages: packageArgs(args[i+1:])}, true
+			}
+		}
+		return Install{}, false
+	}
+
+	subcommands, ok := installSubcommands[manager]
+	if !ok {
+		return Install{}, false
+	}
+	for i, arg := range args {
+		// Flags before the subcommand, e.g. apt-get -y install or yarn global add
+		if strings.HasPrefix(arg, "-") && !contains(subcommands, arg) || arg == "global" {
+			continue
+		}
+		if contains(subcommands, arg) {
+			return Install{Manager: manager, Packages: packageArgs(args[i+1:])}, true
+		}
+		return Install{}, false
+	}
+	return Install{}, false
+}
+
+// packageArgs returns the arguments that name packages, leaving out flags
+// A flag's value is indistinguishable from a package without knowing the package manager,
+// so only the file arguments of -r and --requirement are skipped, along with redirections
+func packageArgs(args []string) []string {
+	var packages []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-r" || arg == "--requirement":
+			i++
+		case strings.HasPrefix(arg, "-") || strings.ContainsAny(arg, "<>"):
+		default:
+			packages = append(packages, arg)
+		}
+	}
+	return packages
+}
+
+// unwrap returns the words of a command from its program on, looking through wrappers such as sudo or env
+func unwrap(words []string) []string {
+	program := leadingProgram(words)
+	for i, word := range words {
+		if strings.TrimLeft(word, "({!") == program {
+			return append([]string{program}, words[i+1:]...)
+		}
+	}
+	return nil
+}
+
+// contains reports whether values contains value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
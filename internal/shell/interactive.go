@@ -0,0 +1,140 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// alwaysInteractive lists programs that expect a terminal regardless of their arguments
+var alwaysInteractive = map[string]bool{
+	"sudo": true, "su": true, "doas": true, "passwd": true, "login": true,
+	"ssh": true, "sftp": true, "telnet": true, "ftp": true,
+	"vi": true, "vim": true, "nvim": true, "nano": true, "emacs": true, "pico": true,
+	"less": true, "more": true, "man": true, "top": true, "htop": true, "watch": true,
+	"tmux": true, "screen": true,
+}
+
+// replPrograms lists programs that start an interactive session when run without arguments
+var replPrograms = map[string]bool{
+	"python": true, "python3": true, "node": true, "irb": true, "ghci": true,
+	"psql": true, "mysql": true, "sqlite3": true, "redis-cli": true, "mongo": true,
+	"bash": true, "sh": true, "zsh": true, "fish": true,
+}
+
+// splitCommands splits a shell command line into its individual commands
+// (separated by pipes, ;, && or ||), each returned as a list of words.
+// This is a best-effort split that does not handle quoting.
+func splitCommands(cmd string) [][]string {
+	var commands [][]string
+	for _, segment := range splitSegments(cmd) {
+		words := strings.Fields(segment)
+
+		// Skip leading environment variable assignments (FOO=bar cmd)
+		for len(words) > 0 && strings.Contains(words[0], "=") && !strings.HasPrefix(words[0], "=") {
+			words = words[1:]
+		}
+
+		if len(words) > 0 {
+			commands = append(commands, words)
+		}
+	}
+	return commands
+}
+
+// splitSegments splits a shell command line on pipes, ;, && and || (and newlines) without further parsing
+func splitSegments(cmd string) []string {
+	replacer := strings.NewReplacer("&&", "\n", "||", "\n", "|", "\n", ";", "\n")
+	return strings.Split(replacer.Replace(cmd), "\n")
+}
+
+// IsInteractive reports whether a command is likely to need interactive terminal input
+func IsInteractive(cmd string) bool {
+	for _, words := range splitCommands(cmd) {
+		program := words[0]
+		args := words[1:]
+
+		switch {
+		case alwaysInteractive[program]:
+			return true
+		case replPrograms[program] && len(args) == 0:
+			return true
+		case program == "git" && isInteractiveGit(args):
+			return true
+		}
+	}
+	return false
+}
+
+// isInteractiveGit reports whether git arguments open an editor or prompt
+func isInteractiveGit(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	hasArg := func(names ...string) bool {
+		for _, arg := range args[1:] {
+			for _, name := range names {
+				if arg == name || strings.HasPrefix(arg, name+"=") {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	switch args[0] {
+	case "commit":
+		// Without a message git opens an editor
+		if hasArg("--no-edit") {
+			return false
+		}
+		for _, arg := range args[1:] {
+			if strings.HasPrefix(arg, "-m") || strings.HasPrefix(arg, "--message") ||
+				strings.HasPrefix(arg, "-F") || strings.HasPrefix(arg, "--file") ||
+				strings.HasPrefix(arg, "-C") || strings.HasPrefix(arg, "--reuse-message") {
+				return false
+			}
+			// Combined short flags such as -am
+			if strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.ContainsAny(arg, "mF") {
+				return false
+			}
+		}
+		return true
+	case "rebase":
+		return hasArg("-i", "--interactive")
+	case "add", "checkout", "reset", "restore", "stash":
+		return hasArg("-p", "--patch", "-i", "--interactive")
+	case "mergetool", "difftool":
+		return true
+	}
+	return false
+}
+
+// RunInteractive executes a command connected directly to the terminal
+// The output is not captured, so nothing is passed to the output log handler
+func (s *Shell) RunInteractive(cmd string) error {
+	// Log the command
+	if s.LogHandler != nil {
+		s.LogHandler(cmd, "")
+	}
+
+	command := exec.Command("bash", "-c", cmd)
+	command.Dir = s.Dir
+	command.Stdin = os.Stdin
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+
+	// Interactive commands always stay in the foreground process group, they need the terminal
+	if err := s.start(command, false, false); err != nil {
+		return fmt.Errorf("command failed: %w", err)
+	}
+	defer s.finish()
+
+	if err := command.Wait(); err != nil {
+		return fmt.Errorf("command failed: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package shell
+
+import (
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// shellBuiltins lists bash builtins and keywords, which are never found on PATH
+var shellBuiltins = map[string]bool{
+	"!": true, ".": true, ":": true, "[": true, "[[": true, "]]": true, "{": true, "}": true, "((": true,
+	"alias": true, "bg": true, "break": true, "builtin": true, "case": true, "cd": true, "command": true,
+	"continue": true, "declare": true, "dirs": true, "do": true, "done": true, "echo": true, "elif": true,
+	"else": true, "esac": true, "eval": true, "exec": true, "exit": true, "export": true, "false": true,
+	"fg": true, "fi": true, "for": true, "function": true, "getopts": true, "hash": true, "history": true,
+	"if": true, "in": true, "jobs": true, "kill": true, "let": true, "local": true, "popd": true,
+	"printf": true, "pushd": true, "pwd": true, "read": true, "readonly": true, "return": true,
+	"select": true, "set": true, "shift": true, "shopt": true, "source": true, "test": true, "then": true,
+	"time": true, "trap": true, "true": true, "type": true, "typeset": true, "ulimit": true, "umask": true,
+	"unalias": true, "unset": true, "until": true, "wait": true, "while": true,
+}
+
+// commandWrappers lists programs and keywords that run the command given in their arguments
+var commandWrappers = map[string]bool{
+	"sudo": true, "doas": true, "env": true, "nohup": true, "time": true, "exec": true,
+	"command": true, "nice": true, "then": true, "do": true, "else": true, "!": true,
+}
+
+// wrapperValueFlags lists flags of wrappers such as sudo -u or nice -n that take a value
+var wrapperValueFlags = map[string]bool{
+	"-u": true, "-g": true, "-U": true, "-C": true, "-p": true, "-r": true, "-t": true, "-n": true,
+}
+
+// functionDefinition matches shell function definitions, "name()" or "function name"
+var functionDefinition = regexp.MustCompile(`(?:^|[\s;&|({])(?:function\s+([A-Za-z_][\w-]*)|([A-Za-z_][\w-]*)\s*\(\))`)
+
+// MissingPrograms returns the programs a command line runs that aren't installed
+// Shell builtins and keywords, paths, variables and functions defined in the command line are ignored.
+// This is a best-effort check that does not handle quoting.
+func MissingPrograms(cmd string) []string {
+	var missing []string
+	seen := make(map[string]bool)
+	for _, match := range functionDefinition.FindAllStringSubmatch(cmd, -1) {
+		seen[match[1]+match[2]] = true
+	}
+	for _, words := range splitCommands(cmd) {
+		program := leadingProgram(words)
+		if program == "" || seen[program] || shellBuiltins[program] {
+			continue
+		}
+		seen[program] = true
+
+		// Paths may be created by an earlier part of the command line, and variables are only known at run time
+		if strings.ContainsAny(program, "/$`'\"=()") {
+			continue
+		}
+
+		if _, err := exec.LookPath(program); err != nil {
+			missing = append(missing, program)
+		}
+	}
+	return missing
+}
+
+// leadingProgram returns the program a command runs, looking through wrappers such as sudo or env
+func leadingProgram(words []string) string {
+	for i := 0; i < len(words); i++ {
+		word := strings.TrimLeft(words[i], "({!")
+		if word == "" {
+			continue
+		}
+		if !commandWrappers[word] {
+			return word
+		}
+
+		// Skip the wrapper's flags and environment variable assignments
+		for i+1 < len(words) && (strings.HasPrefix(words[i+1], "-") || strings.Contains(words[i+1], "=")) {
+			if wrapperValueFlags[words[i+1]] {
+				i++
+			}
+			i++
+		}
+	}
+	return ""
+}
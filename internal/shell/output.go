@@ -0,0 +1,76 @@
+package shell
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DefaultMaxOutputBytes is the default cap on the output kept for a command (10 MiB)
+const DefaultMaxOutputBytes = 10 * 1024 * 1024
+
+// maxLineBytes is the longest line readLines passes on at once, longer lines are passed in pieces of this size
+const maxLineBytes = 64 * 1024
+
+// outputBuffer collects command output up to a maximum size and counts what it drops
+// It isn't safe for concurrent use, callers serialize writes
+type outputBuffer struct {
+	buf      bytes.Buffer
+	maxBytes int // <= 0 means unlimited
+	dropped  int
+	// onLimit is called once, when the first line is dropped (optional)
+	onLimit func()
+}
+
+// WriteString keeps a line of output unless it would exceed the cap
+func (b *outputBuffer) WriteString(line string) {
+	if b.maxBytes <= 0 || b.buf.Len()+len(line) <= b.maxBytes {
+		b.buf.WriteString(line)
+		return
+	}
+
+	if b.dropped == 0 && b.onLimit != nil {
+		b.onLimit()
+	}
+	b.dropped += len(line)
+}
+
+// String returns the kept output, followed by a notice if any output was dropped
+func (b *outputBuffer) String() string {
+	if b.dropped == 0 {
+		return b.buf.String()
+	}
+	return b.buf.String() + fmt.Sprintf("[output truncated: %d bytes over the %d byte limit were dropped]\n", b.dropped, b.maxBytes)
+}
+
+// readLines reads r until it ends, passing each line with its newline to handle
+// Lines longer than maxLineBytes are passed in pieces rather than failing like a bufio.Scanner, and r is drained
+// after a read error, so a command writing a long line never blocks on a full pipe.
+func readLines(r io.Reader, handle func(line string)) {
+	reader := bufio.NewReaderSize(r, maxLineBytes)
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		switch {
+		case err == nil:
+			line := string(chunk)
+			if strings.HasSuffix(line, "\r\n") {
+				line = line[:len(line)-2] + "\n"
+			}
+			handle(line)
+		case errors.Is(err, bufio.ErrBufferFull):
+			handle(string(chunk))
+		default:
+			// The last line may lack a newline
+			if len(chunk) > 0 {
+				handle(string(chunk) + "\n")
+			}
+			if err != io.EOF {
+				io.Copy(io.Discard, r)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package shell
+
+// privilegePrograms lists programs that run commands with elevated privileges
+var privilegePrograms = map[string]bool{
+	"sudo":   true,
+	"doas":   true,
+	"su":     true,
+	"pkexec": true,
+}
+
+// RequiresPrivilege reports whether a command escalates privileges (e.g. via sudo or doas)
+func RequiresPrivilege(cmd string) bool {
+	for _, words := range splitCommands(cmd) {
+		if privilegePrograms[words[0]] {
+			return true
+		}
+	}
+	return false
+}
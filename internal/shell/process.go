@@ -0,0 +1,80 @@
+package shell
+
+import (
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// runningCommand tracks the command being executed, so signals can be forwarded to it
+type runningCommand struct {
+	mutex   sync.Mutex
+	command *exec.Cmd
+	// group tells whether the command leads its own process group
+	group bool
+	// done is closed once the command has exited
+	done chan struct{}
+}
+
+// StopGracePeriod is how long a command stopped by its context may take to exit after an interrupt before it is killed
+const StopGracePeriod = 5 * time.Second
+
+// start starts a command and tracks it until finish is called
+// Without a terminal there is no job control to signal a command's children, so piped commands
+// get their own process group instead. On a terminal they stay in the foreground group, where
+// Ctrl+C reaches them directly and they can still prompt on /dev/tty, unless ownGroup is set
+// because the command may have to be stopped with its children.
+func (s *Shell) start(command *exec.Cmd, piped, ownGroup bool) error {
+	group := piped && (ownGroup || !StdinIsTerminal())
+	if group {
+		setProcessGroup(command)
+	}
+
+	if err := command.Start(); err != nil {
+		return err
+	}
+
+	s.running.mutex.Lock()
+	defer s.running.mutex.Unlock()
+	s.running.command = command
+	s.running.group = group
+	s.running.done = make(chan struct{})
+	return nil
+}
+
+// finish stops tracking the command once it has exited
+func (s *Shell) finish() {
+	s.running.mutex.Lock()
+	defer s.running.mutex.Unlock()
+	if s.running.done != nil {
+		close(s.running.done)
+	}
+	s.running.command = nil
+	s.running.done = nil
+}
+
+// Signal forwards sig to the running command, if any, and waits up to grace for it to exit before killing it
+// A command in its own process group receives the signal as a whole, so its children aren't orphaned
+func (s *Shell) Signal(sig os.Signal, grace time.Duration) {
+	s.running.mutex.Lock()
+	command, group, done := s.running.command, s.running.group, s.running.done
+	s.running.mutex.Unlock()
+	if command == nil {
+		return
+	}
+
+	signalProcess(command.Process, sig, group)
+	select {
+	case <-done:
+	case <-time.After(grace):
+		signalProcess(command.Process, os.Kill, group)
+	}
+}
+
+// StdinIsTerminal reports whether ai was started from a terminal
+func StdinIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
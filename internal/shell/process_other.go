@@ -0,0 +1,16 @@
+//go:build !unix
+
+package shell
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup does nothing, process groups are only supported on Unix
+func setProcessGroup(command *exec.Cmd) {}
+
+// signalProcess sends sig to the process itself
+func signalProcess(process *os.Process, sig os.Signal, group bool) error {
+	return process.Signal(sig)
+}
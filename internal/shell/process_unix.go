@@ -0,0 +1,22 @@
+//go:build unix
+
+package shell
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup makes the command the leader of a new process group
+func setProcessGroup(command *exec.Cmd) {
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// signalProcess sends sig to a process, or to its whole process group when it leads one
+func signalProcess(process *os.Process, sig os.Signal, group bool) error {
+	if signal, ok := sig.(syscall.Signal); ok && group {
+		return syscall.Kill(-process.Pid, signal)
+	}
+	return process.Signal(sig)
+}
@@ -0,0 +1,246 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// MaxSandboxBytes caps the total size of the files copied into a sandbox
+const MaxSandboxBytes = 256 * 1024 * 1024
+
+// MaxSandboxFiles caps the number of files copied into a sandbox
+const MaxSandboxFiles = 20000
+
+// ErrSandboxTooLarge is returned by NewSandbox when the directory exceeds MaxSandboxBytes or MaxSandboxFiles
+var ErrSandboxTooLarge = errors.New("the directory is too large to copy into a sandbox")
+
+// sandboxFile is the state of a file in a sandbox or the directory it was copied from
+type sandboxFile struct {
+	size    int64
+	modTime time.Time
+	mode    fs.FileMode
+	// link is the target of a symlink
+	link string
+}
+
+// Sandbox is a temporary copy of a directory for previewing what a command does to its files
+// Unlike SnapshotTree it covers hidden files too, and it compares file modes as well as sizes and modification times.
+type Sandbox struct {
+	// Source is the directory that was copied
+	Source string
+	// Dir is the copy, commands run in it with Shell.Dir
+	Dir string
+
+	// files is the state of the copied files, relative to Source
+	files map[string]sandboxFile
+}
+
+// NewSandbox copies source into a new temporary directory, Close removes it
+// Regular files, directories and symlinks are copied, symlinks as they are, so links pointing outside source
+// still lead outside the sandbox. Other files such as sockets are left out.
+func NewSandbox(source string) (*Sandbox, error) {
+	files, err := sandboxFiles(source, true)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "ai-sandbox-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sandbox directory: %w", err)
+	}
+	sandbox := &Sandbox{Source: source, Dir: dir, files: files}
+
+	err = filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(source, path)
+		if err != nil || relPath == "." {
+			return err
+		}
+		target := filepath.Join(dir, relPath)
+
+		if d.IsDir() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return os.Mkdir(target, info.Mode().Perm()|0700)
+		}
+		if _, ok := files[relPath]; !ok {
+			return nil
+		}
+		return copyFile(path, target)
+	})
+	if err != nil {
+		sandbox.Close()
+		return nil, fmt.Errorf("failed to copy %s into a sandbox: %w", source, err)
+	}
+	return sandbox, nil
+}
+
+// Changes returns the files the commands run in the sandbox created, modified or deleted
+func (s *Sandbox) Changes() (Changes, error) {
+	after, err := sandboxFiles(s.Dir, false)
+	if err != nil {
+		return Changes{}, err
+	}
+	return diffSandboxFiles(s.files, after), nil
+}
+
+// Apply makes the changes found in the sandbox to the source directory
+// Files created or modified in the sandbox are copied over, deleted ones are removed, empty directories are left as they are
+func (s *Sandbox) Apply(changes Changes) error {
+	for _, path := range append(append([]string(nil), changes.Created...), changes.Modified...) {
+		target := filepath.Join(s.Source, path)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", path, err)
+		}
+		// Replace rather than overwrite, the file may have become a symlink or stopped being one
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to replace %s: %w", path, err)
+		}
+		if err := copyFile(filepath.Join(s.Dir, path), target); err != nil {
+			return fmt.Errorf("failed to apply %s: %w", path, err)
+		}
+	}
+	for _, path := range changes.Deleted {
+		if err := os.Remove(filepath.Join(s.Source, path)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// Close removes the sandbox
+func (s *Sandbox) Close() error {
+	// Commands may have left read-only directories behind, which RemoveAll can't empty
+	filepath.WalkDir(s.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			os.Chmod(path, 0700)
+		}
+		return nil
+	})
+	if err := os.RemoveAll(s.Dir); err != nil {
+		return fmt.Errorf("failed to remove sandbox: %w", err)
+	}
+	return nil
+}
+
+// sandboxFiles records the state of the regular files and symlinks under dir
+// When capped is set, it fails with ErrSandboxTooLarge once they exceed MaxSandboxBytes or MaxSandboxFiles
+func sandboxFiles(dir string, capped bool) (map[string]sandboxFile, error) {
+	files := make(map[string]sandboxFile)
+	var totalBytes int64
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !(d.Type().IsRegular() || d.Type()&fs.ModeSymlink != 0) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		// Special mode bits such as setuid aren't copied, so they aren't compared either
+		file := sandboxFile{size: info.Size(), modTime: info.ModTime(), mode: info.Mode() & (fs.ModeType | fs.ModePerm)}
+		if d.Type()&fs.ModeSymlink != 0 {
+			// A copied symlink gets a new modification time, only its target tells whether it changed
+			if file.link, err = os.Readlink(path); err != nil {
+				return err
+			}
+			file.modTime = time.Time{}
+		}
+		files[relPath] = file
+
+		totalBytes += info.Size()
+		if capped && (totalBytes > MaxSandboxBytes || len(files) > MaxSandboxFiles) {
+			return ErrSandboxTooLarge
+		}
+		return nil
+	})
+	if errors.Is(err, ErrSandboxTooLarge) {
+		return nil, fmt.Errorf("%w (limits: %d MiB, %d files)", err, MaxSandboxBytes>>20, MaxSandboxFiles)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files of %s: %w", dir, err)
+	}
+	return files, nil
+}
+
+// diffSandboxFiles compares the files of a sandbox before and after running commands
+func diffSandboxFiles(before, after map[string]sandboxFile) Changes {
+	var changes Changes
+	for path, file := range after {
+		previous, ok := before[path]
+		switch {
+		case !ok:
+			changes.Created = append(changes.Created, path)
+		case previous.size != file.size || !previous.modTime.Equal(file.modTime) || previous.mode != file.mode || previous.link != file.link:
+			changes.Modified = append(changes.Modified, path)
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			changes.Deleted = append(changes.Deleted, path)
+		}
+	}
+
+	sort.Strings(changes.Created)
+	sort.Strings(changes.Modified)
+	sort.Strings(changes.Deleted)
+	return changes
+}
+
+// copyFile copies a regular file with its mode and modification time, or recreates a symlink
+func copyFile(source, target string) error {
+	info, err := os.Lstat(source)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		link, err := os.Readlink(source)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(link, target)
+	}
+
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	// The mode is set again as the umask applies to OpenFile, and the modification time is kept so unchanged files compare equal
+	if err := os.Chmod(target, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(target, info.ModTime(), info.ModTime())
+}
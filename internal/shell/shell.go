@@ -1,29 +1,61 @@
 package shell
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Shell handles executing commands
 type Shell struct {
 	LogHandler func(cmd, output string)
+	// Exclude filters files and directories out of ListFiles (optional)
+	Exclude *ExcludeMatcher
+	// ProgressHandler is called every ProgressInterval while StreamCommand produces no output (optional)
+	ProgressHandler  func(elapsed time.Duration)
+	ProgressInterval time.Duration
+	// MaxOutputBytes caps the output kept by ExecuteCommand and StreamCommand, <= 0 means unlimited
+	// Output over the cap is still passed to the handlers, but isn't returned
+	MaxOutputBytes int
+	// KillOnOutputLimit kills the command once its output exceeds MaxOutputBytes
+	KillOnOutputLimit bool
+	// Dir is the directory commands run in, the current directory if empty, see Sandbox
+	Dir string
+
+	// running is the command being executed, see Signal
+	running runningCommand
 }
 
 // New creates a new Shell instance
 func New(logHandler func(cmd, output string)) *Shell {
 	return &Shell{
-		LogHandler: logHandler,
+		LogHandler:     logHandler,
+		MaxOutputBytes: DefaultMaxOutputBytes,
 	}
 }
 
+// newOutputBuffer creates the buffer collecting a command's output, applying the output cap
+func (s *Shell) newOutputBuffer(command *exec.Cmd) *outputBuffer {
+	buffer := &outputBuffer{maxBytes: s.MaxOutputBytes}
+	if s.KillOnOutputLimit {
+		buffer.onLimit = func() {
+			if command.Process != nil {
+				command.Process.Kill()
+			}
+		}
+	}
+	return buffer
+}
+
 // ExecuteCommand executes a command and returns its output
 func (s *Shell) ExecuteCommand(cmd string) (string, error) {
 	// Log the command
@@ -33,6 +65,7 @@ func (s *Shell) ExecuteCommand(cmd string) (string, error) {
 
 	// Create the command
 	command := exec.Command("bash", "-c", cmd)
+	command.Dir = s.Dir
 
 	// Create pipes for stdout and stderr
 	stdoutPipe, err := command.StdoutPipe()
@@ -46,38 +79,41 @@ func (s *Shell) ExecuteCommand(cmd string) (string, error) {
 	}
 
 	// Start the command
-	if err := command.Start(); err != nil {
+	if err := s.start(command, true, false); err != nil {
 		return "", fmt.Errorf("failed to start command: %w", err)
 	}
+	defer s.finish()
 
 	// Combine stdout and stderr output
-	var combinedOutput bytes.Buffer
+	combinedOutput := s.newOutputBuffer(command)
+	var outputMutex sync.Mutex
+	done := make(chan struct{}, 2)
+
+	// handleLine is shared by the stdout and stderr readers
+	handleLine := func(line string) {
+		outputMutex.Lock()
+		defer outputMutex.Unlock()
+		if s.LogHandler != nil {
+			s.LogHandler("", line)
+		}
+		combinedOutput.WriteString(line)
+	}
 
 	// Process stdout in real-time
 	go func() {
-		scanner := bufio.NewScanner(stdoutPipe)
-		for scanner.Scan() {
-			line := scanner.Text()
-			if s.LogHandler != nil {
-				s.LogHandler("", line+"\n")
-			}
-			combinedOutput.WriteString(line + "\n")
-		}
+		readLines(stdoutPipe, handleLine)
+		done <- struct{}{}
 	}()
 
 	// Process stderr in real-time
 	go func() {
-		scanner := bufio.NewScanner(stderrPipe)
-		for scanner.Scan() {
-			line := scanner.Text()
-			if s.LogHandler != nil {
-				s.LogHandler("", line+"\n")
-			}
-			combinedOutput.WriteString(line + "\n")
-		}
+		readLines(stderrPipe, handleLine)
+		done <- struct{}{}
 	}()
 
-	// Wait for the command to complete
+	// Wait for both readers before the command, Wait closes the pipes
+	<-done
+	<-done
 	err = command.Wait()
 
 	// Get the final output
@@ -92,7 +128,23 @@ func (s *Shell) ExecuteCommand(cmd string) (string, error) {
 }
 
 // StreamCommand executes a command and streams its output in real-time
-func (s *Shell) StreamCommand(cmd string, outputHandler func(line string)) (string, error) {
+func (s *Shell) StreamCommand(ctx context.Context, cmd string, outputHandler func(line string)) (string, error) {
+	return s.StreamCommandSplit(ctx, cmd, outputHandler, outputHandler)
+}
+
+// StreamCommandSplit executes a command and streams its stdout and stderr lines to separate handlers
+// The returned output combines both streams in the order the lines were read
+// When ctx ends the command is interrupted, and killed after StopGracePeriod. A command run with a deadline
+// gets its own process group even on a terminal, so its children are stopped with it.
+func (s *Shell) StreamCommandSplit(ctx context.Context, cmd string, stdoutHandler, stderrHandler func(line string)) (string, error) {
+	_, hasDeadline := ctx.Deadline()
+	return s.StreamCommandInput(ctx, cmd, nil, hasDeadline, stdoutHandler, stderrHandler)
+}
+
+// StreamCommandInput is StreamCommandSplit with the command's standard input read from stdin
+// A nil stdin gives the command no input, like StreamCommandSplit. ownGroup gives the command its own process group
+// even on a terminal, for callers that may stop it with Signal, e.g. on a deadline, so its children are stopped with it.
+func (s *Shell) StreamCommandInput(ctx context.Context, cmd string, stdin io.Reader, ownGroup bool, stdoutHandler, stderrHandler func(line string)) (string, error) {
 	// Log the command
 	if s.LogHandler != nil {
 		s.LogHandler(cmd, "")
@@ -100,6 +152,8 @@ func (s *Shell) StreamCommand(cmd string, outputHandler func(line string)) (stri
 
 	// Create the command
 	command := exec.Command("bash", "-c", cmd)
+	command.Dir = s.Dir
+	command.Stdin = stdin
 
 	// Create pipes for stdout and stderr
 	stdoutPipe, err := command.StdoutPipe()
@@ -113,48 +167,96 @@ func (s *Shell) StreamCommand(cmd string, outputHandler func(line string)) (stri
 	}
 
 	// Start the command
-	if err := command.Start(); err != nil {
+	if err := s.start(command, true, ownGroup); err != nil {
 		return "", fmt.Errorf("failed to start command: %w", err)
 	}
+	defer s.finish()
+
+	// Stop the command when the context ends, e.g. when its time is up
+	exited := make(chan struct{})
+	var stopped atomic.Bool
+	go func() {
+		select {
+		case <-ctx.Done():
+			stopped.Store(true)
+			s.Signal(os.Interrupt, StopGracePeriod)
+		case <-exited:
+		}
+	}()
 
 	// Combine stdout and stderr output
-	var combinedOutput bytes.Buffer
+	combinedOutput := s.newOutputBuffer(command)
+	var outputMutex sync.Mutex
+	lastOutput := time.Now()
+
+	// handleLine is shared by the stdout and stderr readers
+	handleLine := func(handler func(line string), line string) {
+		outputMutex.Lock()
+		defer outputMutex.Unlock()
+		lastOutput = time.Now()
+		if s.LogHandler != nil {
+			s.LogHandler("", line)
+		}
+		handler(line)
+		combinedOutput.WriteString(line)
+	}
 
 	// Create a WaitGroup to wait for goroutines to finish
 	done := make(chan struct{}, 2)
 
 	// Process stdout in real-time
 	go func() {
-		scanner := bufio.NewScanner(stdoutPipe)
-		for scanner.Scan() {
-			line := scanner.Text() + "\n"
-			outputHandler(line)
-			combinedOutput.WriteString(line)
-		}
+		readLines(stdoutPipe, func(line string) { handleLine(stdoutHandler, line) })
 		done <- struct{}{}
 	}()
 
 	// Process stderr in real-time
 	go func() {
-		scanner := bufio.NewScanner(stderrPipe)
-		for scanner.Scan() {
-			line := scanner.Text() + "\n"
-			outputHandler(line)
-			combinedOutput.WriteString(line)
-		}
+		readLines(stderrPipe, func(line string) { handleLine(stderrHandler, line) })
 		done <- struct{}{}
 	}()
 
+	// Report progress while the command is silent
+	stopProgress := make(chan struct{})
+	if s.ProgressInterval > 0 && s.ProgressHandler != nil {
+		started := time.Now()
+		ticker := time.NewTicker(s.ProgressInterval)
+		defer ticker.Stop()
+
+		go func() {
+			for {
+				select {
+				case <-stopProgress:
+					return
+				case <-ticker.C:
+					outputMutex.Lock()
+					idle := time.Since(lastOutput) >= s.ProgressInterval
+					outputMutex.Unlock()
+					if idle {
+						s.ProgressHandler(time.Since(started))
+					}
+				}
+			}
+		}()
+	}
+
 	// Wait for both goroutines to complete
 	<-done
 	<-done
+	close(stopProgress)
 
 	// Wait for the command to complete
 	err = command.Wait()
+	close(exited)
 
 	// Get the final output
 	output := combinedOutput.String()
 
+	// Tell a command stopped by its context apart from one that failed
+	if stopped.Load() && err != nil {
+		return output, fmt.Errorf("command stopped: %w\nOutput: %s", ctx.Err(), output)
+	}
+
 	// Return an error if the command failed
 	if err != nil {
 		return output, fmt.Errorf("command failed: %w\nOutput: %s", err, output)
@@ -163,20 +265,39 @@ func (s *Shell) StreamCommand(cmd string, outputHandler func(line string)) (stri
 	return output, nil
 }
 
+// ExitCode returns the exit code of a command from the error returned by StreamCommand, ExecuteCommand or RunInteractive
+// It returns 0 for a nil error and -1 if the command didn't exit normally, e.g. it couldn't start or was killed by a signal
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
 // GetCurrentDirectory returns the current working directory
 func (s *Shell) GetCurrentDirectory() (string, error) {
 	return os.Getwd()
 }
 
 // ListFiles lists files in the current directory (limited to maxFiles)
-func (s *Shell) ListFiles(maxFiles int) ([]string, error) {
+// It also reports whether the list was truncated because there are more files
+func (s *Shell) ListFiles(maxFiles int) ([]string, bool, error) {
 	dir, err := os.Getwd()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get current directory: %w", err)
+		return nil, false, fmt.Errorf("failed to get current directory: %w", err)
 	}
 
+	return s.ListFilesIn(dir, maxFiles)
+}
+
+// ListFilesIn lists files under dir like ListFiles, the paths are relative to dir
+func (s *Shell) ListFilesIn(dir string, maxFiles int) ([]string, bool, error) {
 	var files []string
-	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return nil // Skip files we can't access
 		}
@@ -189,11 +310,6 @@ func (s *Shell) ListFiles(maxFiles int) ([]string, error) {
 			return nil
 		}
 
-		// Skip directories
-		if d.IsDir() && path != dir {
-			return nil
-		}
-
 		// Get the relative path from the current directory
 		relPath, err := filepath.Rel(dir, path)
 		if err != nil {
@@ -205,20 +321,34 @@ func (s *Shell) ListFiles(maxFiles int) ([]string, error) {
 			return nil
 		}
 
-		files = append(files, relPath)
+		// Skip excluded files and directories
+		if s.Exclude.Match(relPath, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 
-		// Stop if we've reached the maximum number of files
+		// Skip directories
+		if d.IsDir() {
+			return nil
+		}
+
+		// Stop at the first file over the limit, which tells the list is truncated
 		if len(files) >= maxFiles {
 			return errors.New("max files reached")
 		}
 
+		files = append(files, relPath)
+
 		return nil
 	})
 
 	// If we stopped because we reached the max files, consider it a success
-	if err != nil && err.Error() != "max files reached" {
-		return files, fmt.Errorf("failed to list files: %w", err)
+	truncated := err != nil && err.Error() == "max files reached"
+	if err != nil && !truncated {
+		return files, false, fmt.Errorf("failed to list files: %w", err)
 	}
 
-	return files, nil
+	return files, truncated, nil
 }
@@ -0,0 +1,104 @@
+package shell
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// longLineCommand writes a 300000 byte line, over the 64KB a bufio.Scanner accepts, followed by a short one
+const longLineCommand = "head -c 300000 /dev/zero | tr '\\0' a; echo; echo done"
+
+func TestStreamCommandLongLine(t *testing.T) {
+	sh := New(nil)
+	sh.MaxOutputBytes = 1000
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	streamed := 0
+	output, err := sh.StreamCommand(ctx, longLineCommand, func(line string) {
+		streamed += len(line)
+	})
+	if err != nil {
+		t.Fatalf("StreamCommand failed: %v", err)
+	}
+
+	if want := 300000 + len("\ndone\n"); streamed != want {
+		t.Errorf("streamed %d bytes, want %d", streamed, want)
+	}
+	if !strings.Contains(output, "[output truncated:") {
+		t.Errorf("output has no truncation notice: %q", output)
+	}
+	if kept := strings.Split(output, "[output truncated:")[0]; len(kept) > sh.MaxOutputBytes {
+		t.Errorf("kept %d bytes of output, over the %d byte limit", len(kept), sh.MaxOutputBytes)
+	}
+	if !strings.HasPrefix(output, "done\n") {
+		t.Errorf("output doesn't start with the lines after the long one: %q", output)
+	}
+}
+
+func TestExecuteCommandLongLine(t *testing.T) {
+	sh := New(nil)
+	sh.MaxOutputBytes = 1000
+
+	result := make(chan string, 1)
+	go func() {
+		output, err := sh.ExecuteCommand(longLineCommand)
+		if err != nil {
+			t.Errorf("ExecuteCommand failed: %v", err)
+		}
+		result <- output
+	}()
+
+	select {
+	case output := <-result:
+		if !strings.Contains(output, "[output truncated:") {
+			t.Errorf("output has no truncation notice: %q", output)
+		}
+		if len(output) > sh.MaxOutputBytes+200 {
+			t.Errorf("output is %d bytes, the limit is %d", len(output), sh.MaxOutputBytes)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("ExecuteCommand didn't return, the output wasn't drained")
+	}
+}
+
+func TestReadLines(t *testing.T) {
+	var lines []string
+	readLines(strings.NewReader("one\r\ntwo\nthree"), func(line string) {
+		lines = append(lines, line)
+	})
+	want := []string{"one\n", "two\n", "three\n"}
+	if strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Errorf("readLines = %q, want %q", lines, want)
+	}
+}
+
+// TestStreamCommandInputOwnGroup checks that a command run with ownGroup is stopped with its children,
+// whose output would keep the command from finishing. It matters on a terminal, without one every command gets its own group.
+func TestStreamCommandInputOwnGroup(t *testing.T) {
+	sh := New(nil)
+
+	result := make(chan error, 1)
+	go func() {
+		// The background sleep ignores the interrupt, as asynchronous commands do without job control, so it has to be killed
+		_, err := sh.StreamCommandInput(context.Background(), "sleep 60 & echo started; wait", nil, true, func(line string) {
+			if line == "started\n" {
+				go sh.Signal(os.Interrupt, time.Second)
+			}
+		}, func(line string) {})
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Error("the stopped command succeeded")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the command's child wasn't stopped with it")
+	}
+}
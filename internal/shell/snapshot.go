@@ -0,0 +1,116 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxSnapshotFiles caps the number of files recorded by SnapshotTree
+const MaxSnapshotFiles = 10000
+
+// errSnapshotLimit stops the walk once MaxSnapshotFiles is reached
+var errSnapshotLimit = errors.New("max snapshot files reached")
+
+// FileState is the size and modification time of a file
+type FileState struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// Snapshot records the state of the files in a directory tree
+type Snapshot struct {
+	Files map[string]FileState
+	// Truncated is set when the tree had more than MaxSnapshotFiles files
+	Truncated bool
+}
+
+// Changes lists the files that differ between two snapshots
+type Changes struct {
+	Created  []string
+	Modified []string
+	Deleted  []string
+}
+
+// Empty reports whether no changes were found
+func (c Changes) Empty() bool {
+	return len(c.Created) == 0 && len(c.Modified) == 0 && len(c.Deleted) == 0
+}
+
+// SnapshotTree records the size and modification time of the non-hidden files under dir
+func SnapshotTree(dir string) (Snapshot, error) {
+	snapshot := Snapshot{Files: make(map[string]FileState)}
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // Skip files we can't access
+		}
+
+		// Skip hidden files and directories
+		if strings.HasPrefix(d.Name(), ".") && path != dir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil // The file may have been removed during the walk
+		}
+
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return nil
+		}
+
+		snapshot.Files[relPath] = FileState{Size: info.Size(), ModTime: info.ModTime()}
+
+		if len(snapshot.Files) >= MaxSnapshotFiles {
+			snapshot.Truncated = true
+			return errSnapshotLimit
+		}
+		return nil
+	})
+
+	if err != nil && !errors.Is(err, errSnapshotLimit) {
+		return snapshot, fmt.Errorf("failed to snapshot directory: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+// Diff returns the changes needed to go from this snapshot to other
+func (s Snapshot) Diff(other Snapshot) Changes {
+	var changes Changes
+
+	for path, after := range other.Files {
+		before, ok := s.Files[path]
+		switch {
+		case !ok:
+			changes.Created = append(changes.Created, path)
+		case before.Size != after.Size || !before.ModTime.Equal(after.ModTime):
+			changes.Modified = append(changes.Modified, path)
+		}
+	}
+
+	for path := range s.Files {
+		if _, ok := other.Files[path]; !ok {
+			changes.Deleted = append(changes.Deleted, path)
+		}
+	}
+
+	sort.Strings(changes.Created)
+	sort.Strings(changes.Modified)
+	sort.Strings(changes.Deleted)
+
+	return changes
+}
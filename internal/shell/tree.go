@@ -0,0 +1,78 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BuildTree renders the files under dir as an indented tree, like the output of `tree`
+// Hidden and excluded files are left out, directories deeper than maxDepth aren't expanded,
+// and the tree stops after maxEntries entries with a note that it was truncated
+func (s *Shell) BuildTree(dir string, maxEntries, maxDepth int) (string, error) {
+	var b strings.Builder
+	b.WriteString(".\n")
+
+	entries := 0
+	truncated := false
+
+	var walk func(path, relDir, indent string, depth int) error
+	walk = func(path, relDir, indent string, depth int) error {
+		items, err := os.ReadDir(path)
+		if err != nil {
+			if depth == 0 {
+				return fmt.Errorf("failed to read directory: %w", err)
+			}
+			return nil // Skip directories we can't access
+		}
+
+		// Skip hidden and excluded files and directories
+		var visible []os.DirEntry
+		for _, item := range items {
+			if strings.HasPrefix(item.Name(), ".") {
+				continue
+			}
+			if s.Exclude.Match(filepath.Join(relDir, item.Name()), item.IsDir()) {
+				continue
+			}
+			visible = append(visible, item)
+		}
+
+		for i, item := range visible {
+			if entries >= maxEntries {
+				truncated = true
+				return nil
+			}
+			entries++
+
+			connector, childIndent := "├── ", "│   "
+			if i == len(visible)-1 {
+				connector, childIndent = "└── ", "    "
+			}
+
+			name := item.Name()
+			if !item.IsDir() {
+				b.WriteString(indent + connector + name + "\n")
+				continue
+			}
+
+			b.WriteString(indent + connector + name + "/\n")
+			if depth+1 < maxDepth {
+				if err := walk(filepath.Join(path, name), filepath.Join(relDir, name), indent+childIndent, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := walk(dir, "", "", 0); err != nil {
+		return "", err
+	}
+
+	if truncated {
+		fmt.Fprintf(&b, "[truncated after %d entries]\n", maxEntries)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,89 @@
+package theme
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/spinner"
+)
+
+// Theme names
+const (
+	// Default is the original palette
+	Default = "default"
+	// Mono prints no colors at all, it is forced by NO_COLOR
+	Mono = "mono"
+	// HighContrast uses bold, bright colors that stand out on dark and light backgrounds
+	HighContrast = "high-contrast"
+)
+
+// Palette holds the console colors of a theme as ANSI escape sequences, empty sequences print no color
+type Palette struct {
+	// Command colors suggested and executed commands
+	Command string
+	// Error colors failures, such as failed checks and deleted files
+	Error   string
+	Success string
+	// Warning colors cautions and error messages that don't stop ai
+	Warning string
+	Info    string
+	// Highlight colors notices that need attention, such as elevated privileges
+	Highlight string
+	Reset     string
+
+	// Spinner is shown while waiting for the model, in SpinnerColor (a lipgloss color, empty for the terminal's default)
+	Spinner      spinner.Spinner
+	SpinnerColor string
+	SpinnerBold  bool
+}
+
+// names lists the themes in the order they are documented
+var names = []string{Default, Mono, HighContrast}
+
+// palettes maps theme names to their palettes
+var palettes = map[string]Palette{
+	Default: {
+		Command:      "\033[31m",
+		Error:        "\033[31m",
+		Success:      "\033[32m",
+		Warning:      "\033[33m",
+		Info:         "\033[34m",
+		Highlight:    "\033[35m",
+		Reset:        "\033[0m",
+		Spinner:      spinner.Dot,
+		SpinnerColor: "205",
+	},
+	Mono: {
+		// An ASCII spinner, as the terminal may not render anything fancier
+		Spinner: spinner.Line,
+	},
+	HighContrast: {
+		Command:      "\033[1;91m",
+		Error:        "\033[1;91m",
+		Success:      "\033[1;92m",
+		Warning:      "\033[1;93m",
+		Info:         "\033[1;96m",
+		Highlight:    "\033[1;95m",
+		Reset:        "\033[0m",
+		Spinner:      spinner.Dot,
+		SpinnerColor: "15",
+		SpinnerBold:  true,
+	},
+}
+
+// Select returns the palette of the named theme, an empty name selects Default
+// NO_COLOR (https://no-color.org) forces Mono, whatever theme is selected
+func Select(name string) (Palette, error) {
+	if name == "" {
+		name = Default
+	}
+	palette, ok := palettes[name]
+	if !ok {
+		return Palette{}, fmt.Errorf("unknown theme %q, expected %s", name, strings.Join(names, ", "))
+	}
+	if os.Getenv("NO_COLOR") != "" {
+		return palettes[Mono], nil
+	}
+	return palette, nil
+}